@@ -1,19 +1,29 @@
 package main
 
 import (
-	"database/sql"
-	"fmt"
+	"context"
+	"crypto/rand"
+	"flag"
 	"net"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/OJOMB/graffiti-berlin-svc/internal/app"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/audit"
 	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/auth"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/config"
 	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/metrics"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/oidc"
 	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/passwords"
-	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/repo"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/ratelimit"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/requestid"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/totp"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/tracing"
 	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/uuidv4"
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 
@@ -21,124 +31,261 @@ import (
 )
 
 const (
-	versionEnv     = "SVC_VERSION"
-	portEnv        = "SVC_PORT"
-	environmentEnv = "SVC_ENVIRONMENT"
-	dbHostEnv      = "DB_HOST"
-	dbPortEnv      = "DB_PORT"
-	dbUserEnv      = "DB_USER"
-	dbPasswordEnv  = "DB_PASSWORD"
-
-	defaultVersion     = "v0.0.0"
-	defaultPort        = 8080
-	defaultHost        = "0.0.0.0"
-	defaultEnvironment = "dev"
-	defaultDBPort      = 3306
-	defaultDBUser      = "root"
-	defaultDBPassword  = "pass"
-
-	dbName  = "graffiti"
+	// each env var with this prefix holds a PEM (or base64 PEM) private key e.g. JWT_KEY_2022_07_01
+	jwtKeyEnvPrefix = "JWT_KEY_"
+
 	appName = "graffiti-berlin-svc"
 
 	passwordGeneratorCost = 15
+
+	tokenExpiresAfter = 12 * time.Hour
+	// accept TOTP codes from one step either side of the current one to allow for clock drift
+	totpSkew = 1
+	devKeyID = "dev"
+
+	rateLimitKeyPrefix = appName + ":ratelimit:"
+
+	// prometheus names can't contain hyphens
+	metricsNamespace = "graffiti_berlin_svc"
+
+	traceFlushTimeout = 5 * time.Second
 )
 
+var defaultRateLimits = app.RateLimits{
+	Default:   ratelimit.Limit{Burst: 120, Period: time.Minute},
+	Expensive: ratelimit.Limit{Burst: 10, Period: time.Minute},
+}
+
 func main() {
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(requestid.LogHook{})
 
-	version := os.Getenv(versionEnv)
-	if version == "" {
-		logger.Info("failed to retrieve app version number from env...using default")
-		version = defaultVersion
-	}
-
-	logger.Infof("app version number %s", version)
-
-	var port int
-	var err error
-	portStr := os.Getenv(portEnv)
-	if portStr == "" {
-		logger.Infof("failed to retrieve app port number from env...using default %d", defaultPort)
-		port = defaultPort
-	} else {
-		port, err = strconv.Atoi(portStr)
+	args := os.Args[1:]
+	var migration *migrateCommand
+	if len(args) > 0 && args[0] == migrateSubcommand {
+		var err error
+		migration, args, err = parseMigrateCommand(args[1:])
 		if err != nil {
-			logger.WithError(err).Fatalf("retrieved invalid service port number from env: %s", portStr)
+			logger.WithError(err).Fatal(migrateUsage)
 		}
 	}
 
-	logger.Infof("app port number %d", port)
-
-	environment := os.Getenv(environmentEnv)
-	if environment == "" {
-		logger.Info("failed to retrieve app environment from env...using default")
-		environment = defaultEnvironment
+	cfg, err := config.Load(appName, args, os.LookupEnv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		logger.WithError(err).Fatal("failed to load config")
 	}
 
-	var docsEnabled bool
-	if environment != "prod" {
-		logger.Info("app docs endpoint enabled")
-		// swaggerUI should not be available in prod environment
-		docsEnabled = true
-	}
+	logger.Infof("effective config:\n%s", cfg.Dump())
 
-	dbHost := os.Getenv(dbHostEnv)
-	if version == "" {
-		logger.Info("failed to retrieve DB host from env...using default")
-		dbHost = defaultHost
-	}
+	if migration != nil {
+		if cfg.Storage.Backend == config.StorageMemory {
+			logger.Fatalf("the %s storage backend has no migrations", config.StorageMemory)
+		}
 
-	var dbPort int
-	dbPortStr := os.Getenv(dbPortEnv)
-	if dbPortStr == "" {
-		logger.Info("failed to retrieve DB host from env...using default")
-		dbPort = defaultDBPort
-	} else {
-		dbPort, err = strconv.Atoi(dbPortStr)
+		db := openDB(logger, cfg)
+		err := migration.run(context.Background(), newMigrator(logger, db, cfg), os.Stdout)
+		db.Close()
 		if err != nil {
-			logger.WithError(err).Fatalf("retrieved invalid DB port number from env: %s", portStr)
+			logger.WithError(err).Fatalf("migrate %s failed", migration.direction)
 		}
-	}
 
-	dbUser := os.Getenv(dbUserEnv)
-	if dbUser == "" {
-		logger.Info("failed to retrieve DB host from env...using default")
-		dbUser = defaultDBUser
+		return
 	}
 
-	dbPassword := os.Getenv(dbPasswordEnv)
-	if dbUser == "" {
-		logger.Info("failed to retrieve DB host from env...using default")
-		dbPassword = defaultDBPassword
+	if cfg.Environment != config.EnvironmentProd {
+		// swaggerUI should not be available in prod environment
+		logger.Info("app docs endpoint enabled")
 	}
 
-	logger.Infof("connecting to DB @ %s:%d as %s", dbHost, dbPort, dbUser)
-
-	// default loc - so UTC
-	dbCnxnStr := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", dbUser, dbPassword, dbHost, dbPort, dbName)
-	db, err := sql.Open("mysql", dbCnxnStr)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, appName, cfg.Version, os.Stdout)
 	if err != nil {
-		logger.WithError(err).Fatalf("failed to establish connection to DB @ %s:%d", dbHost, dbPort)
+		logger.WithError(err).Fatal("failed to set up tracing")
 	}
 
-	defer db.Close()
+	logger.AddHook(tracing.LogHook{})
+
+	svcMetrics := metrics.New(metricsNamespace)
+	store := openStorage(logger, cfg, svcMetrics)
 
-	logger.Info("successfully connected to DB")
+	jwtKeys := loadJWTKeys(logger, cfg.Environment, cfg.JWT)
+	logger.Infof("signing tokens with key %s, accepting keys %v", jwtKeys.Active().ID, jwtKeys.IDs())
+
+	rateLimiter, rateLimitChecks := newRateLimiter(logger, cfg.RateLimit)
+	readinessChecks := append(store.checks, rateLimitChecks...)
 
 	server := app.New(
 		mux.NewRouter(),
-		logger, &net.TCPAddr{IP: net.ParseIP(defaultHost), Port: port},
-		version,
-		environment,
-		auth.NewJWTTool("secretKey", 12*time.Hour, appName),
+		logger, &net.TCPAddr{IP: net.ParseIP(cfg.Server.Host), Port: cfg.Server.Port},
+		cfg.Version,
+		cfg.Environment,
+		auth.NewJWTTool(jwtKeys, tokenExpiresAfter, appName, uuidv4.NewGenerator()),
 		domain.NewService(
 			logger,
-			repo.NewSQLRepo(db, logger),
+			metrics.InstrumentRepo(store.repo, svcMetrics),
 			uuidv4.NewGenerator(),
-			passwords.NewGenerator(passwordGeneratorCost),
+			metrics.InstrumentPasswordTool(passwords.NewGenerator(passwordGeneratorCost), svcMetrics),
+			domain.WithLoginThrottle(
+				metrics.InstrumentLoginAttemptStore(store.loginAttempts, svcMetrics),
+				domain.DefaultAccountThrottlePolicy,
+				domain.DefaultIPThrottlePolicy,
+			),
+			domain.WithAuditor(audit.NewLogAuditor(logger)),
+			domain.WithTOTP(totp.NewTool(appName, totpSkew)),
 		),
+		loadOIDCProviders(logger, cfg.OIDC),
+		app.WithRateLimiting(rateLimiter, defaultRateLimits),
+		app.WithReadinessChecks(readinessChecks...),
+		app.WithMetrics(svcMetrics, &net.TCPAddr{IP: net.ParseIP(cfg.Server.Host), Port: cfg.Server.MetricsPort}),
+		app.WithTracing(appName),
+		app.WithRequestLogging(requestLogging(cfg.Logging)),
+		app.WithCacheControl(app.CacheControl{Users: cfg.Cache.Users}),
+		app.WithTimeouts(serverTimeouts(cfg.Server.Timeouts)),
 	)
 
-	server.Run()
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		logger.Infof("received %s", sig)
+		cancel()
+	}()
+
+	runErr := server.Run(ctx)
+	if runErr != nil {
+		logger.WithError(runErr).Error("server stopped")
+	}
+
+	// only close the DB pool once in-flight requests have finished with it
+	if err := store.close(); err != nil {
+		logger.WithError(err).Error("failed to close DB connections")
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), traceFlushTimeout)
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.WithError(err).Error("failed to flush traces")
+	}
+	cancelFlush()
+
+	if runErr != nil {
+		os.Exit(1)
+	}
+
+	logger.Info("shut down cleanly")
+}
+
+// newRateLimiter uses redis if configured so that all replicas share the same limits,
+// in which case redis is also checked for readiness
+func newRateLimiter(logger *logrus.Logger, cfg config.RateLimit) (*ratelimit.Limiter, []app.DependencyCheck) {
+	redisAddr := cfg.RedisAddr
+	if redisAddr == "" {
+		logger.Info("no rate limit redis address configured...rate limits will be held in memory")
+		return ratelimit.NewLimiter(ratelimit.NewMemoryStore()), nil
+	}
+
+	logger.Infof("rate limits will be held in redis @ %s", redisAddr)
+
+	client := redis.NewClient(&redis.Options{Addr: redisAddr})
+	check := app.DependencyCheck{
+		Name: "cache",
+		Check: func(ctx context.Context) error {
+			return client.Ping(ctx).Err()
+		},
+	}
+
+	return ratelimit.NewLimiter(ratelimit.NewRedisStore(client, rateLimitKeyPrefix)), []app.DependencyCheck{check}
+}
+
+// loadJWTKeys loads the token signing keys from a directory of PEM files if configured, otherwise from env vars.
+// Outside of prod an ephemeral key is generated when none are configured, meaning tokens won't survive a restart
+func loadJWTKeys(logger *logrus.Logger, environment string, cfg config.JWT) *auth.KeySet {
+	activeKeyID := cfg.ActiveKeyID
+
+	if keysDir := cfg.KeysDir; keysDir != "" {
+		keys, err := auth.LoadKeySetFromDir(keysDir, activeKeyID)
+		if err != nil {
+			logger.WithError(err).Fatalf("failed to load JWT signing keys from %s", keysDir)
+		}
+
+		return keys
+	}
+
+	keys, err := auth.LoadKeySetFromEnv(jwtKeyEnvPrefix, activeKeyID)
+	if err == nil {
+		return keys
+	} else if environment == config.EnvironmentProd {
+		logger.WithError(err).Fatal("failed to load JWT signing keys from env")
+	}
+
+	logger.WithError(err).Warn("failed to load JWT signing keys from env...generating ephemeral key")
+	devKey, err := auth.GenerateEd25519Key(devKeyID)
+	if err != nil {
+		logger.WithError(err).Fatal("failed to generate ephemeral JWT signing key")
+	}
+
+	keys, err = auth.NewKeySet(devKeyID, devKey)
+	if err != nil {
+		logger.WithError(err).Fatal("failed to create JWT key set")
+	}
+
+	return keys
+}
+
+// loadOIDCProviders configures social login for each configured provider.
+// Providers whose discovery fails are skipped so that an outage at one provider doesn't stop the service starting
+func loadOIDCProviders(logger *logrus.Logger, cfg config.OIDC) app.Option {
+	stateSecret := []byte(cfg.StateSecret)
+	if len(stateSecret) == 0 {
+		// config validation only allows this in dev
+		logger.Warn("no OIDC state secret configured...generating one, logins in progress won't survive a restart " +
+			"and logins started on one replica will fail on any other")
+		stateSecret = make([]byte, 32)
+		if _, err := rand.Read(stateSecret); err != nil {
+			logger.WithError(err).Fatal("failed to generate OIDC state secret")
+		}
+	}
+
+	var providers []app.OIDCProvider
+	for name, p := range cfg.Providers {
+		provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+			Name:         name,
+			IssuerURL:    p.IssuerURL,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+		}, nil)
+		if err != nil {
+			logger.WithError(err).Errorf("failed to configure OIDC provider %s...skipping", name)
+			continue
+		}
+
+		logger.Infof("OIDC login enabled for provider %s", name)
+		providers = append(providers, provider)
+	}
+
+	return app.WithOIDCProviders(oidc.NewFlowCodec(stateSecret), providers...)
+}
+
+func serverTimeouts(cfg config.ServerTimeouts) app.Timeouts {
+	return app.Timeouts{
+		ReadHeader:    cfg.ReadHeader,
+		Read:          cfg.Read,
+		Write:         cfg.Write,
+		Idle:          cfg.Idle,
+		ShutdownDelay: cfg.ShutdownDelay,
+		Drain:         cfg.Drain,
+	}
+}
+
+// requestLogging applies the configured body logging to the default redaction rules
+func requestLogging(cfg config.Logging) app.RequestLogging {
+	rl := app.DefaultRequestLogging
+	rl.Bodies = app.BodyLogging(cfg.Bodies)
+	rl.SampleRate = cfg.BodySampleRate
+	rl.MaxBodyBytes = cfg.MaxBodyBytes
+
+	return rl
 }
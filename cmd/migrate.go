@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/db"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/config"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/migrate"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	migrateSubcommand = "migrate"
+	migrateUsage      = "usage: migrate up|down [steps]|status [config flags]"
+
+	// long enough to wait out another replica applying a slow migration
+	migrationLockTimeout = 5 * time.Minute
+)
+
+// migrateCommand is parsed from "migrate up", "migrate down [steps]" or "migrate status"
+type migrateCommand struct {
+	direction string
+	steps     int
+}
+
+// parseMigrateCommand returns the command along with the remaining args, which are config flags
+func parseMigrateCommand(args []string) (*migrateCommand, []string, error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("missing migrate command")
+	}
+
+	cmd := &migrateCommand{direction: args[0], steps: 1}
+	args = args[1:]
+
+	switch cmd.direction {
+	case "up", "status":
+	case "down":
+		if len(args) > 0 {
+			if steps, err := strconv.Atoi(args[0]); err == nil {
+				if steps < 1 {
+					return nil, nil, fmt.Errorf("steps must be at least 1, got %d", steps)
+				}
+
+				cmd.steps = steps
+				args = args[1:]
+			}
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown migrate command %q", cmd.direction)
+	}
+
+	return cmd, args, nil
+}
+
+func (c *migrateCommand) run(ctx context.Context, migrator *migrate.Migrator, out io.Writer) error {
+	switch c.direction {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		fmt.Fprintf(out, "applied %d migrations\n", applied)
+		return err
+	case "down":
+		rolledBack, err := migrator.Down(ctx, c.steps)
+		fmt.Fprintf(out, "rolled back %d migrations\n", rolledBack)
+		return err
+	default:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		return writeMigrationStatus(out, statuses)
+	}
+}
+
+func writeMigrationStatus(out io.Writer, statuses []migrate.Status) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		status, appliedAt := "pending", ""
+		if s.Applied {
+			status, appliedAt = "applied", s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+
+		if s.Dirty {
+			status = "dirty"
+		}
+
+		if s.Unknown {
+			status += " (unknown to this release)"
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, status, appliedAt)
+	}
+
+	return w.Flush()
+}
+
+// newMigrator migrates the database of the mysql, postgres or sqlite storage backend
+func newMigrator(logger *logrus.Logger, sqlDB *sql.DB, cfg *config.Config) *migrate.Migrator {
+	var fsys fs.FS
+	var dialect migrate.Dialect
+	switch cfg.Storage.Backend {
+	case config.StoragePostgres:
+		// advisory locks are cluster wide so, as with MySQL, the DB name is part of the lock
+		fsys = db.PostgresMigrations()
+		dialect = migrate.NewPostgres(fmt.Sprintf("%s:%s:migrations", appName, cfg.Postgres.Name), migrationLockTimeout)
+	case config.StorageSQLite:
+		fsys, dialect = db.SQLiteMigrations(), migrate.NewSQLite()
+	default:
+		fsys = db.MySQLMigrations()
+		// GET_LOCK names are server wide so include the DB name, letting services on the same server migrate independently
+		dialect = migrate.NewMySQL(fmt.Sprintf("%s:%s:migrations", appName, cfg.DB.Name), migrationLockTimeout)
+	}
+
+	migrations, err := migrate.Load(fsys)
+	if err != nil {
+		logger.WithError(err).Fatal("failed to load migrations")
+	}
+
+	return migrate.New(sqlDB, dialect, migrations, logger)
+}
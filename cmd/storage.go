@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/app"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/config"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/metrics"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/repo"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/repo/memory"
+	"github.com/sirupsen/logrus"
+)
+
+// login attempts are only needed for as long as the longest throttle window
+const loginAttemptRetention = time.Hour
+
+// sqlStore is implemented by the repos of the mysql, postgres and sqlite backends
+type sqlStore interface {
+	domain.Repo
+	domain.LoginAttemptStore
+	Ping(ctx context.Context) error
+}
+
+// storage is the configured backend along with its readiness checks
+type storage struct {
+	repo          domain.Repo
+	loginAttempts domain.LoginAttemptStore
+	checks        []app.DependencyCheck
+	close         func() error
+}
+
+func openStorage(logger *logrus.Logger, cfg *config.Config, svcMetrics *metrics.Metrics) *storage {
+	if cfg.Storage.Backend == config.StorageMemory {
+		logger.Warn("storing data in memory...everything will be lost on restart")
+		return &storage{
+			repo:          memory.NewRepo(),
+			loginAttempts: repo.NewMemoryLoginAttemptStore(loginAttemptRetention),
+			close:         func() error { return nil },
+		}
+	}
+
+	db := openDB(logger, cfg)
+
+	if cfg.Storage.MigrateOnStart {
+		applied, err := newMigrator(logger, db, cfg).Up(context.Background())
+		if err != nil {
+			logger.WithError(err).Fatal("failed to migrate DB")
+		}
+
+		logger.Infof("applied %d migrations", applied)
+	}
+
+	dbName := cfg.DB.Name
+	switch cfg.Storage.Backend {
+	case config.StoragePostgres:
+		dbName = cfg.Postgres.Name
+	case config.StorageSQLite:
+		dbName = cfg.SQLite.Path
+	}
+
+	if err := svcMetrics.RegisterDBStats(db, dbName); err != nil {
+		logger.WithError(err).Fatal("failed to register DB metrics")
+	}
+
+	var sqlRepo sqlStore
+	switch cfg.Storage.Backend {
+	case config.StoragePostgres:
+		sqlRepo = repo.NewPostgresRepo(db, logger)
+	case config.StorageSQLite:
+		sqlRepo = repo.NewSQLiteRepo(db, logger)
+	default:
+		sqlRepo = repo.NewSQLRepo(db, logger)
+	}
+
+	return &storage{
+		repo:          sqlRepo,
+		loginAttempts: sqlRepo,
+		checks:        []app.DependencyCheck{{Name: "db", Check: sqlRepo.Ping}},
+		close:         db.Close,
+	}
+}
+
+// openDB opens the database of the mysql, postgres or sqlite storage backend
+func openDB(logger *logrus.Logger, cfg *config.Config) *sql.DB {
+	switch cfg.Storage.Backend {
+	case config.StoragePostgres:
+		return openPostgres(logger, cfg.Postgres)
+	case config.StorageSQLite:
+		logger.Infof("opening SQLite DB @ %s", cfg.SQLite.Path)
+
+		db, err := repo.OpenSQLite(cfg.SQLite.Path)
+		if err != nil {
+			logger.WithError(err).Fatalf("failed to open SQLite DB @ %s", cfg.SQLite.Path)
+		}
+
+		return db
+	default:
+		return openMySQL(logger, cfg.DB)
+	}
+}
+
+func openMySQL(logger *logrus.Logger, cfg config.DB) *sql.DB {
+	logger.Infof("connecting to DB @ %s:%d as %s", cfg.Host, cfg.Port, cfg.User)
+
+	// default loc - so UTC
+	dbCnxnStr := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name)
+	db, err := sql.Open("mysql", dbCnxnStr)
+	if err != nil {
+		logger.WithError(err).Fatalf("failed to establish connection to DB @ %s:%d", cfg.Host, cfg.Port)
+	}
+
+	logger.Info("successfully connected to DB")
+
+	return db
+}
+
+func openPostgres(logger *logrus.Logger, cfg config.Postgres) *sql.DB {
+	logger.Infof("connecting to Postgres DB @ %s:%d as %s", cfg.Host, cfg.Port, cfg.User)
+
+	// a URL rather than key=value pairs so that the password is escaped whatever it contains
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Path:     "/" + cfg.Name,
+		RawQuery: url.Values{"sslmode": {cfg.SSLMode}}.Encode(),
+	}
+
+	db, err := sql.Open("pgx", dsn.String())
+	if err != nil {
+		logger.WithError(err).Fatalf("failed to establish connection to Postgres DB @ %s:%d", cfg.Host, cfg.Port)
+	}
+
+	logger.Info("successfully connected to Postgres DB")
+
+	return db
+}
@@ -0,0 +1,33 @@
+// Package db holds the database schema as migrations, embedded so that the service binary can apply them.
+// Each database has its own migrations, named <version>_<name>.up.sql and <version>_<name>.down.sql
+package db
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed migrations/mysql/*.sql migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrations embed.FS
+
+func MySQLMigrations() fs.FS {
+	return sub("migrations/mysql")
+}
+
+func SQLiteMigrations() fs.FS {
+	return sub("migrations/sqlite")
+}
+
+func PostgresMigrations() fs.FS {
+	return sub("migrations/postgres")
+}
+
+func sub(dir string) fs.FS {
+	fsys, err := fs.Sub(migrations, dir)
+	if err != nil {
+		// the path is fixed at compile time so this can't happen
+		panic(err)
+	}
+
+	return fsys
+}
@@ -6,20 +6,74 @@ CREATE TABLE districts (
     PRIMARY KEY (id)
 );
 
+-- password holds a bcrypt hash which is 60 characters
 CREATE TABLE users (
     id varchar(36),
     user_name varchar(255) NOT NULL,
     email varchar(255) NOT NULL,
-    password varchar(40)  NOT NULL,
+    password varchar(255) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     PRIMARY KEY (id),
-    CONSTRAINT uc_username UNIQUE (username),
+    CONSTRAINT uc_user_name UNIQUE (user_name),
     CONSTRAINT uc_email UNIQUE (email)
 );
 
-CREATE INDEX idx_user_email ON users (email);
-CREATE INDEX idx_user_username ON users (username);
+CREATE TABLE user_identities (
+    provider varchar(100) NOT NULL,
+    subject varchar(255) NOT NULL,
+    user_id varchar(36) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, subject),
+    CONSTRAINT fk_user_identities_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_user_identities_user ON user_identities (user_id);
+
+CREATE TABLE user_mfa (
+    user_id varchar(36) NOT NULL,
+    secret varchar(64) NOT NULL,
+    enabled boolean NOT NULL DEFAULT false,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id),
+    CONSTRAINT fk_user_mfa_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE mfa_recovery_codes (
+    user_id varchar(36) NOT NULL,
+    code_hash char(64) NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash),
+    CONSTRAINT fk_mfa_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE api_keys (
+    id varchar(36) NOT NULL,
+    user_id varchar(36) NOT NULL,
+    name varchar(100) NOT NULL,
+    prefix varchar(16) NOT NULL,
+    key_hash char(64) NOT NULL,
+    scopes varchar(255) NOT NULL,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    CONSTRAINT uc_api_keys_key_hash UNIQUE (key_hash),
+    CONSTRAINT fk_api_keys_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_api_keys_user ON api_keys (user_id);
+
+CREATE TABLE login_attempts (
+    attempt_key varchar(300) NOT NULL,
+    failures int NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (attempt_key)
+);
 
 CREATE TABLE artists (
     id varchar(36),
@@ -57,11 +111,19 @@ CREATE TABLE affiliations (
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     PRIMARY KEY (artist, crew),
     CONSTRAINT fk_affiliations_artist FOREIGN KEY (artist) REFERENCES artists(id),
-    CONSTRAINT fk_affiliations_crew FOREIGN KEY (crew) REFERENCES countries(id)
+    CONSTRAINT fk_affiliations_crew FOREIGN KEY (crew) REFERENCES crews(id)
 );
 
 CREATE INDEX idx_affiliations_crew ON affiliations (crew);
 
+CREATE TABLE piece_types (
+    id int AUTO_INCREMENT,
+    name varchar(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+
 CREATE TABLE pieces (
     id varchar(36),
     img varchar(255) NOT NULL,
@@ -73,7 +135,7 @@ CREATE TABLE pieces (
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     PRIMARY KEY (id),
     CONSTRAINT fk_pieces_district FOREIGN KEY (district) REFERENCES districts(id),
-    CONSTRAINT fk_pieces_uploadedBy FOREIGN KEY (uploadedBy) REFERENCES users(id),
+    CONSTRAINT fk_pieces_uploaded_by FOREIGN KEY (uploaded_by) REFERENCES users(id),
     CONSTRAINT fk_pieces_type FOREIGN KEY (type) REFERENCES piece_types(id)
 );
 
@@ -82,37 +144,36 @@ CREATE TABLE duplicates (
     duplicate varchar(36),
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    PRIMARY KEY (original, duplicate)
+    PRIMARY KEY (original, duplicate),
+    CONSTRAINT fk_duplicates_original FOREIGN KEY (original) REFERENCES pieces(id),
+    CONSTRAINT fk_duplicates_duplicate FOREIGN KEY (duplicate) REFERENCES pieces(id)
 );
 
 CREATE TABLE piece_artists (
-    piece int AUTO_INCREMENT,
-    artist varchar(100) NOT NULL,
+    piece varchar(36) NOT NULL,
+    artist varchar(36) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    PRIMARY KEY (piece, artist)
+    PRIMARY KEY (piece, artist),
+    CONSTRAINT fk_piece_artists_piece FOREIGN KEY (piece) REFERENCES pieces(id),
+    CONSTRAINT fk_piece_artists_artist FOREIGN KEY (artist) REFERENCES artists(id)
 );
 
 CREATE TABLE piece_crews (
-    piece int AUTO_INCREMENT,
-    crew varchar(100) NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    PRIMARY KEY (piece, crew)
-);
-
-CREATE TABLE piece_types (
-    id int AUTO_INCREMENT,
-    name varchar(100) NOT NULL,
+    piece varchar(36) NOT NULL,
+    crew varchar(36) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    PRIMARY KEY (id)
+    PRIMARY KEY (piece, crew),
+    CONSTRAINT fk_piece_crews_piece FOREIGN KEY (piece) REFERENCES pieces(id),
+    CONSTRAINT fk_piece_crews_crew FOREIGN KEY (crew) REFERENCES crews(id)
 );
 
 CREATE TABLE piece_tags (
-    piece varchar(36),
+    piece varchar(36) NOT NULL,
     tag varchar(100) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    PRIMARY KEY (piece, tag)
+    PRIMARY KEY (piece, tag),
+    CONSTRAINT fk_piece_tags_piece FOREIGN KEY (piece) REFERENCES pieces(id)
 );
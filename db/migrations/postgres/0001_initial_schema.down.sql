@@ -0,0 +1,20 @@
+DROP TABLE piece_tags;
+DROP TABLE piece_crews;
+DROP TABLE piece_artists;
+DROP TABLE duplicates;
+DROP TABLE pieces;
+DROP TABLE piece_types;
+DROP TABLE affiliations;
+DROP TABLE crews;
+DROP TABLE aliases;
+DROP TABLE artists;
+DROP TABLE login_attempts;
+DROP TABLE api_keys;
+DROP TABLE mfa_recovery_codes;
+DROP TABLE user_mfa;
+DROP TABLE user_identities;
+DROP TABLE users;
+DROP TABLE districts;
+DROP FUNCTION set_piece_district;
+DROP FUNCTION set_updated_at;
+-- the postgis and citext extensions are left in place as other schemas in the database may use them
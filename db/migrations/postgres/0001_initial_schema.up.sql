@@ -0,0 +1,242 @@
+-- locations are geography so that distances are in metres on the spheroid. They are GIST indexed for nearest
+-- neighbour queries ordered by <-> and for containment within district boundaries.
+-- user names and emails are citext so that they are case-insensitive, as they are under MySQL's default collation
+CREATE EXTENSION IF NOT EXISTS postgis;
+CREATE EXTENSION IF NOT EXISTS citext;
+
+-- postgres has no ON UPDATE clause so updated_at is maintained by a trigger on each table that has it
+-- +migrate StatementBegin
+CREATE FUNCTION set_updated_at() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    NEW.updated_at := CURRENT_TIMESTAMP;
+    RETURN NEW;
+END
+$$;
+-- +migrate StatementEnd
+
+CREATE TABLE districts (
+    id integer GENERATED BY DEFAULT AS IDENTITY,
+    name varchar(100) NOT NULL,
+    boundary geography(MultiPolygon, 4326),
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_districts_boundary ON districts USING GIST (boundary);
+
+CREATE TRIGGER trg_districts_updated_at BEFORE UPDATE ON districts FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE users (
+    id varchar(36),
+    user_name citext NOT NULL,
+    email citext NOT NULL,
+    password varchar(255) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    CONSTRAINT uc_user_name UNIQUE (user_name),
+    CONSTRAINT uc_email UNIQUE (email)
+);
+
+CREATE TRIGGER trg_users_updated_at BEFORE UPDATE ON users FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE user_identities (
+    provider varchar(100) NOT NULL,
+    subject varchar(255) NOT NULL,
+    user_id varchar(36) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, subject),
+    CONSTRAINT fk_user_identities_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_user_identities_user ON user_identities (user_id);
+
+CREATE TRIGGER trg_user_identities_updated_at BEFORE UPDATE ON user_identities FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE user_mfa (
+    user_id varchar(36) NOT NULL,
+    secret varchar(64) NOT NULL,
+    enabled boolean NOT NULL DEFAULT false,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id),
+    CONSTRAINT fk_user_mfa_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TRIGGER trg_user_mfa_updated_at BEFORE UPDATE ON user_mfa FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE mfa_recovery_codes (
+    user_id varchar(36) NOT NULL,
+    code_hash varchar(64) NOT NULL,
+    used_at timestamptz NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash),
+    CONSTRAINT fk_mfa_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE api_keys (
+    id varchar(36) NOT NULL,
+    user_id varchar(36) NOT NULL,
+    name varchar(100) NOT NULL,
+    prefix varchar(16) NOT NULL,
+    key_hash varchar(64) NOT NULL,
+    scopes varchar(255) NOT NULL,
+    last_used_at timestamptz NULL,
+    revoked_at timestamptz NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    CONSTRAINT uc_api_keys_key_hash UNIQUE (key_hash),
+    CONSTRAINT fk_api_keys_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_api_keys_user ON api_keys (user_id);
+
+CREATE TABLE login_attempts (
+    attempt_key varchar(300) NOT NULL,
+    failures int NOT NULL,
+    last_failure_at timestamptz NOT NULL,
+    PRIMARY KEY (attempt_key)
+);
+
+CREATE TABLE artists (
+    id varchar(36),
+    name varchar(100) NOT NULL,
+    instagram varchar(255),
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+
+CREATE TRIGGER trg_artists_updated_at BEFORE UPDATE ON artists FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE aliases (
+    artist varchar(36) NOT NULL,
+    alias varchar(36) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (artist, alias),
+    CONSTRAINT fk_aliases_artist FOREIGN KEY (artist) REFERENCES artists(id),
+    CONSTRAINT fk_aliases_alias FOREIGN KEY (alias) REFERENCES artists(id)
+);
+
+CREATE TRIGGER trg_aliases_updated_at BEFORE UPDATE ON aliases FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE crews (
+    id varchar(36),
+    name varchar(100) NOT NULL,
+    acronym varchar(20),
+    instagram varchar(255),
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+
+CREATE TRIGGER trg_crews_updated_at BEFORE UPDATE ON crews FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE affiliations (
+    artist varchar(36) NOT NULL,
+    crew varchar(36) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (artist, crew),
+    CONSTRAINT fk_affiliations_artist FOREIGN KEY (artist) REFERENCES artists(id),
+    CONSTRAINT fk_affiliations_crew FOREIGN KEY (crew) REFERENCES crews(id)
+);
+
+CREATE INDEX idx_affiliations_crew ON affiliations (crew);
+
+CREATE TRIGGER trg_affiliations_updated_at BEFORE UPDATE ON affiliations FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE piece_types (
+    id integer GENERATED BY DEFAULT AS IDENTITY,
+    name varchar(100) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+
+CREATE TRIGGER trg_piece_types_updated_at BEFORE UPDATE ON piece_types FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE pieces (
+    id varchar(36),
+    img varchar(255) NOT NULL,
+    type int NOT NULL,
+    uploaded_by varchar(36) NOT NULL,
+    district int,
+    location geography(Point, 4326),
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_pieces_district FOREIGN KEY (district) REFERENCES districts(id),
+    CONSTRAINT fk_pieces_uploaded_by FOREIGN KEY (uploaded_by) REFERENCES users(id),
+    CONSTRAINT fk_pieces_type FOREIGN KEY (type) REFERENCES piece_types(id)
+);
+
+CREATE INDEX idx_pieces_location ON pieces USING GIST (location);
+CREATE INDEX idx_pieces_district ON pieces (district);
+
+CREATE TRIGGER trg_pieces_updated_at BEFORE UPDATE ON pieces FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+-- a piece's district is whichever district's boundary covers its location, so it is kept in step with the location
+-- +migrate StatementBegin
+CREATE FUNCTION set_piece_district() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    IF NEW.location IS NOT NULL THEN
+        NEW.district := (SELECT id FROM districts WHERE ST_Covers(boundary, NEW.location) ORDER BY id LIMIT 1);
+    END IF;
+    RETURN NEW;
+END
+$$;
+-- +migrate StatementEnd
+
+CREATE TRIGGER trg_pieces_district BEFORE INSERT OR UPDATE OF location ON pieces FOR EACH ROW EXECUTE FUNCTION set_piece_district();
+
+CREATE TABLE duplicates (
+    original varchar(36),
+    duplicate varchar(36),
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (original, duplicate),
+    CONSTRAINT fk_duplicates_original FOREIGN KEY (original) REFERENCES pieces(id),
+    CONSTRAINT fk_duplicates_duplicate FOREIGN KEY (duplicate) REFERENCES pieces(id)
+);
+
+CREATE TRIGGER trg_duplicates_updated_at BEFORE UPDATE ON duplicates FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE piece_artists (
+    piece varchar(36) NOT NULL,
+    artist varchar(36) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (piece, artist),
+    CONSTRAINT fk_piece_artists_piece FOREIGN KEY (piece) REFERENCES pieces(id),
+    CONSTRAINT fk_piece_artists_artist FOREIGN KEY (artist) REFERENCES artists(id)
+);
+
+CREATE TRIGGER trg_piece_artists_updated_at BEFORE UPDATE ON piece_artists FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE piece_crews (
+    piece varchar(36) NOT NULL,
+    crew varchar(36) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (piece, crew),
+    CONSTRAINT fk_piece_crews_piece FOREIGN KEY (piece) REFERENCES pieces(id),
+    CONSTRAINT fk_piece_crews_crew FOREIGN KEY (crew) REFERENCES crews(id)
+);
+
+CREATE TRIGGER trg_piece_crews_updated_at BEFORE UPDATE ON piece_crews FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TABLE piece_tags (
+    piece varchar(36) NOT NULL,
+    tag varchar(100) NOT NULL,
+    created_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (piece, tag),
+    CONSTRAINT fk_piece_tags_piece FOREIGN KEY (piece) REFERENCES pieces(id)
+);
+
+CREATE TRIGGER trg_piece_tags_updated_at BEFORE UPDATE ON piece_tags FOR EACH ROW EXECUTE FUNCTION set_updated_at();
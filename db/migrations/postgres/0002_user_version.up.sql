@@ -0,0 +1,2 @@
+-- version is incremented by every update so that concurrent updates can be detected
+ALTER TABLE users ADD COLUMN version bigint NOT NULL DEFAULT 1;
@@ -0,0 +1,283 @@
+-- SQLite has no ON UPDATE clause so updated_at is maintained by a trigger on each table that has it,
+-- and no spatial types so locations are held as lat/lon columns, indexed for bounding box queries
+
+CREATE TABLE districts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name varchar(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_districts_updated_at AFTER UPDATE ON districts FOR EACH ROW
+BEGIN
+    UPDATE districts SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+-- user names and emails are case-insensitive, as they are under MySQL's default collation
+CREATE TABLE users (
+    id varchar(36),
+    user_name varchar(255) NOT NULL COLLATE NOCASE,
+    email varchar(255) NOT NULL COLLATE NOCASE,
+    password varchar(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    CONSTRAINT uc_user_name UNIQUE (user_name),
+    CONSTRAINT uc_email UNIQUE (email)
+);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_users_updated_at AFTER UPDATE ON users FOR EACH ROW
+BEGIN
+    UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE user_identities (
+    provider varchar(100) NOT NULL,
+    subject varchar(255) NOT NULL,
+    user_id varchar(36) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, subject),
+    CONSTRAINT fk_user_identities_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_user_identities_user ON user_identities (user_id);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_user_identities_updated_at AFTER UPDATE ON user_identities FOR EACH ROW
+BEGIN
+    UPDATE user_identities SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE user_mfa (
+    user_id varchar(36) NOT NULL,
+    secret varchar(64) NOT NULL,
+    enabled boolean NOT NULL DEFAULT false,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id),
+    CONSTRAINT fk_user_mfa_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_user_mfa_updated_at AFTER UPDATE ON user_mfa FOR EACH ROW
+BEGIN
+    UPDATE user_mfa SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE mfa_recovery_codes (
+    user_id varchar(36) NOT NULL,
+    code_hash char(64) NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash),
+    CONSTRAINT fk_mfa_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE api_keys (
+    id varchar(36) NOT NULL,
+    user_id varchar(36) NOT NULL,
+    name varchar(100) NOT NULL,
+    prefix varchar(16) NOT NULL,
+    key_hash char(64) NOT NULL,
+    scopes varchar(255) NOT NULL,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    CONSTRAINT uc_api_keys_key_hash UNIQUE (key_hash),
+    CONSTRAINT fk_api_keys_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_api_keys_user ON api_keys (user_id);
+
+CREATE TABLE login_attempts (
+    attempt_key varchar(300) NOT NULL,
+    failures int NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (attempt_key)
+);
+
+CREATE TABLE artists (
+    id varchar(36),
+    name varchar(100) NOT NULL,
+    instagram varchar(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_artists_updated_at AFTER UPDATE ON artists FOR EACH ROW
+BEGIN
+    UPDATE artists SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE aliases (
+    artist varchar(36) NOT NULL,
+    alias varchar(36) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (artist, alias),
+    CONSTRAINT fk_aliases_artist FOREIGN KEY (artist) REFERENCES artists(id),
+    CONSTRAINT fk_aliases_alias FOREIGN KEY (alias) REFERENCES artists(id)
+);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_aliases_updated_at AFTER UPDATE ON aliases FOR EACH ROW
+BEGIN
+    UPDATE aliases SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE crews (
+    id varchar(36),
+    name varchar(100) NOT NULL,
+    acronym varchar(20),
+    instagram varchar(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_crews_updated_at AFTER UPDATE ON crews FOR EACH ROW
+BEGIN
+    UPDATE crews SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE affiliations (
+    artist varchar(36) NOT NULL,
+    crew varchar(36) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (artist, crew),
+    CONSTRAINT fk_affiliations_artist FOREIGN KEY (artist) REFERENCES artists(id),
+    CONSTRAINT fk_affiliations_crew FOREIGN KEY (crew) REFERENCES crews(id)
+);
+
+CREATE INDEX idx_affiliations_crew ON affiliations (crew);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_affiliations_updated_at AFTER UPDATE ON affiliations FOR EACH ROW
+BEGIN
+    UPDATE affiliations SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE piece_types (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name varchar(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_piece_types_updated_at AFTER UPDATE ON piece_types FOR EACH ROW
+BEGIN
+    UPDATE piece_types SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+-- a location is both lat and lon or neither
+CREATE TABLE pieces (
+    id varchar(36),
+    img varchar(255) NOT NULL,
+    type int NOT NULL,
+    uploaded_by varchar(36) NOT NULL,
+    district int,
+    lat double CHECK (lat BETWEEN -90 AND 90),
+    lon double CHECK (lon BETWEEN -180 AND 180),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    CONSTRAINT ck_pieces_location CHECK ((lat IS NULL) = (lon IS NULL)),
+    CONSTRAINT fk_pieces_district FOREIGN KEY (district) REFERENCES districts(id),
+    CONSTRAINT fk_pieces_uploaded_by FOREIGN KEY (uploaded_by) REFERENCES users(id),
+    CONSTRAINT fk_pieces_type FOREIGN KEY (type) REFERENCES piece_types(id)
+);
+
+CREATE INDEX idx_pieces_location ON pieces (lat, lon);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_pieces_updated_at AFTER UPDATE ON pieces FOR EACH ROW
+BEGIN
+    UPDATE pieces SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE duplicates (
+    original varchar(36),
+    duplicate varchar(36),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (original, duplicate),
+    CONSTRAINT fk_duplicates_original FOREIGN KEY (original) REFERENCES pieces(id),
+    CONSTRAINT fk_duplicates_duplicate FOREIGN KEY (duplicate) REFERENCES pieces(id)
+);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_duplicates_updated_at AFTER UPDATE ON duplicates FOR EACH ROW
+BEGIN
+    UPDATE duplicates SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE piece_artists (
+    piece varchar(36) NOT NULL,
+    artist varchar(36) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (piece, artist),
+    CONSTRAINT fk_piece_artists_piece FOREIGN KEY (piece) REFERENCES pieces(id),
+    CONSTRAINT fk_piece_artists_artist FOREIGN KEY (artist) REFERENCES artists(id)
+);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_piece_artists_updated_at AFTER UPDATE ON piece_artists FOR EACH ROW
+BEGIN
+    UPDATE piece_artists SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE piece_crews (
+    piece varchar(36) NOT NULL,
+    crew varchar(36) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (piece, crew),
+    CONSTRAINT fk_piece_crews_piece FOREIGN KEY (piece) REFERENCES pieces(id),
+    CONSTRAINT fk_piece_crews_crew FOREIGN KEY (crew) REFERENCES crews(id)
+);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_piece_crews_updated_at AFTER UPDATE ON piece_crews FOR EACH ROW
+BEGIN
+    UPDATE piece_crews SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE piece_tags (
+    piece varchar(36) NOT NULL,
+    tag varchar(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (piece, tag),
+    CONSTRAINT fk_piece_tags_piece FOREIGN KEY (piece) REFERENCES pieces(id)
+);
+
+-- +migrate StatementBegin
+CREATE TRIGGER trg_piece_tags_updated_at AFTER UPDATE ON piece_tags FOR EACH ROW
+BEGIN
+    UPDATE piece_tags SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+-- +migrate StatementEnd
@@ -1,9 +1,14 @@
 package app
 
 import (
+	"context"
+	"fmt"
 	"net"
 	"net/http"
+	"sync/atomic"
+	"time"
 
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/oidc"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
@@ -13,30 +18,171 @@ const (
 	appHandler   = "handler"
 )
 
+// Timeouts bound how long the server spends on each connection, and on shutting down
+type Timeouts struct {
+	ReadHeader time.Duration
+	// Read and Write must allow for the slowest uploads and downloads
+	Read  time.Duration
+	Write time.Duration
+	Idle  time.Duration
+	// ShutdownDelay is how long the server keeps accepting requests after reporting it is not ready,
+	// giving load balancers time to stop sending it new ones
+	ShutdownDelay time.Duration
+	// Drain is how long in-flight requests have to finish once the server stops accepting new ones
+	Drain time.Duration
+}
+
+// DefaultTimeouts match config.Default and fit within the 30 second grace period Kubernetes gives pods to terminate
+var DefaultTimeouts = Timeouts{
+	ReadHeader:    10 * time.Second,
+	Read:          2 * time.Minute,
+	Write:         2 * time.Minute,
+	Idle:          2 * time.Minute,
+	ShutdownDelay: 5 * time.Second,
+	Drain:         20 * time.Second,
+}
+
 type App struct {
-	router  *mux.Router
-	logger  *logrus.Entry
-	addr    net.Addr
-	version string
-	env     string
+	router   *mux.Router
+	logger   *logrus.Entry
+	addr     net.Addr
+	version  string
+	env      string
+	timeouts Timeouts
+	// ready is 1 while the app is serving and not shutting down
+	ready           int32
+	readinessChecks []DependencyCheck
 
 	tokenAuth TokenAuth
 	service   Service
+
+	oidcProviders map[string]OIDCProvider
+	oidcFlows     *oidc.FlowCodec
+
+	rateLimiter *RateLimiter
+	metrics     Metrics
+	metricsAddr net.Addr
+
+	tracingServiceName string
+	requestLogging     RequestLogging
+	cacheControl       CacheControl
+}
+
+// Option configures optional App functionality
+type Option func(*App)
+
+// WithTimeouts overrides DefaultTimeouts
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(app *App) {
+		app.timeouts = timeouts
+	}
 }
 
-func New(r *mux.Router, logger *logrus.Logger, addr net.Addr, version string, env string, auth TokenAuth, svc Service) *App {
-	return &App{
-		router:    r,
-		logger:    logger.WithField("component", componentApp),
-		addr:      addr,
-		version:   version,
-		env:       env,
-		tokenAuth: auth,
-		service:   svc,
+// WithTracing starts a span for each request, continuing any trace propagated by the caller through the traceparent header.
+// Spans go to the global tracer provider
+func WithTracing(serviceName string) Option {
+	return func(app *App) {
+		app.tracingServiceName = serviceName
+	}
+}
+
+func New(r *mux.Router, logger *logrus.Logger, addr net.Addr, version string, env string, auth TokenAuth, svc Service, opts ...Option) *App {
+	app := &App{
+		router:         r,
+		logger:         logger.WithField("component", componentApp),
+		addr:           addr,
+		version:        version,
+		env:            env,
+		timeouts:       DefaultTimeouts,
+		requestLogging: DefaultRequestLogging,
+		cacheControl:   DefaultCacheControl,
+		tokenAuth:      auth,
+		service:        svc,
+		oidcProviders:  map[string]OIDCProvider{},
+	}
+
+	for _, opt := range opts {
+		opt(app)
 	}
+
+	return app
 }
 
-func (app *App) Run() {
+// Run listens on the app's address and serves until ctx is cancelled, see Serve.
+// Metrics are served on their own address until then, see WithMetrics
+func (app *App) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", app.addr.String())
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", app.addr, err)
+	}
+
+	if app.metrics != nil && app.metricsAddr != nil {
+		ml, err := net.Listen("tcp", app.metricsAddr.String())
+		if err != nil {
+			l.Close()
+			return fmt.Errorf("failed to listen on %s: %v", app.metricsAddr, err)
+		}
+
+		metricsSrv := &http.Server{Handler: app.metricsHandler(), ReadHeaderTimeout: app.timeouts.ReadHeader}
+		go func() {
+			if err := metricsSrv.Serve(ml); err != http.ErrServerClosed {
+				app.logger.WithError(err).Error("metrics server stopped unexpectedly")
+			}
+		}()
+
+		// metrics are still scraped while requests drain
+		defer metricsSrv.Close()
+		app.logger.Infof("serving metrics on %s", ml.Addr())
+	}
+
+	return app.Serve(ctx, l)
+}
+
+// Serve serves on the listener until ctx is cancelled. It then reports it is not ready, waits for the shutdown delay,
+// stops accepting connections and waits for in-flight requests to finish before returning
+func (app *App) Serve(ctx context.Context, l net.Listener) error {
 	app.routes()
-	app.logger.Fatal(http.ListenAndServe(app.addr.String(), app.router))
+
+	srv := &http.Server{
+		Handler:           app.router,
+		ReadHeaderTimeout: app.timeouts.ReadHeader,
+		ReadTimeout:       app.timeouts.Read,
+		WriteTimeout:      app.timeouts.Write,
+		IdleTimeout:       app.timeouts.Idle,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(l)
+	}()
+
+	atomic.StoreInt32(&app.ready, 1)
+	app.logger.Infof("serving on %s", l.Addr())
+
+	select {
+	case err := <-serveErr:
+		atomic.StoreInt32(&app.ready, 0)
+		return fmt.Errorf("server stopped unexpectedly: %v", err)
+	case <-ctx.Done():
+	}
+
+	app.logger.Infof("shutting down, draining requests for up to %s", app.timeouts.ShutdownDelay+app.timeouts.Drain)
+	atomic.StoreInt32(&app.ready, 0)
+	time.Sleep(app.timeouts.ShutdownDelay)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), app.timeouts.Drain)
+	defer cancel()
+
+	if err := srv.Shutdown(drainCtx); err != nil {
+		return fmt.Errorf("failed to drain in-flight requests: %v", err)
+	}
+
+	app.logger.Info("all requests drained")
+
+	return nil
+}
+
+// isReady reports whether the app should be sent traffic
+func (app *App) isReady() bool {
+	return atomic.LoadInt32(&app.ready) == 1
 }
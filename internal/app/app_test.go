@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/config"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestServe_gracefulShutdown(t *testing.T) {
+	timeouts := DefaultTimeouts
+	timeouts.ShutdownDelay = 200 * time.Millisecond
+	timeouts.Drain = 2 * time.Second
+
+	router := mux.NewRouter()
+	app := New(router, nullLogger(), nil, "", "", nil, nil, WithTimeouts(timeouts))
+
+	requestStarted := make(chan struct{})
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(requestStarted)
+		time.Sleep(500 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	baseURL := "http://" + l.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- app.Serve(ctx, l)
+	}()
+
+	require.Eventually(t, app.isReady, time.Second, 10*time.Millisecond)
+	resp, err := http.Get(baseURL + "/readyz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	slowResp := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(baseURL + "/slow")
+		if err != nil {
+			slowResp <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		slowResp <- string(body)
+	}()
+
+	<-requestStarted
+	cancel()
+
+	// during the shutdown delay the app still serves but reports it is not ready
+	require.Eventually(t, func() bool { return !app.isReady() }, time.Second, 10*time.Millisecond)
+	resp, err = http.Get(baseURL + "/readyz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// the in-flight request is allowed to finish
+	assert.Equal(t, "done", <-slowResp)
+	assert.NoError(t, <-served)
+
+	_, err = http.Get(baseURL + "/readyz")
+	assert.Error(t, err)
+}
+
+func TestWithTracing_continuesPropagatedTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	router := mux.NewRouter()
+	app := New(router, nullLogger(), nil, "", "", nil, nil, WithTracing("test-svc"))
+	app.routes()
+
+	var handlerSpan trace.SpanContext
+	router.HandleFunc("/traced/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/traced/123", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", handlerSpan.TraceID().String())
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "/traced/{id}", spans[0].Name())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+}
+
+func TestRoutes_docsHiddenInProd(t *testing.T) {
+	testCases := []struct {
+		env         string
+		docsMounted bool
+	}{
+		{env: config.EnvironmentDev, docsMounted: true},
+		{env: config.EnvironmentStaging, docsMounted: true},
+		{env: config.EnvironmentProd, docsMounted: false},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.env), func(t *testing.T) {
+			app := New(mux.NewRouter(), nullLogger(), nil, "", tc.env, nil, nil)
+			app.routes()
+
+			r := httptest.NewRequest(http.MethodGet, "/docs/swagger.yaml", nil)
+			assert.Equal(t, tc.docsMounted, app.router.Match(r, &mux.RouteMatch{}))
+		})
+	}
+}
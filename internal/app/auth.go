@@ -5,12 +5,20 @@ import "github.com/OJOMB/graffiti-berlin-svc/internal/pkg/auth"
 type TokenAuth interface {
 	TokenGenerator
 	TokenDecoder
+	KeyPublisher
 }
 
 type TokenGenerator interface {
 	GenerateTokenString(userID string) (string, error)
+	GenerateMFAChallengeTokenString(userID string) (string, error)
 }
 
 type TokenDecoder interface {
-	GetClaims(tokenString string) (auth.JWTClaims, error)
+	GetClaims(tokenString string) (*auth.JWTClaims, error)
+	GetMFAChallengeClaims(tokenString string) (*auth.JWTClaims, error)
+}
+
+// KeyPublisher exposes the public keys tokens can be verified with, so other services don't need a shared secret
+type KeyPublisher interface {
+	JWKS() auth.JWKS
 }
@@ -0,0 +1,17 @@
+package app
+
+import (
+	"net"
+	"net/http"
+)
+
+// clientIP returns the IP of the peer that sent the request.
+// Forwarding headers are ignored as they are trivially spoofed unless set by a proxy we control
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
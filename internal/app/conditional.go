@@ -0,0 +1,61 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CacheControl is the Cache-Control header sent with each cacheable resource, left unset when empty.
+// The responses also carry an ETag and Last-Modified so that clients can revalidate them with a conditional GET
+type CacheControl struct {
+	// Users hold email addresses so shared caches must not store them
+	Users string
+}
+
+// DefaultCacheControl lets clients keep resources but revalidate them before each use
+var DefaultCacheControl = CacheControl{
+	Users: "private, no-cache",
+}
+
+// WithCacheControl overrides DefaultCacheControl
+func WithCacheControl(cfg CacheControl) Option {
+	return func(app *App) {
+		app.cacheControl = cfg
+	}
+}
+
+// setCacheHeaders sets the headers that a response and any 304 in its place must both carry
+func setCacheHeaders(w http.ResponseWriter, cacheControl, etag string, lastModified time.Time) {
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified reports whether the request's conditional headers show the client already has the current representation.
+// If-None-Match uses weak comparison and takes precedence, If-Modified-Since is only checked when it is absent
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if header := strings.Join(r.Header.Values("If-None-Match"), ","); header != "" {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+
+	// Last-Modified only has second precision
+	return !lastModified.Truncate(time.Second).After(since)
+}
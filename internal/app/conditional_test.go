@@ -0,0 +1,70 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotModified(t *testing.T) {
+	lastModified := time.Date(2021, 6, 1, 12, 0, 0, 500, time.UTC)
+
+	testCases := []struct {
+		name         string
+		headers      map[string]string
+		lastModified time.Time
+		expected     bool
+	}{
+		{name: "no conditional headers", headers: map[string]string{}, lastModified: lastModified, expected: false},
+		{name: "etag matches", headers: map[string]string{"If-None-Match": `"2"`}, lastModified: lastModified, expected: true},
+		{name: "etag in list", headers: map[string]string{"If-None-Match": `"1", "2"`}, lastModified: lastModified, expected: true},
+		{name: "weak etag matches", headers: map[string]string{"If-None-Match": `W/"2"`}, lastModified: lastModified, expected: true},
+		{name: "any etag", headers: map[string]string{"If-None-Match": "*"}, lastModified: lastModified, expected: true},
+		{name: "etag does not match", headers: map[string]string{"If-None-Match": `"1"`}, lastModified: lastModified, expected: false},
+		{
+			name:         "If-None-Match takes precedence",
+			headers:      map[string]string{"If-None-Match": `"1"`, "If-Modified-Since": "Wed, 02 Jun 2021 12:00:00 GMT"},
+			lastModified: lastModified,
+			expected:     false,
+		},
+		{
+			name:         "not modified since, ignoring sub-second precision",
+			headers:      map[string]string{"If-Modified-Since": "Tue, 01 Jun 2021 12:00:00 GMT"},
+			lastModified: lastModified,
+			expected:     true,
+		},
+		{
+			name:         "modified since",
+			headers:      map[string]string{"If-Modified-Since": "Tue, 01 Jun 2021 11:59:59 GMT"},
+			lastModified: lastModified,
+			expected:     false,
+		},
+		{
+			name:         "malformed date",
+			headers:      map[string]string{"If-Modified-Since": "yesterday"},
+			lastModified: lastModified,
+			expected:     false,
+		},
+		{
+			name:         "unknown last modified",
+			headers:      map[string]string{"If-Modified-Since": "Tue, 01 Jun 2021 12:00:00 GMT"},
+			lastModified: time.Time{},
+			expected:     false,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			assert.Equal(t, tc.expected, notModified(r, `"2"`, tc.lastModified))
+		})
+	}
+}
@@ -1,55 +1,176 @@
 package app
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
 	"net/http"
 
 	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/requestid"
+	"github.com/sirupsen/logrus"
 )
 
+const (
+	contentTypeProblemJSON = "application/problem+json"
+	// problemTypeBase is where each error code is documented, the code is appended as the fragment
+	problemTypeBase = "https://github.com/OJOMB/graffiti-berlin-svc/blob/main/docs/errors.md#"
+)
+
+// machine readable error codes. Clients rely on these so they must never change
+const (
+	errCodeInvalidRequest       = "invalid_request"
+	errCodeInvalidInput         = "invalid_input"
+	errCodeUnauthorized         = "unauthorized"
+	errCodeForbidden            = "forbidden"
+	errCodeNotFound             = "resource_not_found"
+	errCodeConflict             = "resource_conflict"
+	errCodePreconditionRequired = "precondition_required"
+	errCodePreconditionFailed   = "precondition_failed"
+	errCodeUnsupportedMediaType = "unsupported_media_type"
+	errCodeTooManyRequests      = "too_many_requests"
+	errCodeSystemError          = "system_error"
+	errCodeNotImplemented       = "not_implemented"
+)
+
+var errCodeTitles = map[string]string{
+	errCodeInvalidRequest:       "Request is malformed",
+	errCodeInvalidInput:         "Invalid input data",
+	errCodeUnauthorized:         "Unauthorized",
+	errCodeForbidden:            "Forbidden",
+	errCodeNotFound:             "Resource not found",
+	errCodeConflict:             "Resource state conflict",
+	errCodePreconditionRequired: "Precondition required",
+	errCodePreconditionFailed:   "Precondition failed",
+	errCodeUnsupportedMediaType: "Unsupported media type",
+	errCodeTooManyRequests:      "Too many requests",
+	errCodeSystemError:          "Unexpected system error",
+	errCodeNotImplemented:       "Not implemented",
+}
+
 type appErr struct {
-	msg  string
-	code int
+	msg     string
+	status  int
+	errCode string
+	fields  []domain.FieldError
+}
+
+// problem is an RFC 7807 problem details object https://datatracker.ietf.org/doc/html/rfc7807
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the request ID, which can be used to find the request in the logs
+	Instance string              `json:"instance,omitempty"`
+	Code     string              `json:"code"`
+	Errors   []domain.FieldError `json:"errors,omitempty"`
 }
 
 var svcErrMessagesToStatusCodes = map[domain.ErrorType]int{
-	domain.InvalidInput:     http.StatusBadRequest,
-	domain.ResourceNotFound: http.StatusNotFound,
-	domain.SystemError:      http.StatusInternalServerError,
-	domain.ResourceConflict: http.StatusConflict,
-	domain.Unauthorized:     http.StatusUnauthorized,
-	domain.NotImplemented:   http.StatusNotImplemented,
+	domain.InvalidInput:       http.StatusBadRequest,
+	domain.ResourceNotFound:   http.StatusNotFound,
+	domain.SystemError:        http.StatusInternalServerError,
+	domain.ResourceConflict:   http.StatusConflict,
+	domain.Unauthorized:       http.StatusUnauthorized,
+	domain.NotImplemented:     http.StatusNotImplemented,
+	domain.TooManyRequests:    http.StatusTooManyRequests,
+	domain.PreconditionFailed: http.StatusPreconditionFailed,
+}
+
+var svcErrTypesToErrCodes = map[domain.ErrorType]string{
+	domain.InvalidInput:       errCodeInvalidInput,
+	domain.ResourceNotFound:   errCodeNotFound,
+	domain.SystemError:        errCodeSystemError,
+	domain.ResourceConflict:   errCodeConflict,
+	domain.Unauthorized:       errCodeUnauthorized,
+	domain.NotImplemented:     errCodeNotImplemented,
+	domain.TooManyRequests:    errCodeTooManyRequests,
+	domain.PreconditionFailed: errCodePreconditionFailed,
 }
 
-func newAppErr(errMsg string, code int) *appErr {
-	return &appErr{msg: errMsg, code: code}
+// statusCodesToErrCodes gives the code for errors raised by the app layer itself
+var statusCodesToErrCodes = map[int]string{
+	http.StatusBadRequest:           errCodeInvalidRequest,
+	http.StatusUnauthorized:         errCodeUnauthorized,
+	http.StatusForbidden:            errCodeForbidden,
+	http.StatusNotFound:             errCodeNotFound,
+	http.StatusConflict:             errCodeConflict,
+	http.StatusPreconditionFailed:   errCodePreconditionFailed,
+	http.StatusPreconditionRequired: errCodePreconditionRequired,
+	http.StatusUnsupportedMediaType: errCodeUnsupportedMediaType,
+	http.StatusTooManyRequests:      errCodeTooManyRequests,
+	http.StatusInternalServerError:  errCodeSystemError,
+	http.StatusNotImplemented:       errCodeNotImplemented,
 }
 
-func (app *App) newAppErrFromDomainErr(dErr *domain.Error) *appErr {
-	code, ok := svcErrMessagesToStatusCodes[dErr.Code]
+func newAppErr(errMsg string, status int) *appErr {
+	errCode, ok := statusCodesToErrCodes[status]
 	if !ok {
-		app.logger.Warnf("unknown domain error type: %v", dErr.Code.String())
+		errCode = errCodeSystemError
+	}
+
+	return &appErr{msg: errMsg, status: status, errCode: errCode}
+}
+
+func (app *App) newAppErrFromDomainErr(ctx context.Context, dErr *domain.Error) *appErr {
+	if app.metrics != nil {
+		app.metrics.CountDomainError(dErr.Code)
+	}
+
+	return appErrFromDomainErr(app.logger.WithContext(ctx), dErr)
+}
+
+// appErrFromDomainErr is for middleware, which has no App to call newAppErrFromDomainErr on
+func appErrFromDomainErr(logger *logrus.Entry, dErr *domain.Error) *appErr {
+	status, ok := svcErrMessagesToStatusCodes[dErr.Code]
+	if !ok {
+		logger.Warnf("unknown domain error type: %v", dErr.Code.String())
 		// in this case default to system error 500
-		return &appErr{
-			msg:  dErr.Error(),
-			code: http.StatusInternalServerError,
-		}
+		return &appErr{msg: dErr.Msg, status: http.StatusInternalServerError, errCode: errCodeSystemError}
+	}
+
+	msg := dErr.Msg
+	if dErr.Code == domain.SystemError {
+		// the underlying error may reveal implementation details so only goes to the logs
+		logger.WithError(dErr).Error("domain system error")
+	} else if dErr.Err != nil && len(dErr.Fields) == 0 {
+		msg += ": " + dErr.Err.Error()
 	}
 
 	return &appErr{
-		msg:  dErr.Error(),
-		code: code,
+		msg:     msg,
+		status:  status,
+		errCode: svcErrTypesToErrCodes[dErr.Code],
+		fields:  dErr.Fields,
 	}
 }
 
 func (apperr *appErr) Error() string {
-	return fmt.Sprintf(`{"error": "%s"}`, apperr.msg)
+	return apperr.msg
 }
 
 func (apperr *appErr) Code() int {
-	return apperr.code
+	return apperr.status
 }
 
-func httpErrorFromAppErr(w http.ResponseWriter, appErr *appErr) {
-	http.Error(w, appErr.Error(), appErr.Code())
+// httpErrorFromAppErr writes the error as a problem+json response
+func httpErrorFromAppErr(w http.ResponseWriter, r *http.Request, apperr *appErr) {
+	body, err := json.Marshal(problem{
+		Type:     problemTypeBase + apperr.errCode,
+		Title:    errCodeTitles[apperr.errCode],
+		Status:   apperr.status,
+		Detail:   apperr.msg,
+		Instance: requestid.FromContext(r.Context()),
+		Code:     apperr.errCode,
+		Errors:   apperr.fields,
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeProblemJSON)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apperr.status)
+	w.Write(body)
 }
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/requestid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpErrorFromAppErr(t *testing.T) {
+	app := New(nil, nullLogger(), nil, "", "", nil, nil)
+
+	dErr := &domain.Error{
+		Code:   domain.InvalidInput,
+		Msg:    `user "bob" is invalid`,
+		Fields: []domain.FieldError{{Field: "email", Rule: domain.RuleRequired, Message: "must not be empty"}},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/users", nil)
+	r = r.WithContext(requestid.NewContext(r.Context(), "a4b6d1b2-9f5e-4c8e-8a55-d8c5e4b4b3b1"))
+
+	httpErrorFromAppErr(w, r, app.newAppErrFromDomainErr(r.Context(), dErr))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "https://github.com/OJOMB/graffiti-berlin-svc/blob/main/docs/errors.md#invalid_input",
+		"title": "Invalid input data",
+		"status": 400,
+		"detail": "user \"bob\" is invalid",
+		"instance": "a4b6d1b2-9f5e-4c8e-8a55-d8c5e4b4b3b1",
+		"code": "invalid_input",
+		"errors": [{"field": "email", "rule": "required", "message": "must not be empty"}]
+	}`, w.Body.String())
+}
+
+func TestNewAppErrFromDomainErr_hidesSystemErrorCause(t *testing.T) {
+	app := New(nil, nullLogger(), nil, "", "", nil, nil)
+
+	apperr := app.newAppErrFromDomainErr(context.Background(), &domain.Error{Code: domain.SystemError, Msg: "failed to retrieve user", Err: assert.AnError})
+	assert.Equal(t, "failed to retrieve user", apperr.msg)
+	assert.Equal(t, errCodeSystemError, apperr.errCode)
+
+	apperr = app.newAppErrFromDomainErr(context.Background(), &domain.Error{Code: domain.InvalidInput, Msg: "patch could not be decoded", Err: assert.AnError})
+	assert.Equal(t, "patch could not be decoded: "+assert.AnError.Error(), apperr.msg)
+}
@@ -0,0 +1,35 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// formatETag makes a strong entity tag from a resource's version
+func formatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatchVersions returns the versions in the request's If-Match headers, or nil for * which matches any version.
+// If-Match uses strong comparison, so weak and malformed tags are left out as they can never match
+func ifMatchVersions(r *http.Request) []int64 {
+	header := strings.Join(r.Header.Values("If-Match"), ",")
+	if strings.TrimSpace(header) == "*" {
+		return nil
+	}
+
+	versions := []int64{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+
+		if v, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64); err == nil {
+			versions = append(versions, v)
+		}
+	}
+
+	return versions
+}
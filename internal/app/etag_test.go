@@ -0,0 +1,39 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfMatchVersions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		headers  []string
+		expected []int64
+	}{
+		{name: "single tag", headers: []string{`"3"`}, expected: []int64{3}},
+		{name: "list of tags", headers: []string{`"3", "4"`}, expected: []int64{3, 4}},
+		{name: "repeated headers", headers: []string{`"3"`, `"4"`}, expected: []int64{3, 4}},
+		{name: "any", headers: []string{"*"}, expected: nil},
+		{name: "weak tags never match", headers: []string{`W/"3"`}, expected: []int64{}},
+		{name: "malformed tags never match", headers: []string{`3, "x", "`}, expected: []int64{}},
+		{name: "malformed tags are skipped", headers: []string{`"x", "5"`}, expected: []int64{5}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/", nil)
+			for _, h := range tc.headers {
+				r.Header.Add("If-Match", h)
+			}
+
+			assert.Equal(t, tc.expected, ifMatchVersions(r))
+		})
+	}
+}
+
+func TestFormatETag(t *testing.T) {
+	assert.Equal(t, `"12"`, formatETag(12))
+}
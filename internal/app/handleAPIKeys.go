@@ -0,0 +1,117 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/gorilla/mux"
+)
+
+const (
+	handlerCreateAPIKey = "handleCreateAPIKey"
+	handlerListAPIKeys  = "handleListAPIKeys"
+)
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// createAPIKeyResponse is the only time the plaintext key is returned
+type createAPIKeyResponse struct {
+	*domain.APIKey
+	Key string `json:"key"`
+}
+
+// handleCreateAPIKey creates a personal API key for the authenticated user
+func (app *App) handleCreateAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)[urlVarUserID]
+		if !requireUserSession(w, r, userID) {
+			return
+		}
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			apperr := newAppErr("request body unreadable", http.StatusBadRequest)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		defer r.Body.Close()
+
+		var reqBodyData createAPIKeyRequest
+		if err := json.Unmarshal(reqBody, &reqBodyData); err != nil {
+			apperr := newAppErr("invalid json in request body", http.StatusBadRequest)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		key, plaintext, dErr := app.service.CreateAPIKey(r.Context(), userID, reqBodyData.Name, reqBodyData.Scopes)
+		if dErr != nil {
+			apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		respBytes, err := json.Marshal(createAPIKeyResponse{APIKey: key, Key: plaintext})
+		if err != nil {
+			app.logger.WithContext(r.Context()).WithField(appHandler, handlerCreateAPIKey).WithError(err).Error("failed to marshal json response")
+			apperr := newAppErr("failed to marshal json response", http.StatusInternalServerError)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(respBytes)
+	}
+}
+
+// handleListAPIKeys lists the authenticated user's API keys. The keys themselves are never returned, only their prefixes
+func (app *App) handleListAPIKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)[urlVarUserID]
+		if !requireUserSession(w, r, userID) {
+			return
+		}
+
+		keys, dErr := app.service.ListAPIKeys(r.Context(), userID)
+		if dErr != nil {
+			apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		respBytes, err := json.Marshal(keys)
+		if err != nil {
+			app.logger.WithContext(r.Context()).WithField(appHandler, handlerListAPIKeys).WithError(err).Error("failed to marshal json response")
+			apperr := newAppErr("failed to marshal json response", http.StatusInternalServerError)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		w.Write(respBytes)
+	}
+}
+
+// handleRevokeAPIKey revokes one of the authenticated user's API keys
+func (app *App) handleRevokeAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		userID := vars[urlVarUserID]
+		if !requireUserSession(w, r, userID) {
+			return
+		}
+
+		if dErr := app.service.RevokeAPIKey(r.Context(), userID, vars[urlVarAPIKeyID]); dErr != nil {
+			apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
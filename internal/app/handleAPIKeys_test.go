@@ -0,0 +1,238 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandleCreateAPIKey_successPath(t *testing.T) {
+	ms := &mockService{}
+	ma := &mockAuth{}
+	app := New(mux.NewRouter(), nullLogger(), nil, "", "", ma, ms)
+	app.routes()
+
+	key := &domain.APIKey{
+		ID:        "4d0e2d7e-8f2c-4a39-9a0a-0ad3e6d9b6f1",
+		UserID:    mfaTestUserID,
+		Name:      "mapping script",
+		Prefix:    "gbs_abcdefgh",
+		Scopes:    []string{domain.ScopePiecesRead},
+		CreatedAt: time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC),
+	}
+	ma.On("GetClaims", "token").Return(claimsFor(mfaTestUserID), nil)
+	ms.On("CreateAPIKey", mock.Anything, mfaTestUserID, "mapping script", []string{"pieces:read"}).Return(key, "gbs_abcdefghijkl", nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("/api/v1/users/%s/api-keys", mfaTestUserID),
+		strings.NewReader(`{"name":"mapping script","scopes":["pieces:read"]}`),
+	)
+	r.Header.Set("Authorization", "Bearer token")
+
+	app.router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.JSONEq(t, `{
+		"id":"4d0e2d7e-8f2c-4a39-9a0a-0ad3e6d9b6f1",
+		"user_id":"9abc46be-3bcd-42b1-aeb2-ac6ff557a580",
+		"name":"mapping script",
+		"prefix":"gbs_abcdefgh",
+		"scopes":["pieces:read"],
+		"created_at":"2022-08-01T12:00:00Z",
+		"last_used_at":null,
+		"key":"gbs_abcdefghijkl"
+	}`, w.Body.String())
+	ms.AssertExpectations(t)
+	ma.AssertExpectations(t)
+}
+
+func TestHandleRevokeAPIKey_successPath(t *testing.T) {
+	ms := &mockService{}
+	ma := &mockAuth{}
+	app := New(mux.NewRouter(), nullLogger(), nil, "", "", ma, ms)
+	app.routes()
+
+	ma.On("GetClaims", "token").Return(claimsFor(mfaTestUserID), nil)
+	ms.On("RevokeAPIKey", mock.Anything, mfaTestUserID, "key-id").Return(nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/v1/users/%s/api-keys/key-id", mfaTestUserID), nil)
+	r.Header.Set("Authorization", "Bearer token")
+
+	app.router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	ms.AssertExpectations(t)
+	ma.AssertExpectations(t)
+}
+
+func TestHandleListAPIKeys_failurePath(t *testing.T) {
+	testCases := []struct {
+		name               string
+		authHeader         string
+		setup              func(ms *mockService, ma *mockAuth)
+		expectedStatusCode int
+		expectedRespBody   string
+	}{
+		{
+			name:       "revoked api key",
+			authHeader: "ApiKey gbs_revoked",
+			setup: func(ms *mockService, ma *mockAuth) {
+				ms.On("AuthenticateAPIKey", mock.Anything, "gbs_revoked").
+					Return(nil, &domain.Error{Code: domain.Unauthorized, Msg: "api key is invalid"})
+			},
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedRespBody:   problemJSONWithInstance(http.StatusUnauthorized, errCodeUnauthorized, "api key is invalid", testRequestID),
+		},
+		{
+			name:       "valid api key cannot manage credentials",
+			authHeader: "ApiKey gbs_valid",
+			setup: func(ms *mockService, ma *mockAuth) {
+				ms.On("AuthenticateAPIKey", mock.Anything, "gbs_valid").
+					Return(&domain.APIKey{UserID: mfaTestUserID, Scopes: []string{domain.ScopeUsersRead, domain.ScopeUsersWrite}}, nil)
+			},
+			expectedStatusCode: http.StatusForbidden,
+			expectedRespBody:   problemJSONWithInstance(http.StatusForbidden, errCodeForbidden, "api keys cannot be used to manage credentials", testRequestID),
+		},
+		{
+			name:               "unknown auth scheme",
+			authHeader:         "Basic dXNlcjpwYXNz",
+			setup:              func(ms *mockService, ma *mockAuth) {},
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedRespBody:   problemJSONWithInstance(http.StatusUnauthorized, errCodeUnauthorized, "auth header value in unexpected format", testRequestID),
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			ms := &mockService{}
+			ma := &mockAuth{}
+			tc.setup(ms, ma)
+			app := New(mux.NewRouter(), nullLogger(), nil, "", "", ma, ms)
+			app.routes()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/users/%s/api-keys", mfaTestUserID), nil)
+			r.Header.Set("Authorization", tc.authHeader)
+			r.Header.Set(reqIDHeader, testRequestID)
+
+			app.router.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedStatusCode, w.Code)
+			assert.JSONEq(t, tc.expectedRespBody, w.Body.String())
+			ms.AssertNotCalled(t, "ListAPIKeys", mock.Anything, mock.Anything)
+			ms.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRoutes_apiKeyScopes(t *testing.T) {
+	testCases := []struct {
+		name               string
+		method             string
+		authHeader         string
+		setup              func(ms *mockService)
+		expectedStatusCode int
+		expectedRespBody   string
+	}{
+		{
+			name:       "accepted key with scope",
+			method:     http.MethodGet,
+			authHeader: "ApiKey gbs_read",
+			setup: func(ms *mockService) {
+				ms.On("AuthenticateAPIKey", mock.Anything, "gbs_read").
+					Return(&domain.APIKey{ID: "key-id", UserID: mfaTestUserID, Scopes: []string{domain.ScopeUsersRead}}, nil).Once()
+				ms.On("GetUser", mock.Anything, mfaTestUserID).Return(&domain.User{ID: mfaTestUserID, Version: 1}, nil).Once()
+			},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:       "revoked key",
+			method:     http.MethodGet,
+			authHeader: "ApiKey gbs_revoked",
+			setup: func(ms *mockService) {
+				ms.On("AuthenticateAPIKey", mock.Anything, "gbs_revoked").
+					Return(nil, &domain.Error{Code: domain.Unauthorized, Msg: "api key is invalid"}).Once()
+			},
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedRespBody:   problemJSONWithInstance(http.StatusUnauthorized, errCodeUnauthorized, "api key is invalid", testRequestID),
+		},
+		{
+			name:       "key missing scope",
+			method:     http.MethodPatch,
+			authHeader: "ApiKey gbs_read",
+			setup: func(ms *mockService) {
+				ms.On("AuthenticateAPIKey", mock.Anything, "gbs_read").
+					Return(&domain.APIKey{ID: "key-id", UserID: mfaTestUserID, Scopes: []string{domain.ScopeUsersRead}}, nil).Once()
+			},
+			expectedStatusCode: http.StatusForbidden,
+			expectedRespBody:   problemJSONWithInstance(http.StatusForbidden, errCodeForbidden, "api key lacks scope users:write", testRequestID),
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			ms := &mockService{}
+			tc.setup(ms)
+			app := New(mux.NewRouter(), nullLogger(), nil, "", "", &mockAuth{}, ms)
+			app.routes()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tc.method, "/api/v1/users/"+mfaTestUserID, nil)
+			r.Header.Set("Authorization", tc.authHeader)
+			r.Header.Set(reqIDHeader, testRequestID)
+
+			app.router.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedStatusCode, w.Code)
+			if tc.expectedRespBody != "" {
+				assert.JSONEq(t, tc.expectedRespBody, w.Body.String())
+			}
+			ms.AssertNotCalled(t, "PatchUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			ms.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := RequireScope(domain.ScopeUsersWrite)(ok)
+
+	testCases := []struct {
+		name               string
+		principal          *principal
+		expectedStatusCode int
+	}{
+		{"key without scope", &principal{Subject: mfaTestUserID, APIKey: &domain.APIKey{Scopes: []string{domain.ScopeUsersRead}}}, http.StatusForbidden},
+		{"key with scope", &principal{Subject: mfaTestUserID, APIKey: &domain.APIKey{Scopes: []string{domain.ScopeUsersWrite}}}, http.StatusOK},
+		// access tokens are not restricted to scopes
+		{"access token", &principal{Subject: mfaTestUserID}, http.StatusOK},
+		{"anonymous", nil, http.StatusOK},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPatch, "/api/v1/users/"+mfaTestUserID, nil)
+			if tc.principal != nil {
+				r = r.WithContext(context.WithValue(r.Context(), ctxKeyPrincipal, tc.principal))
+			}
+
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedStatusCode, w.Code)
+		})
+	}
+}
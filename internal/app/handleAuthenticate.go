@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
 type loginRequest struct {
@@ -17,12 +18,18 @@ type loginResponse struct {
 	TokenType   string `json:"token_type"`
 }
 
+// mfaChallengeResponse is returned instead of a loginResponse when the password is valid but a second factor is required
+type mfaChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
 func (app *App) handleAuthenticate() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		reqBody, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			apperr := newAppErr("request body unreadable", http.StatusBadRequest)
-			http.Error(w, apperr.Error(), apperr.Code())
+			httpErrorFromAppErr(w, r, apperr)
 			return
 		}
 
@@ -31,37 +38,83 @@ func (app *App) handleAuthenticate() http.HandlerFunc {
 		var reqBodyData loginRequest
 		if err := json.Unmarshal(reqBody, &reqBodyData); err != nil {
 			apperr := newAppErr("invalid json in request body", http.StatusBadRequest)
-			http.Error(w, apperr.Error(), apperr.Code())
+			httpErrorFromAppErr(w, r, apperr)
 			return
 		}
 
-		user, dErr := app.service.ValidateUserCredentials(r.Context(), reqBodyData.UserName, reqBodyData.Email, reqBodyData.Password)
+		user, dErr := app.service.ValidateUserCredentials(r.Context(), reqBodyData.UserName, reqBodyData.Email, reqBodyData.Password, clientIP(r))
 		if dErr != nil {
-			apperr := app.newAppErrFromDomainErr(dErr)
-			http.Error(w, apperr.Error(), apperr.Code())
-			return
-		}
+			if dErr.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(dErr.RetryAfter)))
+			}
 
-		// user credentials are valid so now we need to return a token
-		tokenString, err := app.tokenAuth.GenerateTokenString(user.ID)
-		if err != nil {
-			apperr := newAppErr("failed to generate token", http.StatusInternalServerError)
-			http.Error(w, apperr.Error(), apperr.Code())
+			apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+			httpErrorFromAppErr(w, r, apperr)
 			return
 		}
 
-		resp := loginResponse{
-			AccessToken: tokenString,
-			TokenType:   "Bearer",
-		}
-		respBodyBytes, err := json.Marshal(resp)
-		if err != nil {
-			apperr := newAppErr("failed to marsal response", http.StatusInternalServerError)
-			http.Error(w, apperr.Error(), apperr.Code())
-			return
-		}
+		// user credentials are valid so now we need to return a token, or ask for a second factor
+		app.completeLogin(w, r, user.ID)
+	}
+}
+
+// completeLogin issues an access token once the user's primary credentials are verified,
+// unless they have two-factor authentication enabled in which case they must first pass /auth/mfa
+func (app *App) completeLogin(w http.ResponseWriter, r *http.Request, userID string) {
+	mfaEnabled, dErr := app.service.IsMFAEnabled(r.Context(), userID)
+	if dErr != nil {
+		apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+		httpErrorFromAppErr(w, r, apperr)
+		return
+	} else if mfaEnabled {
+		app.writeMFAChallengeResponse(w, r, userID)
+		return
+	}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write(respBodyBytes)
+	app.writeLoginResponse(w, r, userID)
+}
+
+// writeLoginResponse issues an access token for the given user
+func (app *App) writeLoginResponse(w http.ResponseWriter, r *http.Request, userID string) {
+	tokenString, err := app.tokenAuth.GenerateTokenString(userID)
+	if err != nil {
+		apperr := newAppErr("failed to generate token", http.StatusInternalServerError)
+		httpErrorFromAppErr(w, r, apperr)
+		return
+	}
+
+	resp := loginResponse{
+		AccessToken: tokenString,
+		TokenType:   "Bearer",
 	}
+
+	respBodyBytes, err := json.Marshal(resp)
+	if err != nil {
+		apperr := newAppErr("failed to marsal response", http.StatusInternalServerError)
+		httpErrorFromAppErr(w, r, apperr)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBodyBytes)
+}
+
+// writeMFAChallengeResponse issues a token which can be exchanged along with a second factor at /auth/mfa for an access token
+func (app *App) writeMFAChallengeResponse(w http.ResponseWriter, r *http.Request, userID string) {
+	tokenString, err := app.tokenAuth.GenerateMFAChallengeTokenString(userID)
+	if err != nil {
+		apperr := newAppErr("failed to generate token", http.StatusInternalServerError)
+		httpErrorFromAppErr(w, r, apperr)
+		return
+	}
+
+	respBodyBytes, err := json.Marshal(mfaChallengeResponse{MFARequired: true, MFAToken: tokenString})
+	if err != nil {
+		apperr := newAppErr("failed to marshal response", http.StatusInternalServerError)
+		httpErrorFromAppErr(w, r, apperr)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBodyBytes)
 }
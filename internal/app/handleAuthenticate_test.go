@@ -0,0 +1,70 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandleAuthenticate_successPath(t *testing.T) {
+	ms := &mockService{}
+	ma := &mockAuth{}
+	app := New(nil, nullLogger(), nil, "", "", ma, ms)
+
+	u := domain.NewUser("9abc46be-3bcd-42b1-aeb2-ac6ff557a580", "test", "test@example.com", "ac6ff557a5804eff")
+	ms.On("ValidateUserCredentials", mock.Anything, "test", "", "password", "192.0.2.1").Return(u, nil)
+	ms.On("IsMFAEnabled", mock.Anything, u.ID).Return(false, nil)
+	ma.On("GenerateTokenString", u.ID).Return("token", nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/auth", strings.NewReader(`{"user_name":"test", "password":"password"}`))
+
+	app.handleAuthenticate()(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"access_token":"token","token_type":"Bearer"}`, w.Body.String())
+	ms.AssertExpectations(t)
+	ma.AssertExpectations(t)
+}
+
+func TestHandleAuthenticate_invalidCredentials_failurePath(t *testing.T) {
+	ms := &mockService{}
+	app := New(nil, nullLogger(), nil, "", "", nil, ms)
+
+	// unknown users and wrong passwords get the same error, so the response doesn't reveal which accounts exist
+	ms.On("ValidateUserCredentials", mock.Anything, "nobody", "", "password", "192.0.2.1").
+		Return(nil, &domain.Error{Code: domain.Unauthorized, Msg: "credentials are invalid"})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/auth", strings.NewReader(`{"user_name":"nobody", "password":"password"}`))
+
+	app.handleAuthenticate()(w, r)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, problemJSON(http.StatusUnauthorized, errCodeUnauthorized, "credentials are invalid"), w.Body.String())
+	ms.AssertExpectations(t)
+}
+
+func TestHandleAuthenticate_tooManyAttempts_failurePath(t *testing.T) {
+	ms := &mockService{}
+	app := New(nil, nullLogger(), nil, "", "", nil, ms)
+
+	ms.On("ValidateUserCredentials", mock.Anything, "test", "", "password", "192.0.2.1").
+		Return(nil, &domain.Error{Code: domain.TooManyRequests, Msg: "too many failed login attempts", RetryAfter: 1500 * time.Millisecond})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/auth", strings.NewReader(`{"user_name":"test", "password":"password"}`))
+
+	app.handleAuthenticate()(w, r)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, problemJSON(http.StatusTooManyRequests, errCodeTooManyRequests, "too many failed login attempts"), w.Body.String())
+	ms.AssertExpectations(t)
+}
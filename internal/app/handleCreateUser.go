@@ -19,7 +19,7 @@ func (app *App) handleCreateUser() http.HandlerFunc {
 		reqBodyBytes, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			apperr := newAppErr("request body unreadable", http.StatusBadRequest)
-			http.Error(w, apperr.Error(), apperr.Code())
+			httpErrorFromAppErr(w, r, apperr)
 			return
 		}
 
@@ -28,25 +28,26 @@ func (app *App) handleCreateUser() http.HandlerFunc {
 		var userData createUserReq
 		if err := json.Unmarshal(reqBodyBytes, &userData); err != nil {
 			apperr := newAppErr("invalid json in request body", http.StatusBadRequest)
-			http.Error(w, apperr.Error(), apperr.Code())
+			httpErrorFromAppErr(w, r, apperr)
 			return
 		}
 
 		user, dErr := app.service.CreateUser(r.Context(), userData.UserName, userData.Email, userData.Password)
 		if dErr != nil {
-			apperr := app.newAppErrFromDomainErr(dErr)
-			http.Error(w, apperr.Error(), apperr.Code())
+			apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+			httpErrorFromAppErr(w, r, apperr)
 			return
 		}
 
 		respBodyBytes, err := json.Marshal(user)
 		if err != nil {
-			app.logger.WithField(appHandler, handlerCreateUser).WithError(err).Error("failed to marshal json response")
+			app.logger.WithContext(r.Context()).WithField(appHandler, handlerCreateUser).WithError(err).Error("failed to marshal json response")
 			apperr := newAppErr("failed to marshal json response", http.StatusInternalServerError)
-			http.Error(w, apperr.Error(), apperr.Code())
+			httpErrorFromAppErr(w, r, apperr)
 			return
 		}
 
+		w.Header().Set("ETag", formatETag(user.Version))
 		w.WriteHeader(http.StatusCreated)
 		w.Write(respBodyBytes)
 	}
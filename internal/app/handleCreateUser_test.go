@@ -45,7 +45,7 @@ func TestHandleCreateUser_requestBodyContainsInvalidJSON_failurePath(t *testing.
 	app.handleCreateUser()(w, r)
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
-	assert.Equal(t, `{"error": "invalid json in request body"}`, strings.TrimRight(w.Body.String(), "\n"))
+	assert.JSONEq(t, problemJSON(http.StatusBadRequest, errCodeInvalidRequest, "invalid json in request body"), w.Body.String())
 }
 
 func TestHandleCreateUser_requestBodyUnreadable_failurePath(t *testing.T) {
@@ -58,7 +58,7 @@ func TestHandleCreateUser_requestBodyUnreadable_failurePath(t *testing.T) {
 	app.handleCreateUser()(w, r)
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
-	assert.Equal(t, `{"error": "request body unreadable"}`, strings.TrimRight(w.Body.String(), "\n"))
+	assert.JSONEq(t, problemJSON(http.StatusBadRequest, errCodeInvalidRequest, "request body unreadable"), w.Body.String())
 }
 
 func TestHandleCreateUser_serviceErr_failurePath(t *testing.T) {
@@ -72,13 +72,19 @@ func TestHandleCreateUser_serviceErr_failurePath(t *testing.T) {
 			name:           "service returns InvalidInput error",
 			domainErrType:  domain.InvalidInput,
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `{"error": "invalid input data - test error"}`,
+			expectedBody:   problemJSON(http.StatusBadRequest, errCodeInvalidInput, "test error"),
+		},
+		{
+			name:           "service returns ResourceConflict error",
+			domainErrType:  domain.ResourceConflict,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   problemJSON(http.StatusConflict, errCodeConflict, "test error"),
 		},
 		{
 			name:           "service returns system error",
 			domainErrType:  domain.SystemError,
 			expectedStatus: http.StatusInternalServerError,
-			expectedBody:   `{"error": "unexpected system error - test error"}`,
+			expectedBody:   problemJSON(http.StatusInternalServerError, errCodeSystemError, "test error"),
 		},
 	}
 
@@ -97,7 +103,7 @@ func TestHandleCreateUser_serviceErr_failurePath(t *testing.T) {
 			app.handleCreateUser()(w, r)
 
 			assert.Equal(t, tc.expectedStatus, w.Code)
-			assert.Equal(t, tc.expectedBody, strings.TrimRight(w.Body.String(), "\n"))
+			assert.JSONEq(t, tc.expectedBody, w.Body.String())
 
 			ms.AssertExpectations(t)
 		})
@@ -16,20 +16,27 @@ func (app *App) handleGetUser() http.HandlerFunc {
 
 		user, dErr := app.service.GetUser(r.Context(), userID)
 		if dErr != nil {
-			apperr := app.newAppErrFromDomainErr(dErr)
-			http.Error(w, apperr.Error(), apperr.Code())
+			apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+			httpErrorFromAppErr(w, r, apperr)
 			return
 		} else if user == nil {
 			apperr := newAppErr("user not found", http.StatusNotFound)
-			http.Error(w, apperr.Error(), apperr.Code())
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		etag := formatETag(user.Version)
+		setCacheHeaders(w, app.cacheControl.Users, etag, user.ModifiedAt)
+		if notModified(r, etag, user.ModifiedAt) {
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
 
 		respBytes, err := json.Marshal(user)
 		if err != nil {
-			app.logger.WithField(appHandler, handleGetUser).WithError(err).Error("failed to marshal json response")
+			app.logger.WithContext(r.Context()).WithField(appHandler, handleGetUser).WithError(err).Error("failed to marshal json response")
 			apperr := newAppErr("failed to marshal json response", http.StatusInternalServerError)
-			http.Error(w, apperr.Error(), apperr.Code())
+			httpErrorFromAppErr(w, r, apperr)
 			return
 		}
 
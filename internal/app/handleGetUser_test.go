@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
+	"time"
 
 	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
 	"github.com/gorilla/mux"
@@ -28,6 +28,8 @@ func TestHandleGetUser_successPath(t *testing.T) {
 	app.handleGetUser()(w, r)
 
 	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
 
 	expectedRespBody, err := json.Marshal(u)
 	assert.NoError(t, err)
@@ -47,13 +49,13 @@ func TestHandleGetUser_serviceErr_failurePath(t *testing.T) {
 			name:           "service returns InvalidInput error",
 			domainErrType:  domain.InvalidInput,
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `{"error": "invalid input data - test error"}`,
+			expectedBody:   problemJSON(http.StatusBadRequest, errCodeInvalidInput, "test error"),
 		},
 		{
 			name:           "service returns system error",
 			domainErrType:  domain.SystemError,
 			expectedStatus: http.StatusInternalServerError,
-			expectedBody:   `{"error": "unexpected system error - test error"}`,
+			expectedBody:   problemJSON(http.StatusInternalServerError, errCodeSystemError, "test error"),
 		},
 	}
 
@@ -72,7 +74,7 @@ func TestHandleGetUser_serviceErr_failurePath(t *testing.T) {
 			app.handleGetUser()(w, r)
 
 			assert.Equal(t, tc.expectedStatus, w.Code)
-			assert.Equal(t, tc.expectedBody, strings.TrimRight(w.Body.String(), "\n"))
+			assert.JSONEq(t, tc.expectedBody, w.Body.String())
 
 			ms.AssertExpectations(t)
 		})
@@ -93,7 +95,49 @@ func TestHandleGetUser_userNotFound_failurePath(t *testing.T) {
 	app.handleGetUser()(w, r)
 
 	assert.Equal(t, http.StatusNotFound, w.Code)
-	assert.Equal(t, `{"error": "user not found"}`, strings.TrimRight(w.Body.String(), "\n"))
+	assert.JSONEq(t, problemJSON(http.StatusNotFound, errCodeNotFound, "user not found"), w.Body.String())
 
 	ms.AssertExpectations(t)
 }
+
+func TestHandleGetUser_notModified_successPath(t *testing.T) {
+	modifiedAt := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{name: "etag matches", headers: map[string]string{"If-None-Match": `"2"`}},
+		{name: "weak etag matches", headers: map[string]string{"If-None-Match": `W/"2"`}},
+		{name: "not modified since", headers: map[string]string{"If-Modified-Since": "Tue, 01 Jun 2021 12:00:00 GMT"}},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			ms := &mockService{}
+			app := New(nil, nullLogger(), nil, "", "", nil, ms, WithCacheControl(CacheControl{Users: "private, max-age=60"}))
+
+			u := domain.NewUser("9abc46be-3bcd-42b1-aeb2-ac6ff557a580", "test", "test@example.com", "ac6ff557a5804eff")
+			u.Version = 2
+			u.ModifiedAt = modifiedAt
+			ms.On("GetUser", mock.Anything, "9abc46be-3bcd-42b1-aeb2-ac6ff557a580").Return(u, nil)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/users/9abc46be-3bcd-42b1-aeb2-ac6ff557a580", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			r = mux.SetURLVars(r, map[string]string{"userID": "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"})
+
+			app.handleGetUser()(w, r)
+
+			assert.Equal(t, http.StatusNotModified, w.Code)
+			assert.Equal(t, "", w.Body.String())
+			assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+			assert.Equal(t, "Tue, 01 Jun 2021 12:00:00 GMT", w.Header().Get("Last-Modified"))
+			assert.Equal(t, "private, max-age=60", w.Header().Get("Cache-Control"))
+
+			ms.AssertExpectations(t)
+		})
+	}
+}
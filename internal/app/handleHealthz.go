@@ -0,0 +1,27 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type healthzResponse struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+}
+
+// handleHealthz is the liveness probe. It deliberately doesn't check dependencies,
+// restarting the app won't fix an unreachable DB, so that is left to /readyz
+func (app *App) handleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respBytes, err := json.Marshal(healthzResponse{Status: checkStatusOK, Version: app.version})
+		if err != nil {
+			httpErrorFromAppErr(w, r, newAppErr("failed to marshal json response", http.StatusInternalServerError))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(respBytes)
+	}
+}
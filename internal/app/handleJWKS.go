@@ -0,0 +1,27 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const handlerJWKS = "handleJWKS"
+
+// handleJWKS publishes the public keys used to sign access tokens as an RFC 7517 JSON Web Key Set.
+// The keys sign MFA challenge tokens too, so verifiers must check tokens have auth.AccessTokenAudience and auth.AccessTokenType
+func (app *App) handleJWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respBytes, err := json.Marshal(app.tokenAuth.JWKS())
+		if err != nil {
+			app.logger.WithContext(r.Context()).WithField(appHandler, handlerJWKS).WithError(err).Error("failed to marshal json response")
+			apperr := newAppErr("failed to marshal json response", http.StatusInternalServerError)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		// verifiers should refetch regularly to pick up rotated keys
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(respBytes)
+	}
+}
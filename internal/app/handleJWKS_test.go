@@ -0,0 +1,33 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleJWKS_successPath(t *testing.T) {
+	ma := &mockAuth{}
+	app := New(nil, nullLogger(), nil, "", "", ma, nil)
+
+	ma.On("JWKS").Return(auth.JWKS{Keys: []auth.JWK{
+		{KeyType: "OKP", KeyID: "2022-07-01", Use: "sig", Algorithm: "EdDSA", Curve: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+	}})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+
+	app.handleJWKS()(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(
+		t,
+		`{"keys":[{"kty":"OKP","kid":"2022-07-01","use":"sig","alg":"EdDSA","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`,
+		w.Body.String(),
+	)
+	ma.AssertExpectations(t)
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	handlerVerifyMFA   = "handleVerifyMFA"
+	handlerEnrollTOTP  = "handleEnrollTOTP"
+	handlerConfirmTOTP = "handleConfirmTOTP"
+)
+
+type verifyMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type enrollTOTPResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	// QRCode is a data URI which can be used directly as the src of an img
+	QRCode string `json:"qr_code"`
+}
+
+type confirmTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+type confirmTOTPResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// handleVerifyMFA completes a two-factor login, exchanging the challenge token issued by /auth and a TOTP or recovery code for an access token
+func (app *App) handleVerifyMFA() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			apperr := newAppErr("request body unreadable", http.StatusBadRequest)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		defer r.Body.Close()
+
+		var reqBodyData verifyMFARequest
+		if err := json.Unmarshal(reqBody, &reqBodyData); err != nil {
+			apperr := newAppErr("invalid json in request body", http.StatusBadRequest)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		claims, err := app.tokenAuth.GetMFAChallengeClaims(reqBodyData.MFAToken)
+		if err != nil {
+			app.logger.WithContext(r.Context()).WithField(appHandler, handlerVerifyMFA).WithError(err).Info("invalid MFA challenge token")
+			apperr := newAppErr("invalid mfa token", http.StatusUnauthorized)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		if dErr := app.service.VerifySecondFactor(r.Context(), claims.Subject, reqBodyData.Code, clientIP(r)); dErr != nil {
+			if dErr.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(dErr.RetryAfter)))
+			}
+
+			apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		app.writeLoginResponse(w, r, claims.Subject)
+	}
+}
+
+// handleEnrollTOTP generates a new TOTP secret for the authenticated user
+func (app *App) handleEnrollTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)[urlVarUserID]
+		if !requireUserSession(w, r, userID) {
+			return
+		}
+
+		enrollment, dErr := app.service.EnrollTOTP(r.Context(), userID)
+		if dErr != nil {
+			apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		respBytes, err := json.Marshal(enrollTOTPResponse{
+			Secret: enrollment.Secret,
+			URI:    enrollment.URI,
+			QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(enrollment.QRCodePNG),
+		})
+		if err != nil {
+			app.logger.WithContext(r.Context()).WithField(appHandler, handlerEnrollTOTP).WithError(err).Error("failed to marshal json response")
+			apperr := newAppErr("failed to marshal json response", http.StatusInternalServerError)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(respBytes)
+	}
+}
+
+// handleConfirmTOTP enables two-factor authentication for the authenticated user given a valid code for their new secret
+func (app *App) handleConfirmTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)[urlVarUserID]
+		if !requireUserSession(w, r, userID) {
+			return
+		}
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			apperr := newAppErr("request body unreadable", http.StatusBadRequest)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		defer r.Body.Close()
+
+		var reqBodyData confirmTOTPRequest
+		if err := json.Unmarshal(reqBody, &reqBodyData); err != nil {
+			apperr := newAppErr("invalid json in request body", http.StatusBadRequest)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		recoveryCodes, dErr := app.service.ConfirmTOTP(r.Context(), userID, reqBodyData.Code)
+		if dErr != nil {
+			apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		respBytes, err := json.Marshal(confirmTOTPResponse{RecoveryCodes: recoveryCodes})
+		if err != nil {
+			app.logger.WithContext(r.Context()).WithField(appHandler, handlerConfirmTOTP).WithError(err).Error("failed to marshal json response")
+			apperr := newAppErr("failed to marshal json response", http.StatusInternalServerError)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		// recovery codes are only ever shown once
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(respBytes)
+	}
+}
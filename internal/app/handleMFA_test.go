@@ -0,0 +1,241 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/auth"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const mfaTestUserID = "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+
+func claimsFor(subject string) *auth.JWTClaims {
+	return &auth.JWTClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: subject}}
+}
+
+func TestHandleAuthenticate_mfaEnabled_successPath(t *testing.T) {
+	ms := &mockService{}
+	ma := &mockAuth{}
+	app := New(nil, nullLogger(), nil, "", "", ma, ms)
+
+	u := domain.NewUser(mfaTestUserID, "test", "test@example.com", "ac6ff557a5804eff")
+	ms.On("ValidateUserCredentials", mock.Anything, "test", "", "password", "192.0.2.1").Return(u, nil)
+	ms.On("IsMFAEnabled", mock.Anything, u.ID).Return(true, nil)
+	ma.On("GenerateMFAChallengeTokenString", u.ID).Return("challenge", nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/auth", strings.NewReader(`{"user_name":"test", "password":"password"}`))
+
+	app.handleAuthenticate()(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"mfa_required":true,"mfa_token":"challenge"}`, w.Body.String())
+	ms.AssertExpectations(t)
+	ma.AssertExpectations(t)
+}
+
+func TestHandleVerifyMFA_successPath(t *testing.T) {
+	ms := &mockService{}
+	ma := &mockAuth{}
+	app := New(nil, nullLogger(), nil, "", "", ma, ms)
+
+	ma.On("GetMFAChallengeClaims", "challenge").Return(claimsFor(mfaTestUserID), nil)
+	ms.On("VerifySecondFactor", mock.Anything, mfaTestUserID, "123456", "192.0.2.1").Return(nil)
+	ma.On("GenerateTokenString", mfaTestUserID).Return("token", nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/auth/mfa", strings.NewReader(`{"mfa_token":"challenge", "code":"123456"}`))
+
+	app.handleVerifyMFA()(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"access_token":"token","token_type":"Bearer"}`, w.Body.String())
+	ms.AssertExpectations(t)
+	ma.AssertExpectations(t)
+}
+
+func TestHandleVerifyMFA_failurePath(t *testing.T) {
+	testCases := []struct {
+		name               string
+		reqBody            string
+		setup              func(ms *mockService, ma *mockAuth)
+		expectedStatusCode int
+		expectedRetryAfter string
+		expectedRespBody   string
+	}{
+		{
+			name:               "invalid json",
+			reqBody:            `{"mfa_token":`,
+			setup:              func(ms *mockService, ma *mockAuth) {},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedRespBody:   problemJSON(http.StatusBadRequest, errCodeInvalidRequest, "invalid json in request body"),
+		},
+		{
+			name:    "access token presented instead of challenge token",
+			reqBody: `{"mfa_token":"access", "code":"123456"}`,
+			setup: func(ms *mockService, ma *mockAuth) {
+				ma.On("GetMFAChallengeClaims", "access").Return(nil, fmt.Errorf("token is not an MFA challenge token"))
+			},
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedRespBody:   problemJSON(http.StatusUnauthorized, errCodeUnauthorized, "invalid mfa token"),
+		},
+		{
+			name:    "wrong code",
+			reqBody: `{"mfa_token":"challenge", "code":"000000"}`,
+			setup: func(ms *mockService, ma *mockAuth) {
+				ma.On("GetMFAChallengeClaims", "challenge").Return(claimsFor(mfaTestUserID), nil)
+				ms.On("VerifySecondFactor", mock.Anything, mfaTestUserID, "000000", "192.0.2.1").
+					Return(&domain.Error{Code: domain.Unauthorized, Msg: "code is invalid"})
+			},
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedRespBody:   problemJSON(http.StatusUnauthorized, errCodeUnauthorized, "code is invalid"),
+		},
+		{
+			name:    "too many attempts",
+			reqBody: `{"mfa_token":"challenge", "code":"000000"}`,
+			setup: func(ms *mockService, ma *mockAuth) {
+				ma.On("GetMFAChallengeClaims", "challenge").Return(claimsFor(mfaTestUserID), nil)
+				ms.On("VerifySecondFactor", mock.Anything, mfaTestUserID, "000000", "192.0.2.1").
+					Return(&domain.Error{Code: domain.TooManyRequests, Msg: "too many failed login attempts", RetryAfter: 30 * time.Second})
+			},
+			expectedStatusCode: http.StatusTooManyRequests,
+			expectedRetryAfter: "30",
+			expectedRespBody:   problemJSON(http.StatusTooManyRequests, errCodeTooManyRequests, "too many failed login attempts"),
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			ms := &mockService{}
+			ma := &mockAuth{}
+			tc.setup(ms, ma)
+			app := New(nil, nullLogger(), nil, "", "", ma, ms)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/auth/mfa", strings.NewReader(tc.reqBody))
+
+			app.handleVerifyMFA()(w, r)
+
+			assert.Equal(t, tc.expectedStatusCode, w.Code)
+			assert.Equal(t, tc.expectedRetryAfter, w.Header().Get("Retry-After"))
+			assert.JSONEq(t, tc.expectedRespBody, w.Body.String())
+			ms.AssertExpectations(t)
+			ma.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleEnrollTOTP_successPath(t *testing.T) {
+	ms := &mockService{}
+	ma := &mockAuth{}
+	app := New(mux.NewRouter(), nullLogger(), nil, "", "", ma, ms)
+	app.routes()
+
+	ma.On("GetClaims", "token").Return(claimsFor(mfaTestUserID), nil)
+	ms.On("EnrollTOTP", mock.Anything, mfaTestUserID).Return(&domain.TOTPEnrollment{
+		Secret:    "JBSWY3DPEHPK3PXP",
+		URI:       "otpauth://totp/graffiti-berlin-svc:test%40example.com?secret=JBSWY3DPEHPK3PXP",
+		QRCodePNG: []byte("png"),
+	}, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/users/%s/mfa/totp", mfaTestUserID), nil)
+	r.Header.Set("Authorization", "Bearer token")
+
+	app.router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var resp enrollTOTPResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", resp.Secret)
+	assert.Equal(t, "data:image/png;base64,cG5n", resp.QRCode)
+	ms.AssertExpectations(t)
+	ma.AssertExpectations(t)
+}
+
+func TestHandleConfirmTOTP_successPath(t *testing.T) {
+	ms := &mockService{}
+	ma := &mockAuth{}
+	app := New(mux.NewRouter(), nullLogger(), nil, "", "", ma, ms)
+	app.routes()
+
+	ma.On("GetClaims", "token").Return(claimsFor(mfaTestUserID), nil)
+	ms.On("ConfirmTOTP", mock.Anything, mfaTestUserID, "123456").Return([]string{"abcde-fghjk"}, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/users/%s/mfa/totp/confirm", mfaTestUserID), strings.NewReader(`{"code":"123456"}`))
+	r.Header.Set("Authorization", "Bearer token")
+
+	app.router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"recovery_codes":["abcde-fghjk"]}`, w.Body.String())
+	ms.AssertExpectations(t)
+	ma.AssertExpectations(t)
+}
+
+func TestHandleEnrollTOTP_failurePath(t *testing.T) {
+	testCases := []struct {
+		name               string
+		authHeader         string
+		setup              func(ma *mockAuth)
+		expectedStatusCode int
+	}{
+		{
+			name:               "no token",
+			setup:              func(ma *mockAuth) {},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid token",
+			authHeader: "Bearer token",
+			setup: func(ma *mockAuth) {
+				ma.On("GetClaims", "token").Return(nil, fmt.Errorf("failed to parse token: token is expired"))
+			},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "token for a different user",
+			authHeader: "Bearer token",
+			setup: func(ma *mockAuth) {
+				ma.On("GetClaims", "token").Return(claimsFor("a0d6f5e2-95a4-4fa4-9a4c-6ec9ee8a2b9a"), nil)
+			},
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			ms := &mockService{}
+			ma := &mockAuth{}
+			tc.setup(ma)
+			app := New(mux.NewRouter(), nullLogger(), nil, "", "", ma, ms)
+			app.routes()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/users/%s/mfa/totp", mfaTestUserID), nil)
+			if tc.authHeader != "" {
+				r.Header.Set("Authorization", tc.authHeader)
+			}
+
+			app.router.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedStatusCode, w.Code)
+			ms.AssertNotCalled(t, "EnrollTOTP", mock.Anything, mock.Anything)
+			ma.AssertExpectations(t)
+		})
+	}
+}
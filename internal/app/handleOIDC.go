@@ -0,0 +1,144 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/config"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/oidc"
+	"github.com/gorilla/mux"
+)
+
+const (
+	handlerOIDCStart    = "handleOIDCStart"
+	handlerOIDCCallback = "handleOIDCCallback"
+
+	oidcFlowCookie = "oidc_flow"
+	// how long the user has to complete the login at the provider
+	oidcFlowTTL = 10 * time.Minute
+)
+
+// OIDCProvider is an OpenID Connect identity provider users can log in with
+type OIDCProvider interface {
+	Name() string
+	AuthCodeURL(state, nonce, codeChallenge string) string
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*oidc.Identity, error)
+}
+
+// WithOIDCProviders enables social login via the given providers. flows signs the login state stored in the user agent
+func WithOIDCProviders(flows *oidc.FlowCodec, providers ...OIDCProvider) Option {
+	return func(app *App) {
+		app.oidcFlows = flows
+		for _, p := range providers {
+			app.oidcProviders[p.Name()] = p
+		}
+	}
+}
+
+// handleOIDCStart begins an authorization-code + PKCE login by redirecting the user agent to the provider
+func (app *App) handleOIDCStart() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		providerName := mux.Vars(r)[urlVarProvider]
+		provider, ok := app.oidcProviders[providerName]
+		if !ok {
+			apperr := newAppErr("unknown identity provider", http.StatusNotFound)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		flow, err := oidc.NewFlow(providerName, oidcFlowTTL)
+		if err != nil {
+			app.logger.WithContext(r.Context()).WithField(appHandler, handlerOIDCStart).WithError(err).Error("failed to create login flow")
+			apperr := newAppErr("failed to start login", http.StatusInternalServerError)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		encodedFlow, err := app.oidcFlows.Encode(flow)
+		if err != nil {
+			app.logger.WithContext(r.Context()).WithField(appHandler, handlerOIDCStart).WithError(err).Error("failed to encode login flow")
+			apperr := newAppErr("failed to start login", http.StatusInternalServerError)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		http.SetCookie(w, app.oidcFlowCookie(providerName, encodedFlow, int(oidcFlowTTL.Seconds())))
+		http.Redirect(w, r, provider.AuthCodeURL(flow.State, flow.Nonce, flow.CodeChallenge()), http.StatusFound)
+	}
+}
+
+// handleOIDCCallback completes the login when the provider redirects back, returning an access token like /auth
+func (app *App) handleOIDCCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		providerName := mux.Vars(r)[urlVarProvider]
+		provider, ok := app.oidcProviders[providerName]
+		if !ok {
+			apperr := newAppErr("unknown identity provider", http.StatusNotFound)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		// the flow is single use whatever the outcome
+		http.SetCookie(w, app.oidcFlowCookie(providerName, "", -1))
+
+		query := r.URL.Query()
+		if providerErr := query.Get("error"); providerErr != "" {
+			apperr := newAppErr(fmt.Sprintf("identity provider returned error: %s", providerErr), http.StatusUnauthorized)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		cookie, err := r.Cookie(oidcFlowCookie)
+		if err != nil {
+			apperr := newAppErr("no login in progress", http.StatusBadRequest)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		flow, err := app.oidcFlows.Decode(cookie.Value)
+		if err != nil || flow.Provider != providerName || flow.State != query.Get("state") {
+			apperr := newAppErr("login state is invalid", http.StatusBadRequest)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		identity, err := provider.Exchange(r.Context(), query.Get("code"), flow.CodeVerifier, flow.Nonce)
+		if err != nil {
+			app.logger.WithContext(r.Context()).WithField(appHandler, handlerOIDCCallback).WithError(err).Warn("failed to exchange authorization code")
+			apperr := newAppErr("failed to verify identity with provider", http.StatusUnauthorized)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		user, dErr := app.service.LoginWithIdentity(r.Context(), domain.ExternalIdentity{
+			Provider:          identity.Provider,
+			Subject:           identity.Subject,
+			Email:             identity.Email,
+			EmailVerified:     identity.EmailVerified,
+			PreferredUserName: identity.PreferredUserName,
+		})
+		if dErr != nil {
+			apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
+
+		app.completeLogin(w, r, user.ID)
+	}
+}
+
+// oidcFlowCookie is scoped to the provider's paths and must be SameSite=Lax to survive the top-level redirect back from the provider
+func (app *App) oidcFlowCookie(providerName, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    value,
+		Path:     fmt.Sprintf("/auth/oidc/%s", providerName),
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   app.env != config.EnvironmentDev,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
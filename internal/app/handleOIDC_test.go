@@ -0,0 +1,218 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/oidc"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/oidc/oidctest"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newOIDCTestApp(t *testing.T, ms *mockService, ma *mockAuth, mockProvider *oidctest.Provider) *App {
+	return newOIDCTestAppWithLogger(t, nullLogger(), ms, ma, mockProvider)
+}
+
+func newOIDCTestAppWithLogger(t *testing.T, logger *logrus.Logger, ms *mockService, ma *mockAuth, mockProvider *oidctest.Provider) *App {
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		Name:         "mock",
+		IssuerURL:    mockProvider.Issuer(),
+		ClientID:     mockProvider.ClientID,
+		ClientSecret: mockProvider.ClientSecret,
+		RedirectURL:  "http://localhost:8080/auth/oidc/mock/callback",
+	}, nil)
+	require.NoError(t, err)
+
+	return New(mux.NewRouter(), logger, nil, "", "", ma, ms, WithOIDCProviders(oidc.NewFlowCodec([]byte("secret")), provider))
+}
+
+func startOIDCLogin(t *testing.T, app *App) (*http.Cookie, string) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/start", nil)
+	r = mux.SetURLVars(r, map[string]string{"provider": "mock"})
+
+	app.handleOIDCStart()(w, r)
+
+	require.Equal(t, http.StatusFound, w.Code)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "/auth/oidc/mock", cookies[0].Path)
+	assert.True(t, cookies[0].HttpOnly)
+
+	return cookies[0], w.Header().Get("Location")
+}
+
+func TestHandleOIDC_successPath(t *testing.T) {
+	mockProvider := oidctest.NewProvider("client-id", "client-secret")
+	defer mockProvider.Close()
+	mockProvider.SetUser(oidctest.User{Subject: "12345", Email: "test@example.com", EmailVerified: true, PreferredUserName: "test"})
+
+	ms := &mockService{}
+	ma := &mockAuth{}
+	app := newOIDCTestApp(t, ms, ma, mockProvider)
+
+	u := domain.NewUser("9abc46be-3bcd-42b1-aeb2-ac6ff557a580", "test", "test@example.com", "ac6ff557a5804eff")
+	ms.On("LoginWithIdentity", mock.Anything, domain.ExternalIdentity{
+		Provider:          "mock",
+		Subject:           "12345",
+		Email:             "test@example.com",
+		EmailVerified:     true,
+		PreferredUserName: "test",
+	}).Return(u, nil).Once()
+	ms.On("IsMFAEnabled", mock.Anything, u.ID).Return(false, nil).Once()
+	ma.On("GenerateTokenString", u.ID).Return("token", nil).Once()
+
+	cookie, location := startOIDCLogin(t, app)
+	assert.True(t, strings.HasPrefix(location, mockProvider.Issuer()+"/authorize?"))
+
+	callback, err := mockProvider.Authorize(location)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, callback.String(), nil)
+	r.AddCookie(cookie)
+	r = mux.SetURLVars(r, map[string]string{"provider": "mock"})
+
+	app.handleOIDCCallback()(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"access_token":"token","token_type":"Bearer"}`, w.Body.String())
+	ms.AssertExpectations(t)
+	ma.AssertExpectations(t)
+}
+
+func TestHandleOIDCCallback_failurePath(t *testing.T) {
+	mockProvider := oidctest.NewProvider("client-id", "client-secret")
+	defer mockProvider.Close()
+	mockProvider.SetUser(oidctest.User{Subject: "12345", Email: "test@example.com"})
+
+	testCases := []struct {
+		name           string
+		provider       string
+		mutateCallback func(query map[string]string)
+		withoutCookie  bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "unknown provider",
+			provider:       "nope",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   problemJSON(http.StatusNotFound, errCodeNotFound, "unknown identity provider"),
+		},
+		{
+			name:           "no flow cookie",
+			provider:       "mock",
+			withoutCookie:  true,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   problemJSON(http.StatusBadRequest, errCodeInvalidRequest, "no login in progress"),
+		},
+		{
+			name:           "state mismatch",
+			provider:       "mock",
+			mutateCallback: func(q map[string]string) { q["state"] = "forged" },
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   problemJSON(http.StatusBadRequest, errCodeInvalidRequest, "login state is invalid"),
+		},
+		{
+			name:           "invalid code",
+			provider:       "mock",
+			mutateCallback: func(q map[string]string) { q["code"] = "forged" },
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   problemJSON(http.StatusUnauthorized, errCodeUnauthorized, "failed to verify identity with provider"),
+		},
+		{
+			name:           "provider returned error",
+			provider:       "mock",
+			mutateCallback: func(q map[string]string) { q["error"] = "access_denied" },
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   problemJSON(http.StatusUnauthorized, errCodeUnauthorized, "identity provider returned error: access_denied"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newOIDCTestApp(t, &mockService{}, &mockAuth{}, mockProvider)
+
+			cookie, location := startOIDCLogin(t, app)
+			callback, err := mockProvider.Authorize(location)
+			require.NoError(t, err)
+
+			q := map[string]string{"code": callback.Query().Get("code"), "state": callback.Query().Get("state")}
+			if tc.mutateCallback != nil {
+				tc.mutateCallback(q)
+			}
+			values := callback.Query()
+			for k, v := range q {
+				values.Set(k, v)
+			}
+			callback.RawQuery = values.Encode()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, callback.String(), nil)
+			if !tc.withoutCookie {
+				r.AddCookie(cookie)
+			}
+			r = mux.SetURLVars(r, map[string]string{"provider": tc.provider})
+
+			app.handleOIDCCallback()(w, r)
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			assert.JSONEq(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestHandleOIDCCallback_credentialsRedactedFromLogs(t *testing.T) {
+	mockProvider := oidctest.NewProvider("client-id", "client-secret")
+	defer mockProvider.Close()
+	mockProvider.SetUser(oidctest.User{Subject: "12345", Email: "test@example.com"})
+
+	logger, hook := test.NewNullLogger()
+	app := newOIDCTestAppWithLogger(t, logger, &mockService{}, &mockAuth{}, mockProvider)
+	app.routes()
+
+	cookie, location := startOIDCLogin(t, app)
+	callback, err := mockProvider.Authorize(location)
+	require.NoError(t, err)
+
+	// the code is forged so the login fails, which is also when bodies are logged
+	query := callback.Query()
+	query.Set("code", "forged-code")
+	callback.RawQuery = query.Encode()
+
+	hook.Reset()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, callback.String(), nil)
+	r.AddCookie(cookie)
+
+	app.router.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var logged []logrus.Fields
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["path"] == "/auth/oidc/mock/callback" {
+			logged = append(logged, entry.Data)
+		}
+	}
+
+	require.Len(t, logged, 2, "the request and response are both logged")
+	assert.Equal(t, "code=%5BREDACTED%5D&state=%5BREDACTED%5D", logged[0]["query_parameters"])
+	assert.Equal(t, []string{redacted}, logged[0]["headers"].(http.Header)["Cookie"])
+	for _, entry := range hook.AllEntries() {
+		line, err := entry.String()
+		require.NoError(t, err)
+		assert.NotContains(t, line, "forged-code")
+		assert.NotContains(t, line, query.Get("state"))
+	}
+}
@@ -1,7 +1,6 @@
 package app
 
 import (
-	"io/ioutil"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -9,31 +8,36 @@ import (
 
 const handlePatchUser = "handlePatchUser"
 
-// handlePatchUser handles PATCH requests to /users/{id} in accordance with JSON PATCH RFC6902
-// https://datatracker.ietf.org/doc/html/rfc6902/
-// handlePatchUser will only patch User Attributes. Attempts to patch other User fields like password will be ignored
+// handlePatchUser handles PATCH requests to /users/{id} with either a JSON Patch (RFC 6902) or a JSON Merge Patch (RFC 7396),
+// as given by the Content-Type, see patchFromRequest
+// handlePatchUser will only patch User Attributes. Attempts to patch other User fields like password will be ignored.
+// If-Match must be set to the ETag of the user the patch was based on, so that concurrent patches can't overwrite each other.
+// The patched user is returned when asked for with Prefer: return=representation, see writeUpdated
 func (app *App) handlePatchUser() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		userID := vars[urlVarUserID]
 
-		reqBody, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			apperr := newAppErr("request body unreadable", http.StatusBadRequest)
-			http.Error(w, apperr.Error(), apperr.Code())
+		if r.Header.Get("If-Match") == "" {
+			apperr := newAppErr("If-Match header is required, set it to the ETag of the user being patched", http.StatusPreconditionRequired)
+			httpErrorFromAppErr(w, r, apperr)
 			return
 		}
 
-		defer r.Body.Close()
+		patch, apperr := patchFromRequest(w, r)
+		if apperr != nil {
+			httpErrorFromAppErr(w, r, apperr)
+			return
+		}
 
-		if dErr := app.service.PatchUser(r.Context(), userID, reqBody); dErr != nil {
-			apperr := app.newAppErrFromDomainErr(dErr)
-			http.Error(w, apperr.Error(), apperr.Code())
+		user, dErr := app.service.PatchUser(r.Context(), userID, ifMatchVersions(r), patch)
+		if dErr != nil {
+			apperr := app.newAppErrFromDomainErr(r.Context(), dErr)
+			httpErrorFromAppErr(w, r, apperr)
 			return
 		}
 
-		// PATCH does not return a body
-		// TODO: implement content negotiation to return resource if requested via Accept header
-		w.WriteHeader(http.StatusNoContent)
+		w.Header().Set("ETag", formatETag(user.Version))
+		app.writeUpdated(w, r, handlePatchUser, user)
 	}
 }
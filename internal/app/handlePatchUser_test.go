@@ -1,6 +1,7 @@
 package app
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -22,32 +23,128 @@ func TestHandlePatchUser_successPath(t *testing.T) {
 		{ "op": "replace", "path": "/email", "value": "bar@example.com" }
 	]`
 
-	ms.On("PatchUser", mock.Anything, "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", []byte(patchJSON)).Return(nil)
+	ms.On("PatchUser", mock.Anything, "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", []int64{3}, domain.Patch{Format: domain.JSONPatch, Doc: []byte(patchJSON)}).
+		Return(&domain.User{ID: "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", Version: 4}, nil)
 
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodPatch, "/users/9abc46be-3bcd-42b1-aeb2-ac6ff557a580", strings.NewReader(patchJSON))
+	r.Header.Set("If-Match", `"3"`)
+	r.Header.Set("Content-Type", "application/json-patch+json")
 	r = mux.SetURLVars(r, map[string]string{"userID": "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"})
 
 	app.handlePatchUser()(w, r)
 
 	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"), "the ETag of the patched user")
 	assert.Equal(t, "", w.Body.String())
 
 	ms.AssertExpectations(t)
 }
 
+func TestHandlePatchUser_returnRepresentation_successPath(t *testing.T) {
+	ms := &mockService{}
+	app := New(nil, nullLogger(), nil, "", "", nil, ms)
+
+	patchJSON := `{"user_name": "foo"}`
+	patched := domain.NewUser("9abc46be-3bcd-42b1-aeb2-ac6ff557a580", "foo", "test@example.com", "ac6ff557a5804eff")
+	patched.Version = 4
+	ms.On("PatchUser", mock.Anything, "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", []int64{3}, domain.Patch{Format: domain.MergePatch, Doc: []byte(patchJSON)}).
+		Return(patched, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/users/9abc46be-3bcd-42b1-aeb2-ac6ff557a580", strings.NewReader(patchJSON))
+	r.Header.Set("If-Match", `"3"`)
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	r.Header.Set("Prefer", "return=representation")
+	r = mux.SetURLVars(r, map[string]string{"userID": "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"})
+
+	app.handlePatchUser()(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+	assert.Equal(t, "return=representation", w.Header().Get("Preference-Applied"))
+
+	expectedRespBody, err := json.Marshal(patched)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(expectedRespBody), w.Body.String())
+
+	ms.AssertExpectations(t)
+}
+
+func TestHandlePatchUser_contentTypes_successPath(t *testing.T) {
+	testCases := []struct {
+		name           string
+		contentType    string
+		expectedFormat domain.PatchFormat
+	}{
+		{name: "json patch", contentType: "application/json-patch+json", expectedFormat: domain.JSONPatch},
+		{name: "merge patch", contentType: "application/merge-patch+json; charset=utf-8", expectedFormat: domain.MergePatch},
+		{name: "plain json is taken to be json patch", contentType: "application/json", expectedFormat: domain.JSONPatch},
+		{name: "no content type is taken to be json patch", contentType: "", expectedFormat: domain.JSONPatch},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			ms := &mockService{}
+			app := New(nil, nullLogger(), nil, "", "", nil, ms)
+
+			patchJSON := `{"user_name": "foo"}`
+			ms.On("PatchUser", mock.Anything, "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", []int64{3}, domain.Patch{Format: tc.expectedFormat, Doc: []byte(patchJSON)}).
+				Return(&domain.User{ID: "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", Version: 4}, nil)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPatch, "/users/9abc46be-3bcd-42b1-aeb2-ac6ff557a580", strings.NewReader(patchJSON))
+			r.Header.Set("If-Match", `"3"`)
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			r = mux.SetURLVars(r, map[string]string{"userID": "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"})
+
+			app.handlePatchUser()(w, r)
+
+			assert.Equal(t, http.StatusNoContent, w.Code)
+
+			ms.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandlePatchUser_unsupportedContentType_failurePath(t *testing.T) {
+	ms := &mockService{}
+	app := New(nil, nullLogger(), nil, "", "", nil, ms)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/users/9abc46be-3bcd-42b1-aeb2-ac6ff557a580", strings.NewReader("<user/>"))
+	r.Header.Set("If-Match", `"3"`)
+	r.Header.Set("Content-Type", "application/xml")
+	r = mux.SetURLVars(r, map[string]string{"userID": "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"})
+
+	app.handlePatchUser()(w, r)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Equal(t, "application/json-patch+json, application/merge-patch+json", w.Header().Get("Accept-Patch"))
+	assert.JSONEq(t, problemJSON(
+		http.StatusUnsupportedMediaType,
+		errCodeUnsupportedMediaType,
+		"Content-Type must be one of application/json-patch+json, application/merge-patch+json",
+	), w.Body.String())
+
+	ms.AssertExpectations(t)
+}
+
 func TestHandlePatchUser_requestBodyUnreadable_successPath(t *testing.T) {
 	app := New(nil, nullLogger(), nil, "", "", nil, nil)
 
 	var unreadableBody errReader = 0
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodPatch, "/users/9abc46be-3bcd-42b1-aeb2-ac6ff557a580", unreadableBody)
+	r.Header.Set("If-Match", `"3"`)
 	r = mux.SetURLVars(r, map[string]string{"userID": "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"})
 
 	app.handlePatchUser()(w, r)
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
-	assert.Equal(t, `{"error": "request body unreadable"}`, strings.TrimRight(w.Body.String(), "\n"))
+	assert.JSONEq(t, problemJSON(http.StatusBadRequest, errCodeInvalidRequest, "request body unreadable"), w.Body.String())
 }
 
 func TestHandlePatchUser_serviceErr_failurePath(t *testing.T) {
@@ -61,13 +158,19 @@ func TestHandlePatchUser_serviceErr_failurePath(t *testing.T) {
 			name:           "service returns InvalidInput error",
 			domainErrType:  domain.InvalidInput,
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `{"error": "invalid input data - test error"}`,
+			expectedBody:   problemJSON(http.StatusBadRequest, errCodeInvalidInput, "test error"),
+		},
+		{
+			name:           "service returns PreconditionFailed error",
+			domainErrType:  domain.PreconditionFailed,
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedBody:   problemJSON(http.StatusPreconditionFailed, errCodePreconditionFailed, "test error"),
 		},
 		{
 			name:           "service returns system error",
 			domainErrType:  domain.SystemError,
 			expectedStatus: http.StatusInternalServerError,
-			expectedBody:   `{"error": "unexpected system error - test error"}`,
+			expectedBody:   problemJSON(http.StatusInternalServerError, errCodeSystemError, "test error"),
 		},
 	}
 
@@ -81,17 +184,18 @@ func TestHandlePatchUser_serviceErr_failurePath(t *testing.T) {
 				{ "op": "replace", "path": "/email", "value": "bar@example.com" }
 			]`
 
-			ms.On("PatchUser", mock.Anything, "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", []byte(patchJSON)).
-				Return(&domain.Error{Code: tc.domainErrType, Msg: "test error"})
+			ms.On("PatchUser", mock.Anything, "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", []int64{3}, domain.Patch{Format: domain.JSONPatch, Doc: []byte(patchJSON)}).
+				Return(nil, &domain.Error{Code: tc.domainErrType, Msg: "test error"})
 
 			w := httptest.NewRecorder()
 			r := httptest.NewRequest(http.MethodPatch, "/users/9abc46be-3bcd-42b1-aeb2-ac6ff557a580", strings.NewReader(patchJSON))
+			r.Header.Set("If-Match", `"3"`)
 			r = mux.SetURLVars(r, map[string]string{"userID": "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"})
 
 			app.handlePatchUser()(w, r)
 
 			assert.Equal(t, tc.expectedStatus, w.Code)
-			assert.Equal(t, tc.expectedBody, strings.TrimRight(w.Body.String(), "\n"))
+			assert.JSONEq(t, tc.expectedBody, w.Body.String())
 
 			ms.AssertExpectations(t)
 		})
@@ -106,17 +210,38 @@ func TestHandlePatchUser_userNotFound_failurePath(t *testing.T) {
 		{ "op": "replace", "path": "/user_name", "value": "foo" },
 		{ "op": "replace", "path": "/email", "value": "bar@example.com" }
 	]`
-	ms.On("PatchUser", mock.Anything, "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", []byte(patchJSON)).
-		Return(&domain.Error{Code: domain.ResourceNotFound, Msg: "user does not exist"})
+	ms.On("PatchUser", mock.Anything, "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", []int64(nil), domain.Patch{Format: domain.JSONPatch, Doc: []byte(patchJSON)}).
+		Return(nil, &domain.Error{Code: domain.ResourceNotFound, Msg: "user does not exist"})
 
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodPatch, "/users/9abc46be-3bcd-42b1-aeb2-ac6ff557a580", strings.NewReader(patchJSON))
+	r.Header.Set("If-Match", "*")
 	r = mux.SetURLVars(r, map[string]string{"userID": "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"})
 
 	app.handlePatchUser()(w, r)
 
 	assert.Equal(t, http.StatusNotFound, w.Code)
-	assert.Equal(t, `{"error": "resource not found - user does not exist"}`, strings.TrimRight(w.Body.String(), "\n"))
+	assert.JSONEq(t, problemJSON(http.StatusNotFound, errCodeNotFound, "user does not exist"), w.Body.String())
+
+	ms.AssertExpectations(t)
+}
+
+func TestHandlePatchUser_missingIfMatch_failurePath(t *testing.T) {
+	ms := &mockService{}
+	app := New(nil, nullLogger(), nil, "", "", nil, ms)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/users/9abc46be-3bcd-42b1-aeb2-ac6ff557a580", strings.NewReader("[]"))
+	r = mux.SetURLVars(r, map[string]string{"userID": "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"})
+
+	app.handlePatchUser()(w, r)
+
+	assert.Equal(t, http.StatusPreconditionRequired, w.Code)
+	assert.JSONEq(t, problemJSON(
+		http.StatusPreconditionRequired,
+		errCodePreconditionRequired,
+		"If-Match header is required, set it to the ETag of the user being patched",
+	), w.Body.String())
 
 	ms.AssertExpectations(t)
 }
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	handlerReadyz = "handleReadyz"
+
+	readyStatusReady        = "ready"
+	readyStatusNotReady     = "not_ready"
+	readyStatusShuttingDown = "shutting_down"
+
+	checkStatusOK    = "ok"
+	checkStatusError = "error"
+
+	// dependencyCheckTimeout stops a hung dependency from hanging the readiness probe
+	dependencyCheckTimeout = 2 * time.Second
+)
+
+// DependencyCheck checks that a dependency the app needs to serve requests, such as the DB, is reachable
+type DependencyCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// WithReadinessChecks makes /readyz check the given dependencies
+func WithReadinessChecks(checks ...DependencyCheck) Option {
+	return func(app *App) {
+		app.readinessChecks = append(app.readinessChecks, checks...)
+	}
+}
+
+type checkResult struct {
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type readyzResponse struct {
+	Status  string                 `json:"status"`
+	Version string                 `json:"version"`
+	Checks  map[string]checkResult `json:"checks,omitempty"`
+}
+
+// handleReadyz tells load balancers whether to send the app traffic. It is not ready if any dependency check fails,
+// or as soon as shutdown begins
+func (app *App) handleReadyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := readyzResponse{Status: readyStatusReady, Version: app.version}
+		status := http.StatusOK
+
+		if !app.isReady() {
+			resp.Status = readyStatusShuttingDown
+			status = http.StatusServiceUnavailable
+		} else if len(app.readinessChecks) > 0 {
+			resp.Checks = app.checkDependencies(r.Context())
+			for _, res := range resp.Checks {
+				if res.Status != checkStatusOK {
+					resp.Status = readyStatusNotReady
+					status = http.StatusServiceUnavailable
+				}
+			}
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			app.logger.WithContext(r.Context()).WithField(appHandler, handlerReadyz).WithError(err).Error("failed to marshal json response")
+			httpErrorFromAppErr(w, r, newAppErr("failed to marshal json response", http.StatusInternalServerError))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		w.Write(respBytes)
+	}
+}
+
+// checkDependencies runs the readiness checks concurrently. Errors are logged rather than returned as they may reveal
+// details of the infrastructure
+func (app *App) checkDependencies(ctx context.Context) map[string]checkResult {
+	ctx, cancel := context.WithTimeout(ctx, dependencyCheckTimeout)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]checkResult, len(app.readinessChecks))
+	)
+
+	for _, check := range app.readinessChecks {
+		wg.Add(1)
+		go func(check DependencyCheck) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := check.Check(ctx)
+			res := checkResult{Status: checkStatusOK, DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				app.logger.WithContext(ctx).WithField(appHandler, handlerReadyz).WithField("dependency", check.Name).WithError(err).Warn("dependency check failed")
+				res.Status = checkStatusError
+			}
+
+			mu.Lock()
+			results[check.Name] = res
+			mu.Unlock()
+		}(check)
+	}
+
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReadyzTestApp(checks ...DependencyCheck) (*App, *mux.Router) {
+	router := mux.NewRouter()
+	app := New(router, nullLogger(), nil, "v1.2.3", "", nil, nil, WithReadinessChecks(checks...))
+	app.routes()
+	atomic.StoreInt32(&app.ready, 1)
+
+	return app, router
+}
+
+func TestHandleHealthz(t *testing.T) {
+	_, router := newReadyzTestApp(DependencyCheck{Name: "db", Check: func(ctx context.Context) error {
+		return errors.New("liveness must not depend on the db")
+	}})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok","version":"v1.2.3"}`, w.Body.String())
+}
+
+func TestHandleReadyz_successPath(t *testing.T) {
+	_, router := newReadyzTestApp(
+		DependencyCheck{Name: "db", Check: func(ctx context.Context) error { return nil }},
+		DependencyCheck{Name: "cache", Check: func(ctx context.Context) error { return nil }},
+	)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var resp readyzResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, readyStatusReady, resp.Status)
+	assert.Equal(t, "v1.2.3", resp.Version)
+	assert.Equal(t, checkStatusOK, resp.Checks["db"].Status)
+	assert.Equal(t, checkStatusOK, resp.Checks["cache"].Status)
+}
+
+func TestHandleReadyz_dependencyFailing_failurePath(t *testing.T) {
+	_, router := newReadyzTestApp(
+		DependencyCheck{Name: "db", Check: func(ctx context.Context) error { return nil }},
+		DependencyCheck{Name: "cache", Check: func(ctx context.Context) error {
+			return errors.New("dial tcp 10.0.0.7:6379: connection refused")
+		}},
+	)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	// the cause is logged rather than exposed
+	assert.NotContains(t, w.Body.String(), "10.0.0.7")
+
+	var resp readyzResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, readyStatusNotReady, resp.Status)
+	assert.Equal(t, checkStatusOK, resp.Checks["db"].Status)
+	assert.Equal(t, checkStatusError, resp.Checks["cache"].Status)
+}
+
+func TestHandleReadyz_dependencyHangs_failurePath(t *testing.T) {
+	_, router := newReadyzTestApp(DependencyCheck{Name: "db", Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Less(t, int64(time.Since(start)), int64(dependencyCheckTimeout+time.Second))
+}
+
+func TestHandleReadyz_shuttingDown(t *testing.T) {
+	app, router := newReadyzTestApp(DependencyCheck{Name: "db", Check: func(ctx context.Context) error { return nil }})
+	atomic.StoreInt32(&app.ready, 0)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"status":"shutting_down","version":"v1.2.3"}`, w.Body.String())
+}
@@ -6,45 +6,148 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	authSchemeBearer = "Bearer "
+	authSchemeAPIKey = "ApiKey "
+)
+
+type contextKey int
+
+const (
+	ctxKeyPrincipal contextKey = iota
+)
+
+// principal is who a request has been authenticated as
+type principal struct {
+	Subject string
+	// APIKey is set when the request was authenticated with an API key rather than an access token,
+	// in which case the request is restricted to the key's scopes
+	APIKey *domain.APIKey
+}
+
+// hasScope reports whether the principal may act within the scope. Access tokens carry every scope
+func (p *principal) hasScope(scope string) bool {
+	return p.APIKey == nil || p.APIKey.HasScope(scope)
+}
+
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, plaintext string) (*domain.APIKey, *domain.Error)
+}
+
 type TokenValidator struct {
 	logger  *logrus.Entry
 	decoder TokenDecoder
+	apiKeys APIKeyAuthenticator
+	// rateLimiter, when set, counts rejected credentials against the client IP's bucket
+	rateLimiter *RateLimiter
 }
 
-func NewTokenValidator(l *logrus.Entry) *TokenValidator {
-	return &TokenValidator{logger: l.WithField("middleware", "TokenValidator")}
+func NewTokenValidator(l *logrus.Entry, decoder TokenDecoder, apiKeys APIKeyAuthenticator, rateLimiter *RateLimiter) *TokenValidator {
+	return &TokenValidator{logger: l.WithField("middleware", "TokenValidator"), decoder: decoder, apiKeys: apiKeys, rateLimiter: rateLimiter}
 }
 
+// Middleware identifies who is making the request. Requests without credentials are passed on anonymously,
+// routes which need a principal must also use RequireAuthentication
 func (tv *TokenValidator) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeaderVal := r.Header.Get("Authorization")
 		if authHeaderVal == "" {
-			tv.logger.Info("no token found in request")
-			appErr := newAppErr("no token found in request", http.StatusUnauthorized)
-			http.Error(w, appErr.Error(), appErr.code)
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeaderVal, "Bearer ")
-		if authHeaderVal == tokenString {
-			appErr := newAppErr("auth header value in unexpected format", http.StatusUnauthorized)
-			http.Error(w, appErr.Error(), appErr.code)
+		var p *principal
+		switch {
+		case strings.HasPrefix(authHeaderVal, authSchemeBearer):
+			claims, err := tv.decoder.GetClaims(strings.TrimPrefix(authHeaderVal, authSchemeBearer))
+			if err != nil {
+				tv.reject(w, r, newAppErr(fmt.Sprintf("invalid token: %s", err.Error()), http.StatusUnauthorized))
+				return
+			}
+
+			p = &principal{Subject: claims.Subject}
+		case strings.HasPrefix(authHeaderVal, authSchemeAPIKey) && tv.apiKeys != nil:
+			key, dErr := tv.apiKeys.AuthenticateAPIKey(r.Context(), strings.TrimPrefix(authHeaderVal, authSchemeAPIKey))
+			if dErr != nil && dErr.Code == domain.Unauthorized {
+				tv.reject(w, r, appErrFromDomainErr(tv.logger.WithContext(r.Context()), dErr))
+				return
+			} else if dErr != nil {
+				httpErrorFromAppErr(w, r, appErrFromDomainErr(tv.logger.WithContext(r.Context()), dErr))
+				return
+			}
+
+			p = &principal{Subject: key.UserID, APIKey: key}
+		default:
+			tv.reject(w, r, newAppErr("auth header value in unexpected format", http.StatusUnauthorized))
 			return
 		}
 
-		claims, err := tv.decoder.GetClaims(tokenString)
-		if err != nil {
-			appErr := newAppErr(fmt.Sprintf("invalid token: %s", err.Error()), http.StatusUnauthorized)
-			http.Error(w, appErr.Error(), appErr.code)
+		// include the principal in the context as this is needed at the domain level
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyPrincipal, p)))
+	})
+}
+
+// reject writes the error for a request whose credentials were rejected, or a 429 once its client IP has been rejected too often
+func (tv *TokenValidator) reject(w http.ResponseWriter, r *http.Request, appErr *appErr) {
+	if tv.rateLimiter != nil && !tv.rateLimiter.takeFailedAuthentication(w, r) {
+		return
+	}
+
+	httpErrorFromAppErr(w, r, appErr)
+}
+
+// RequireAuthentication rejects requests which the TokenValidator middleware did not authenticate
+func RequireAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principalFromContext(r.Context()) == nil {
+			appErr := newAppErr("no token found in request", http.StatusUnauthorized)
+			httpErrorFromAppErr(w, r, appErr)
 			return
 		}
 
-		// include the subject in the context as this is needed at the domain level
-		r.WithContext(context.WithValue(r.Context(), "subject", claims.Subject))
-
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireScope rejects requests made with an API key which lacks the scope. Access tokens carry every scope,
+// and whether anonymous requests are allowed is left to the route. It must run after the TokenValidator middleware
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p := principalFromContext(r.Context()); p != nil && !p.hasScope(scope) {
+				appErr := newAppErr(fmt.Sprintf("api key lacks scope %s", scope), http.StatusForbidden)
+				httpErrorFromAppErr(w, r, appErr)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// principalFromContext returns who the request was authenticated as, or nil if it was not authenticated
+func principalFromContext(ctx context.Context) *principal {
+	p, _ := ctx.Value(ctxKeyPrincipal).(*principal)
+	return p
+}
+
+// requireUserSession writes a 403 and returns false unless the request was made by the given user with an access token.
+// API keys are not accepted so that a leaked key can't be used to mint further credentials
+func requireUserSession(w http.ResponseWriter, r *http.Request, userID string) bool {
+	p := principalFromContext(r.Context())
+	if p == nil || p.Subject != userID {
+		appErr := newAppErr("not permitted to access this resource", http.StatusForbidden)
+		httpErrorFromAppErr(w, r, appErr)
+		return false
+	} else if p.APIKey != nil {
+		appErr := newAppErr("api keys cannot be used to manage credentials", http.StatusForbidden)
+		httpErrorFromAppErr(w, r, appErr)
+		return false
+	}
+
+	return true
+}
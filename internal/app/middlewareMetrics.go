@@ -0,0 +1,65 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/gorilla/mux"
+)
+
+// Metrics records measurements of the app, see metrics.Metrics
+type Metrics interface {
+	ObserveRequest(route, method string, status int, duration time.Duration)
+	CountDomainError(errType domain.ErrorType)
+	Handler() http.Handler
+}
+
+// WithMetrics records request and domain error metrics. Run serves them from /metrics on a separate listener at addr,
+// rather than alongside the API, so they can be kept internal. They are not served when addr is nil
+func WithMetrics(m Metrics, addr net.Addr) Option {
+	return func(app *App) {
+		app.metrics = m
+		app.metricsAddr = addr
+	}
+}
+
+// metricsHandler serves the metrics. It is kept off app.router so that the route, status and latency data aren't public
+func (app *App) metricsHandler() http.Handler {
+	router := mux.NewRouter()
+	router.Handle("/metrics", app.metrics.Handler()).Methods(http.MethodGet)
+
+	return router
+}
+
+// statusRecorder captures the response status without buffering the body
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	w.status = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// metricsMiddleware must be used on the root router so it measures everything, including rejections by other middleware.
+// mux only runs middleware for matched routes, so requests matching no route aren't measured
+func (app *App) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		app.metrics.ObserveRequest(route, r.Method, recorder.status, time.Since(start))
+	})
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockMetrics struct {
+	mock.Mock
+}
+
+func (mm *mockMetrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	mm.Called(route, method, status, duration)
+}
+
+func (mm *mockMetrics) CountDomainError(errType domain.ErrorType) {
+	mm.Called(errType)
+}
+
+func (mm *mockMetrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	})
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	ms := &mockService{}
+	ms.On("GetUser", mock.Anything, "9abc46be-3bcd-42b1-aeb2-ac6ff557a580").
+		Return(nil, &domain.Error{Code: domain.ResourceNotFound, Msg: "user not found"})
+
+	mm := &mockMetrics{}
+	// IDs in paths are replaced by the route template
+	mm.On("ObserveRequest", "/api/v1/users/{userID}", http.MethodGet, http.StatusNotFound, mock.Anything).Once()
+	mm.On("CountDomainError", domain.ResourceNotFound).Once()
+
+	router := mux.NewRouter()
+	app := New(router, nullLogger(), nil, "", "", nil, ms, WithMetrics(mm, nil))
+	app.routes()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/users/9abc46be-3bcd-42b1-aeb2-ac6ff557a580", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// metrics aren't public, they're only served by the separate metrics listener
+	assert.False(t, router.Match(httptest.NewRequest(http.MethodGet, "/metrics", nil), &mux.RouteMatch{}))
+
+	w = httptest.NewRecorder()
+	app.metricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "metrics", w.Body.String())
+
+	mm.AssertExpectations(t)
+	ms.AssertExpectations(t)
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/ratelimit"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	rateLimitBudgetDefault = "default"
+	// rateLimitBudgetExpensive has its own, smaller, bucket for routes which are costly to serve
+	// e.g. those hashing passwords, and uploads and search once they exist
+	rateLimitBudgetExpensive = "expensive"
+)
+
+// RateLimits are the token bucket limits applied to each caller
+type RateLimits struct {
+	Default   ratelimit.Limit
+	Expensive ratelimit.Limit
+}
+
+type RateLimitAllower interface {
+	Allow(ctx context.Context, key string, limit ratelimit.Limit) (*ratelimit.Result, error)
+}
+
+// WithRateLimiting limits how often each caller can call the api v1 routes
+func WithRateLimiting(limiter RateLimitAllower, limits RateLimits) Option {
+	return func(app *App) {
+		app.rateLimiter = NewRateLimiter(app.logger, limiter, limits)
+	}
+}
+
+type RateLimiter struct {
+	logger  *logrus.Entry
+	limiter RateLimitAllower
+	budgets map[string]ratelimit.Limit
+}
+
+func NewRateLimiter(l *logrus.Entry, limiter RateLimitAllower, limits RateLimits) *RateLimiter {
+	return &RateLimiter{
+		logger:  l.WithField("middleware", "RateLimiter"),
+		limiter: limiter,
+		budgets: map[string]ratelimit.Limit{
+			rateLimitBudgetDefault:   limits.Default,
+			rateLimitBudgetExpensive: limits.Expensive,
+		},
+	}
+}
+
+// Middleware counts the request against the caller's bucket for the matched route's budget.
+// It must run after the TokenValidator middleware so that authenticated callers are limited by who they are rather than their IP
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget := rateLimitBudgetDefault
+		if route := mux.CurrentRoute(r); route != nil && expensiveRoutes[route.GetName()] {
+			budget = rateLimitBudgetExpensive
+		}
+
+		if rl.take(w, r, budget, rateLimitKey(r)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// takeFailedAuthentication counts a request whose credentials were rejected against its client IP's bucket,
+// as the request never reaches Middleware. Otherwise guessing credentials would be unlimited
+func (rl *RateLimiter) takeFailedAuthentication(w http.ResponseWriter, r *http.Request) bool {
+	return rl.take(w, r, rateLimitBudgetDefault, "ip:"+clientIP(r))
+}
+
+// take counts the request against the key's bucket for the budget, setting the RateLimit headers.
+// When the bucket is empty it writes a 429 and returns false
+func (rl *RateLimiter) take(w http.ResponseWriter, r *http.Request, budget, key string) bool {
+	res, err := rl.limiter.Allow(r.Context(), budget+":"+key, rl.budgets[budget])
+	if err != nil {
+		// better to serve requests unlimited than not at all
+		rl.logger.WithContext(r.Context()).WithError(err).Warn("failed to apply rate limit")
+		return true
+	}
+
+	// https://datatracker.ietf.org/doc/html/draft-ietf-httpapi-ratelimit-headers
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+
+	if !res.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+		appErr := newAppErr("rate limit exceeded", http.StatusTooManyRequests)
+		httpErrorFromAppErr(w, r, appErr)
+		return false
+	}
+
+	return true
+}
+
+// rateLimitKey identifies the caller. API keys have their own budget, separate from their owner's access tokens
+func rateLimitKey(r *http.Request) string {
+	p := principalFromContext(r.Context())
+	switch {
+	case p == nil:
+		return "ip:" + clientIP(r)
+	case p.APIKey != nil:
+		return "key:" + p.APIKey.ID
+	default:
+		return "user:" + p.Subject
+	}
+}
+
+// ceilSeconds rounds up so clients don't retry just before they're allowed to
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
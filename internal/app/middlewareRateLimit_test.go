@@ -0,0 +1,156 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/ratelimit"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newRateLimitedTestApp(ms *mockService, ma *mockAuth) *App {
+	app := New(mux.NewRouter(), nullLogger(), nil, "", "", ma, ms, WithRateLimiting(
+		ratelimit.NewLimiter(ratelimit.NewMemoryStore()),
+		RateLimits{
+			Default:   ratelimit.Limit{Burst: 2, Period: time.Minute},
+			Expensive: ratelimit.Limit{Burst: 1, Period: time.Minute},
+		},
+	))
+	app.routes()
+
+	return app
+}
+
+func TestRateLimiter(t *testing.T) {
+	ms := &mockService{}
+	ma := &mockAuth{}
+	app := newRateLimitedTestApp(ms, ma)
+
+	u := domain.NewUser(mfaTestUserID, "test", "test@example.com", "ac6ff557a5804eff")
+	ms.On("GetUser", mock.Anything, u.ID).Return(u, nil)
+	ms.On("CreateUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(u, nil)
+	ma.On("GetClaims", "token").Return(claimsFor(u.ID), nil)
+
+	do := func(method, path, remoteAddr, authHeader string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(`{"user_name":"test","email":"test@example.com","password":"password"}`))
+		r.RemoteAddr = remoteAddr
+		r.Header.Set(reqIDHeader, testRequestID)
+		if authHeader != "" {
+			r.Header.Set("Authorization", authHeader)
+		}
+
+		app.router.ServeHTTP(w, r)
+
+		return w
+	}
+
+	getUser := fmt.Sprintf("/api/v1/users/%s", u.ID)
+
+	w := do(http.MethodGet, getUser, "192.0.2.1:1234", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("RateLimit-Reset"))
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, getUser, "192.0.2.1:1234", "").Code)
+
+	w = do(http.MethodGet, getUser, "192.0.2.1:1234", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, problemJSONWithInstance(http.StatusTooManyRequests, errCodeTooManyRequests, "rate limit exceeded", testRequestID), w.Body.String())
+
+	// each client IP has its own bucket
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, getUser, "192.0.2.2:1234", "").Code)
+
+	// expensive routes have a separate, smaller, budget
+	w = do(http.MethodPost, "/api/v1/users", "192.0.2.1:1234", "")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/api/v1/users", "192.0.2.1:1234", "").Code)
+
+	// authenticated callers are limited by who they are, wherever they call from
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, getUser, "192.0.2.1:1234", "Bearer token").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, getUser, "192.0.2.3:1234", "Bearer token").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, getUser, "192.0.2.4:1234", "Bearer token").Code)
+}
+
+func TestRateLimiter_apiKeys(t *testing.T) {
+	ms := &mockService{}
+	ma := &mockAuth{}
+	app := newRateLimitedTestApp(ms, ma)
+
+	u := domain.NewUser(mfaTestUserID, "test", "test@example.com", "ac6ff557a5804eff")
+	ms.On("GetUser", mock.Anything, u.ID).Return(u, nil)
+	ms.On("AuthenticateAPIKey", mock.Anything, "gbs_first").
+		Return(&domain.APIKey{ID: "first-key-id", UserID: u.ID, Scopes: []string{domain.ScopeUsersRead}}, nil)
+	ms.On("AuthenticateAPIKey", mock.Anything, "gbs_second").
+		Return(&domain.APIKey{ID: "second-key-id", UserID: u.ID, Scopes: []string{domain.ScopeUsersRead}}, nil)
+	ma.On("GetClaims", "token").Return(claimsFor(u.ID), nil)
+
+	do := func(remoteAddr, authHeader string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/users/%s", u.ID), nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Authorization", authHeader)
+
+		app.router.ServeHTTP(w, r)
+
+		return w
+	}
+
+	// each key is limited by itself, wherever it's called from
+	assert.Equal(t, http.StatusOK, do("192.0.2.1:1234", "ApiKey gbs_first").Code)
+	assert.Equal(t, http.StatusOK, do("192.0.2.2:1234", "ApiKey gbs_first").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do("192.0.2.3:1234", "ApiKey gbs_first").Code)
+
+	// so neither the owner's other keys nor their access tokens are affected
+	assert.Equal(t, http.StatusOK, do("192.0.2.1:1234", "ApiKey gbs_second").Code)
+	assert.Equal(t, http.StatusOK, do("192.0.2.1:1234", "Bearer token").Code)
+}
+
+func TestRateLimiter_failedAuthentication_failurePath(t *testing.T) {
+	ms := &mockService{}
+	ma := &mockAuth{}
+	app := newRateLimitedTestApp(ms, ma)
+	ms.On("AuthenticateAPIKey", mock.Anything, mock.Anything).Return(nil, &domain.Error{Code: domain.Unauthorized, Msg: "api key is invalid"})
+
+	do := func(remoteAddr, authHeader string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/users/%s/api-keys", mfaTestUserID), nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set(reqIDHeader, testRequestID)
+		r.Header.Set("Authorization", authHeader)
+
+		app.router.ServeHTTP(w, r)
+
+		return w
+	}
+
+	// rejected credentials never reach the rate limiting middleware, so are counted against the client IP
+	for i := 0; i < 2; i++ {
+		w := do("192.0.2.1:1234", fmt.Sprintf("ApiKey gbs_guess%d", i))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, strconv.Itoa(1-i), w.Header().Get("RateLimit-Remaining"))
+	}
+
+	w := do("192.0.2.1:1234", "ApiKey gbs_guess2")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, problemJSONWithInstance(http.StatusTooManyRequests, errCodeTooManyRequests, "rate limit exceeded", testRequestID), w.Body.String())
+
+	// as are credentials in other schemes
+	assert.Equal(t, http.StatusTooManyRequests, do("192.0.2.1:1234", "Basic dXNlcjpwYXNz").Code)
+
+	// and other clients are unaffected
+	assert.Equal(t, http.StatusUnauthorized, do("192.0.2.2:1234", "ApiKey gbs_guess3").Code)
+}
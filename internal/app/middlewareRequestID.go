@@ -1,30 +1,44 @@
 package app
 
 import (
-	"context"
 	"net/http"
+	"regexp"
 
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/requestid"
 	"github.com/sirupsen/logrus"
 )
 
-const reqIDHeader = "X-Request-ID"
+const (
+	reqIDHeader = "X-Request-ID"
 
-type middlewareRequestID struct {
+	// request IDs are standard nanoIDs, with 126 bits of randomness
+	requestIDAlphabet = "_-0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	requestIDLength   = 21
+)
+
+// validRequestID limits request IDs from callers, which end up in logs, to something that looks like an ID
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestIdentifier gives each request an ID, carried in the request context and echoed in the response header.
+// IDs set by callers, e.g. an upstream proxy, are kept so that a request can be followed across services
+type RequestIdentifier struct {
 	logger *logrus.Entry
-	idTool nanoID
+	idTool domain.IDGenerator
 }
 
-func NewmiddlewareRequestID(l *logrus.Entry) *middlewareRequestID {
-	return &middlewareRequestID{logger: l.WithField("middleware", "middlewareRequestID")}
+func NewRequestIdentifier(l *logrus.Entry, idTool domain.IDGenerator) *RequestIdentifier {
+	return &RequestIdentifier{logger: l.WithField("middleware", "RequestIdentifier"), idTool: idTool}
 }
 
-func (mw *middlewareRequestID) Middleware(next http.Handler) http.Handler {
+func (ri *RequestIdentifier) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		reqID := r.Header.Get(reqIDHeader)
-		if reqID == "" {
-			generatedID, err := mw.idTool.New()
+		if !validRequestID.MatchString(reqID) {
+			generatedID, err := ri.idTool.New()
 			if err != nil {
-				mw.logger.Errorf("failed to generate request ID: %s", err.Error())
+				// not worth failing the request over
+				ri.logger.WithError(err).Error("failed to generate request ID")
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -32,6 +46,7 @@ func (mw *middlewareRequestID) Middleware(next http.Handler) http.Handler {
 			reqID = generatedID
 		}
 
-		r.WithContext(context.WithValue(r.Context(), reqIDHeader, reqID))
+		w.Header().Set(reqIDHeader, reqID)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), reqID)))
 	})
 }
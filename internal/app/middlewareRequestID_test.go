@@ -0,0 +1,80 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockIDGenerator struct {
+	mock.Mock
+}
+
+func (mig *mockIDGenerator) New() (string, error) {
+	args := mig.Called()
+	return args.String(0), args.Error(1)
+}
+
+func TestRequestIdentifier(t *testing.T) {
+	testCases := []struct {
+		name          string
+		headerVal     string
+		generatedID   string
+		generateErr   error
+		expectedReqID string
+	}{
+		{
+			name:          "no request ID",
+			generatedID:   "V1StGXR8_Z5jdHi6B-myT",
+			expectedReqID: "V1StGXR8_Z5jdHi6B-myT",
+		},
+		{
+			name:          "request ID set by caller",
+			headerVal:     "a4b6d1b2-9f5e-4c8e-8a55-d8c5e4b4b3b1",
+			expectedReqID: "a4b6d1b2-9f5e-4c8e-8a55-d8c5e4b4b3b1",
+		},
+		{
+			name:          "request ID set by caller is not an ID",
+			headerVal:     "x\n{\"level\":\"error\"}",
+			generatedID:   "V1StGXR8_Z5jdHi6B-myT",
+			expectedReqID: "V1StGXR8_Z5jdHi6B-myT",
+		},
+		{
+			name:        "failed to generate request ID",
+			generateErr: errors.New("no entropy"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mig := &mockIDGenerator{}
+			if tc.generatedID != "" || tc.generateErr != nil {
+				mig.On("New").Return(tc.generatedID, tc.generateErr).Once()
+			}
+
+			var ctxReqID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxReqID = requestid.FromContext(r.Context())
+			})
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			if tc.headerVal != "" {
+				r.Header.Set(reqIDHeader, tc.headerVal)
+			}
+
+			NewRequestIdentifier(nullLogger().WithField("test", t.Name()), mig).Middleware(next).ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.expectedReqID, ctxReqID)
+			assert.Equal(t, tc.expectedReqID, w.Header().Get(reqIDHeader))
+
+			mig.AssertExpectations(t)
+		})
+	}
+}
@@ -1,18 +1,90 @@
 package app
 
 import (
+	"bytes"
+	"io"
+	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/requestid"
 	"github.com/sirupsen/logrus"
 )
 
-const reqIDHeader1 = "X-Request-ID"
+// BodyLogging controls when request and response bodies are logged
+type BodyLogging string
+
+const (
+	BodyLoggingOff BodyLogging = "off"
+	// BodyLoggingErrors logs bodies only when the response is a 4xx or 5xx
+	BodyLoggingErrors BodyLogging = "errors"
+	// BodyLoggingSampled logs bodies for a random fraction of requests
+	BodyLoggingSampled BodyLogging = "sampled"
+)
+
+// RequestLogging configures RequestResponseLogger
+type RequestLogging struct {
+	Bodies BodyLogging
+	// SampleRate is the fraction of requests whose bodies are logged when Bodies is BodyLoggingSampled
+	SampleRate float64
+	// MaxBodyBytes caps how much of each body is kept. JSON bodies over the cap aren't logged as they can't be redacted
+	MaxBodyBytes int
+	// RedactFields are JSON fields and query parameters, matched case insensitively, whose values are never logged
+	RedactFields []string
+	// RedactHeaders are headers whose values are never logged
+	RedactHeaders []string
+}
+
+// DefaultRequestLogging redacts every credential the API accepts or returns
+var DefaultRequestLogging = RequestLogging{
+	Bodies:       BodyLoggingErrors,
+	SampleRate:   0.01,
+	MaxBodyBytes: 4 << 10,
+	RedactFields: []string{
+		"password", "access_token", "mfa_token", "code", "state", "secret", "key", "otpauth_uri", "qr_code", "recovery_codes",
+	},
+	RedactHeaders: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+}
+
+// WithRequestLogging overrides DefaultRequestLogging
+func WithRequestLogging(cfg RequestLogging) Option {
+	return func(app *App) {
+		app.requestLogging = cfg
+	}
+}
+
+// cappedBuffer keeps at most max bytes of what is written to it, noting whether anything was dropped
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+
+		return len(p), nil
+	}
+
+	return b.Buffer.Write(p)
+}
+
+// teeReadCloser captures the request body as the handler reads it
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
 
 type responseWriterRecorder struct {
 	http.ResponseWriter
 	status int
-	body   string
+	// body is nil unless the body is being captured
+	body *cappedBuffer
 }
 
 func (w *responseWriterRecorder) WriteHeader(statusCode int) {
@@ -21,52 +93,127 @@ func (w *responseWriterRecorder) WriteHeader(statusCode int) {
 }
 
 func (w *responseWriterRecorder) Write(bs []byte) (int, error) {
-	w.body += string(bs)
+	if w.body != nil {
+		w.body.Write(bs)
+	}
+
 	return w.ResponseWriter.Write(bs)
 }
 
 type RequestResponseLogger struct {
-	logger          *logrus.Entry
-	logResponseBody bool
+	logger    *logrus.Entry
+	cfg       RequestLogging
+	redaction *redaction
+	// sample returns a number in [0, 1), it is swapped out by tests
+	sample func() float64
 }
 
-func NewRequestResponseLogger(l *logrus.Entry) *RequestResponseLogger {
-	return &RequestResponseLogger{logger: l.WithField("middleware", "RequestResponseLogger")}
+func NewRequestResponseLogger(l *logrus.Entry, cfg RequestLogging) *RequestResponseLogger {
+	return &RequestResponseLogger{
+		logger:    l.WithField("middleware", "RequestResponseLogger"),
+		cfg:       cfg,
+		redaction: newRedaction(cfg.RedactFields, cfg.RedactHeaders),
+		sample:    rand.Float64,
+	}
 }
 
 func (rrl *RequestResponseLogger) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		reqID := r.Header.Get(reqIDHeader)
+		reqID := requestid.FromContext(r.Context())
+		logger := rrl.logger.WithContext(r.Context())
 
-		rrl.logger.WithFields(
+		logger.WithFields(
 			logrus.Fields{
 				"type":             "request",
 				"path":             r.URL.Path,
-				"query_parameters": r.URL.RawQuery,
+				"query_parameters": rrl.redaction.query(r.URL.RawQuery),
 				"method":           r.Method,
 				"request_id":       reqID,
 				"src_host":         r.RemoteAddr,
+				"headers":          rrl.redaction.headers(r.Header),
+				"client":           newClientInfo(r),
 			},
 		).Info("incoming request")
 
 		start := time.Now().UTC()
 
-		customResponseWriter := &responseWriterRecorder{ResponseWriter: w}
-		next.ServeHTTP(customResponseWriter, r)
+		recorder := &responseWriterRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		var reqBody *cappedBuffer
+		if rrl.captureBodies() {
+			reqBody = &cappedBuffer{max: rrl.cfg.MaxBodyBytes}
+			recorder.body = &cappedBuffer{max: rrl.cfg.MaxBodyBytes}
+			r.Body = teeReadCloser{Reader: io.TeeReader(r.Body, reqBody), Closer: r.Body}
+		}
+
+		next.ServeHTTP(recorder, r)
 
 		end := time.Now().UTC()
 
-		rrl.logger.WithFields(
-			logrus.Fields{
-				"type":          "response",
-				"status":        customResponseWriter.status,
-				"response_time": end.Sub(start).Milliseconds(),
-				"path":          r.URL.Path,
-				"method":        r.Method,
-				"body":          customResponseWriter.body,
-				"request_id":    reqID,
-				"tgt_host":      r.Host,
-			},
-		).Info("outgoing response")
+		fields := logrus.Fields{
+			"type":          "response",
+			"status":        recorder.status,
+			"response_time": end.Sub(start).Milliseconds(),
+			"path":          r.URL.Path,
+			"method":        r.Method,
+			"request_id":    reqID,
+			"tgt_host":      r.Host,
+		}
+
+		if recorder.body != nil && (rrl.cfg.Bodies != BodyLoggingErrors || recorder.status >= http.StatusBadRequest) {
+			if body, ok := rrl.redaction.body(r.Header.Get("Content-Type"), reqBody); ok {
+				fields["request_body"] = body
+			}
+
+			if body, ok := rrl.redaction.body(recorder.Header().Get("Content-Type"), recorder.body); ok {
+				fields["body"] = body
+			}
+		}
+
+		logger.WithFields(fields).Info("outgoing response")
 	})
 }
+
+// captureBodies decides whether to capture the bodies of a request. In errors only mode they are always captured
+// as the status isn't known until the response is written
+func (rrl *RequestResponseLogger) captureBodies() bool {
+	switch rrl.cfg.Bodies {
+	case BodyLoggingErrors:
+		return true
+	case BodyLoggingSampled:
+		return rrl.sample() < rrl.cfg.SampleRate
+	default:
+		return false
+	}
+}
+
+type userAgent struct {
+	Raw     string `json:"raw"`
+	Product string `json:"product,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type clientInfo struct {
+	IP        string     `json:"ip"`
+	UserAgent *userAgent `json:"user_agent,omitempty"`
+	// ForwardedFor is logged to help debugging but, like all forwarding headers, it can't be trusted
+	ForwardedFor string `json:"forwarded_for,omitempty"`
+}
+
+func newClientInfo(r *http.Request) clientInfo {
+	info := clientInfo{IP: clientIP(r), ForwardedFor: r.Header.Get("X-Forwarded-For")}
+
+	if ua := r.UserAgent(); ua != "" {
+		info.UserAgent = &userAgent{Raw: ua}
+		// the first product token identifies the client e.g. curl/7.68.0 or Mozilla/5.0
+		if fields := strings.Fields(ua); len(fields) > 0 {
+			product := strings.SplitN(fields[0], "/", 2)
+			info.UserAgent.Product = product[0]
+			if len(product) == 2 {
+				info.UserAgent.Version = product[1]
+			}
+		}
+	}
+
+	return info
+}
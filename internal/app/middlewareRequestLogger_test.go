@@ -0,0 +1,112 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logRequest sends a request through a RequestResponseLogger, returning the fields of the request and response log entries
+func logRequest(t *testing.T, cfg RequestLogging, sample float64, r *http.Request, handler http.HandlerFunc) (logrus.Fields, logrus.Fields) {
+	logger, hook := test.NewNullLogger()
+	rrl := NewRequestResponseLogger(logrus.NewEntry(logger), cfg)
+	rrl.sample = func() float64 { return sample }
+
+	rrl.Middleware(handler).ServeHTTP(httptest.NewRecorder(), r)
+
+	entries := hook.AllEntries()
+	require.Len(t, entries, 2)
+
+	return entries[0].Data, entries[1].Data
+}
+
+func jsonHandler(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// read the request body as a handler would
+		r.Body.Read(make([]byte, 1024))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestRequestResponseLogger_redaction(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/auth?code=abc&state=xyz&next=%2Fhome", strings.NewReader(`{"user_name":"test","password":"hunter2"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set("User-Agent", "curl/7.68.0")
+	r.RemoteAddr = "203.0.113.7:41234"
+
+	reqFields, respFields := logRequest(t, DefaultRequestLogging, 0, r,
+		jsonHandler(http.StatusUnauthorized, `{"access_token":"secret","nested":[{"mfa_token":"secret","ok":true}]}`))
+
+	assert.Equal(t, "code=%5BREDACTED%5D&next=%2Fhome&state=%5BREDACTED%5D", reqFields["query_parameters"])
+	headers := reqFields["headers"].(http.Header)
+	assert.Equal(t, []string{redacted}, headers["Authorization"])
+	assert.Equal(t, []string{"application/json"}, headers["Content-Type"])
+	assert.Equal(t, clientInfo{IP: "203.0.113.7", UserAgent: &userAgent{Raw: "curl/7.68.0", Product: "curl", Version: "7.68.0"}}, reqFields["client"])
+
+	assert.Equal(t, http.StatusUnauthorized, respFields["status"])
+	assert.Equal(t, map[string]interface{}{"user_name": "test", "password": redacted}, respFields["request_body"])
+	assert.Equal(t, map[string]interface{}{
+		"access_token": redacted,
+		"nested":       []interface{}{map[string]interface{}{"mfa_token": redacted, "ok": true}},
+	}, respFields["body"])
+}
+
+func TestRequestResponseLogger_bodyLoggingModes(t *testing.T) {
+	sampled := DefaultRequestLogging
+	sampled.Bodies = BodyLoggingSampled
+	sampled.SampleRate = 0.5
+
+	off := DefaultRequestLogging
+	off.Bodies = BodyLoggingOff
+
+	testCases := []struct {
+		name       string
+		cfg        RequestLogging
+		sample     float64
+		status     int
+		expectBody bool
+	}{
+		{name: "errors only, success response", cfg: DefaultRequestLogging, status: http.StatusOK},
+		{name: "errors only, error response", cfg: DefaultRequestLogging, status: http.StatusBadRequest, expectBody: true},
+		{name: "sampled, in sample", cfg: sampled, sample: 0.2, status: http.StatusOK, expectBody: true},
+		{name: "sampled, not in sample", cfg: sampled, sample: 0.7, status: http.StatusInternalServerError},
+		{name: "off", cfg: off, status: http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/users/123", nil)
+			_, respFields := logRequest(t, tc.cfg, tc.sample, r, jsonHandler(tc.status, `{"id":"123"}`))
+
+			assert.Equal(t, tc.status, respFields["status"])
+			if tc.expectBody {
+				assert.Equal(t, map[string]interface{}{"id": "123"}, respFields["body"])
+			} else {
+				assert.NotContains(t, respFields, "body")
+			}
+		})
+	}
+}
+
+func TestRequestResponseLogger_bodiesThatCantBeRedacted(t *testing.T) {
+	cfg := DefaultRequestLogging
+	cfg.MaxBodyBytes = 16
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/users", strings.NewReader(`password=hunter2`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	_, respFields := logRequest(t, cfg, 0, r, jsonHandler(http.StatusBadRequest, `{"detail":"this is over sixteen bytes"}`))
+
+	assert.Equal(t, `["application/x-www-form-urlencoded" body omitted]`, respFields["request_body"])
+	assert.Equal(t, "[body over 16 bytes omitted]", respFields["body"])
+}
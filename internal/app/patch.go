@@ -0,0 +1,53 @@
+package app
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+)
+
+// patchMediaTypes maps the Content-Types PATCH endpoints accept to the format of the patch.
+// PATCH endpoints took JSON Patch whatever the Content-Type before they dispatched on it,
+// so plain JSON and a missing Content-Type are still taken to be JSON Patch
+var patchMediaTypes = map[string]domain.PatchFormat{
+	"":                        domain.JSONPatch,
+	"application/json":        domain.JSONPatch,
+	string(domain.JSONPatch):  domain.JSONPatch,
+	string(domain.MergePatch): domain.MergePatch,
+}
+
+// patchFromRequest reads the patch document in the body of a PATCH request, in the format named by its Content-Type.
+// Unsupported Content-Types are met with a 415 whose Accept-Patch header lists the supported ones, as RFC 5789 suggests
+func patchFromRequest(w http.ResponseWriter, r *http.Request) (domain.Patch, *appErr) {
+	mediaType := r.Header.Get("Content-Type")
+	if mediaType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(mediaType); err != nil {
+			return domain.Patch{}, newAppErr("Content-Type header is malformed", http.StatusBadRequest)
+		}
+	}
+
+	format, ok := patchMediaTypes[mediaType]
+	if !ok {
+		accepted := make([]string, len(domain.PatchFormats))
+		for i, f := range domain.PatchFormats {
+			accepted[i] = string(f)
+		}
+
+		w.Header().Set("Accept-Patch", strings.Join(accepted, ", "))
+
+		return domain.Patch{}, newAppErr("Content-Type must be one of "+strings.Join(accepted, ", "), http.StatusUnsupportedMediaType)
+	}
+
+	defer r.Body.Close()
+
+	doc, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return domain.Patch{}, newAppErr("request body unreadable", http.StatusBadRequest)
+	}
+
+	return domain.Patch{Format: format, Doc: doc}, nil
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// redaction removes sensitive values from what is logged
+type redaction struct {
+	sensitiveFields  map[string]bool
+	sensitiveHeaders map[string]bool
+}
+
+func newRedaction(fields, headers []string) *redaction {
+	rd := &redaction{sensitiveFields: map[string]bool{}, sensitiveHeaders: map[string]bool{}}
+	for _, f := range fields {
+		rd.sensitiveFields[strings.ToLower(f)] = true
+	}
+
+	for _, h := range headers {
+		rd.sensitiveHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+
+	return rd
+}
+
+func (rd *redaction) headers(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, v := range h {
+		if rd.sensitiveHeaders[http.CanonicalHeaderKey(k)] {
+			out[k] = []string{redacted}
+		} else {
+			out[k] = v
+		}
+	}
+
+	return out
+}
+
+func (rd *redaction) query(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redacted
+	}
+
+	for k := range values {
+		if rd.sensitiveFields[strings.ToLower(k)] {
+			values[k] = []string{redacted}
+		}
+	}
+
+	return values.Encode()
+}
+
+// body returns a redacted copy of a captured body for logging, or false if there is nothing to log.
+// Only JSON bodies are logged in full, anything else might hold secrets we can't find
+func (rd *redaction) body(contentType string, b *cappedBuffer) (interface{}, bool) {
+	if b == nil || b.Len() == 0 {
+		return nil, false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return fmt.Sprintf("[%q body omitted]", mediaType), true
+	} else if b.truncated {
+		return fmt.Sprintf("[body over %d bytes omitted]", b.max), true
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b.Bytes(), &v); err != nil {
+		return "[invalid JSON body omitted]", true
+	}
+
+	return rd.json(v), true
+}
+
+// json redacts sensitive fields at any depth
+func (rd *redaction) json(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, fieldVal := range val {
+			if rd.sensitiveFields[strings.ToLower(k)] {
+				val[k] = redacted
+			} else {
+				val[k] = rd.json(fieldVal)
+			}
+		}
+	case []interface{}:
+		for i := range val {
+			val[i] = rd.json(val[i])
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentTypeJSON = "application/json"
+	// preferRepresentation asks for the resource changed by a request to be returned, see RFC 7240
+	preferRepresentation = "return=representation"
+)
+
+// writeUpdated responds to a PATCH or PUT that updated resource. The resource is returned with a 200 when the client
+// prefers it and accepts JSON, otherwise there is no body and a 204, as when return=minimal is preferred
+func (app *App) writeUpdated(w http.ResponseWriter, r *http.Request, handler string, resource interface{}) {
+	w.Header().Add("Vary", "Prefer")
+
+	if !prefers(r, preferRepresentation) || !acceptsJSON(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	respBytes, err := json.Marshal(resource)
+	if err != nil {
+		app.logger.WithContext(r.Context()).WithField(appHandler, handler).WithError(err).Error("failed to marshal json response")
+		apperr := newAppErr("failed to marshal json response", http.StatusInternalServerError)
+		httpErrorFromAppErr(w, r, apperr)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Preference-Applied", preferRepresentation)
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBytes)
+}
+
+// prefers reports whether the request's Prefer headers include the preference, ignoring any parameters
+func prefers(r *http.Request, preference string) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, p := range strings.Split(header, ",") {
+			p = strings.TrimSpace(strings.SplitN(p, ";", 2)[0])
+			name, value := p, ""
+			if i := strings.Index(p, "="); i >= 0 {
+				name, value = strings.TrimSpace(p[:i]), strings.Trim(strings.TrimSpace(p[i+1:]), `"`)
+			}
+
+			if strings.EqualFold(name+"="+value, preference) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// jsonMediaRanges are the Accept media ranges that match JSON, by how specific they are
+var jsonMediaRanges = map[string]int{"*/*": 0, "application/*": 1, contentTypeJSON: 2}
+
+// acceptsJSON reports whether a response body can be JSON, which it can when there is no Accept header.
+// The most specific media range matching JSON decides, so application/json;q=0 refuses it even alongside */*
+func acceptsJSON(r *http.Request) bool {
+	header := strings.Join(r.Header.Values("Accept"), ",")
+	if header == "" {
+		return true
+	}
+
+	specificity, quality := -1, 0.0
+	for _, mediaRange := range strings.Split(header, ",") {
+		params := strings.Split(mediaRange, ";")
+		s, ok := jsonMediaRanges[strings.ToLower(strings.TrimSpace(params[0]))]
+		if !ok || s <= specificity {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "q") {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		specificity, quality = s, q
+	}
+
+	return quality > 0
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteUpdated(t *testing.T) {
+	testCases := []struct {
+		name           string
+		headers        map[string]string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "no preference", headers: map[string]string{}, expectedStatus: http.StatusNoContent},
+		{name: "minimal preferred", headers: map[string]string{"Prefer": "return=minimal"}, expectedStatus: http.StatusNoContent},
+		{
+			name:           "representation preferred",
+			headers:        map[string]string{"Prefer": "return=representation"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id":"abc"}`,
+		},
+		{
+			name:           "representation preferred among others",
+			headers:        map[string]string{"Prefer": `respond-async, RETURN="representation"`},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id":"abc"}`,
+		},
+		{
+			name:           "representation preferred and json accepted",
+			headers:        map[string]string{"Prefer": "return=representation", "Accept": "text/html, application/json;q=0.5"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id":"abc"}`,
+		},
+		{
+			name:           "representation preferred but json not accepted",
+			headers:        map[string]string{"Prefer": "return=representation", "Accept": "text/html"},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "representation preferred but json refused",
+			headers:        map[string]string{"Prefer": "return=representation", "Accept": "*/*, application/json;q=0"},
+			expectedStatus: http.StatusNoContent,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			app := New(nil, nullLogger(), nil, "", "", nil, nil)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPatch, "/", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			app.writeUpdated(w, r, "test", struct {
+				ID string `json:"id"`
+			}{ID: "abc"})
+
+			assert.Equal(t, tc.expectedStatus, w.Code)
+			assert.Equal(t, "Prefer", w.Header().Get("Vary"))
+			if tc.expectedStatus == http.StatusOK {
+				assert.JSONEq(t, tc.expectedBody, w.Body.String())
+				assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+				assert.Equal(t, "return=representation", w.Header().Get("Preference-Applied"))
+			} else {
+				assert.Equal(t, "", w.Body.String())
+				assert.Equal(t, "", w.Header().Get("Preference-Applied"))
+			}
+		})
+	}
+}
@@ -3,36 +3,86 @@ package app
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/config"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/nanoID"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
 const (
-	urlVarUserID = "userID"
+	urlVarUserID   = "userID"
+	urlVarProvider = "provider"
+	urlVarAPIKeyID = "apiKeyID"
+
+	routeCreateUser = "createUser"
+	routeEnrollTOTP = "enrollTOTP"
 )
 
+// expensiveRoutes are counted against the expensive rate limit budget
+var expensiveRoutes = map[string]bool{
+	routeCreateUser: true,
+	routeEnrollTOTP: true,
+}
+
 func (app *App) routes() {
 	// handles routing app functionality
 	appRouter := app.router.PathPrefix("").Subrouter()
 
-	if app.env != "production" {
+	app.router.Use(NewRequestIdentifier(app.logger, nanoID.NewGenerator(requestIDAlphabet, requestIDLength)).Middleware)
+
+	// tracing comes next so that logs from all the other middleware can be correlated with the request's trace
+	if app.tracingServiceName != "" {
+		app.router.Use(otelmux.Middleware(app.tracingServiceName))
+	}
+
+	// the metrics themselves are served on a separate listener, see WithMetrics
+	if app.metrics != nil {
+		app.router.Use(app.metricsMiddleware)
+	}
+
+	// every route is logged so that credentials are redacted wherever they are sent, including /auth and the OIDC callback
+	app.router.Use(NewRequestResponseLogger(app.logger, app.requestLogging).Middleware)
+
+	if app.env != config.EnvironmentProd {
 		fs := http.FileServer(http.Dir("./api/OpenAPI/"))
 		appRouter.PathPrefix("/docs").Handler(http.StripPrefix("/docs", fs))
 	}
 
 	appRouter.HandleFunc("/auth", app.handleAuthenticate()).Methods(http.MethodPost)
+	appRouter.HandleFunc("/auth/mfa", app.handleVerifyMFA()).Methods(http.MethodPost)
+	appRouter.HandleFunc(fmt.Sprintf("/auth/oidc/{%s}/start", urlVarProvider), app.handleOIDCStart()).Methods(http.MethodGet)
+	appRouter.HandleFunc(fmt.Sprintf("/auth/oidc/{%s}/callback", urlVarProvider), app.handleOIDCCallback()).Methods(http.MethodGet)
 	appRouter.HandleFunc("/ping", app.handlePing()).Methods(http.MethodGet)
+	appRouter.HandleFunc("/healthz", app.handleHealthz()).Methods(http.MethodGet)
+	appRouter.HandleFunc("/readyz", app.handleReadyz()).Methods(http.MethodGet)
+	appRouter.HandleFunc("/.well-known/jwks.json", app.handleJWKS()).Methods(http.MethodGet)
 
 	// handles routing domain functionality for api v1
 	apiV1Router := app.router.PathPrefix("/api/v1").Subrouter()
 	// Users
-	apiV1Router.HandleFunc("/users", app.handleCreateUser()).Methods(http.MethodPost)
+	apiV1Router.HandleFunc("/users", app.handleCreateUser()).Methods(http.MethodPost).Name(routeCreateUser)
 	// apiV1Router.HandleFunc("/users", app.handleGetUsers()).Methods("GET")
-	apiV1Router.HandleFunc(fmt.Sprintf("/users/{%s}", urlVarUserID), app.handleGetUser()).Methods(http.MethodGet)
-	apiV1Router.HandleFunc(fmt.Sprintf("/users/{%s}", urlVarUserID), app.handlePatchUser()).Methods(http.MethodPatch)
+	apiV1Router.Handle(fmt.Sprintf("/users/{%s}", urlVarUserID), RequireScope(domain.ScopeUsersRead)(app.handleGetUser())).Methods(http.MethodGet)
+	apiV1Router.Handle(fmt.Sprintf("/users/{%s}", urlVarUserID), RequireScope(domain.ScopeUsersWrite)(app.handlePatchUser())).Methods(http.MethodPatch)
 	// apiV1Router.HandleFunc(fmt.Sprintf("/users/{%s}", app.handleDeleteUser()).Methods("DELETE")
 	// apiV1Router.HandleFunc(fmt.Sprintf("/users/{%s}/password", app.handleUpdateUserPassword()).Methods("PUT")
 
-	apiV1Router.Use(NewRequestResponseLogger(app.logger).Middleware)
-	if app.env == "production" || app.env == "staging" {
+	// a user's credentials, which require an access token to manage
+	credentialsRouter := apiV1Router.PathPrefix(fmt.Sprintf("/users/{%s}", urlVarUserID)).Subrouter()
+	credentialsRouter.HandleFunc("/mfa/totp", app.handleEnrollTOTP()).Methods(http.MethodPost).Name(routeEnrollTOTP)
+	credentialsRouter.HandleFunc("/mfa/totp/confirm", app.handleConfirmTOTP()).Methods(http.MethodPost)
+	credentialsRouter.HandleFunc("/api-keys", app.handleCreateAPIKey()).Methods(http.MethodPost)
+	credentialsRouter.HandleFunc("/api-keys", app.handleListAPIKeys()).Methods(http.MethodGet)
+	credentialsRouter.HandleFunc(fmt.Sprintf("/api-keys/{%s}", urlVarAPIKeyID), app.handleRevokeAPIKey()).Methods(http.MethodDelete)
+	credentialsRouter.Use(RequireAuthentication)
+
+	apiV1Router.Use(NewTokenValidator(app.logger, app.tokenAuth, app.service, app.rateLimiter).Middleware)
+	if app.rateLimiter != nil {
+		apiV1Router.Use(app.rateLimiter.Middleware)
+	}
+
+	if app.env == config.EnvironmentProd || app.env == config.EnvironmentStaging {
 		//do summat
 	}
 }
@@ -7,9 +7,19 @@ import (
 )
 
 type Service interface {
-	// PingDB(ctx context.Context) error
 	CreateUser(ctx context.Context, UserName, Email, Password string) (*domain.User, *domain.Error)
 	GetUser(ctx context.Context, userID string) (*domain.User, *domain.Error)
-	PatchUser(ctx context.Context, userID string, patch []byte) *domain.Error
-	ValidateUserCredentials(ctx context.Context, userName, email, password string) (*domain.User, *domain.Error)
+	PatchUser(ctx context.Context, userID string, versions []int64, patch domain.Patch) (*domain.User, *domain.Error)
+	ValidateUserCredentials(ctx context.Context, userName, email, password, clientIP string) (*domain.User, *domain.Error)
+	LoginWithIdentity(ctx context.Context, identity domain.ExternalIdentity) (*domain.User, *domain.Error)
+
+	EnrollTOTP(ctx context.Context, userID string) (*domain.TOTPEnrollment, *domain.Error)
+	ConfirmTOTP(ctx context.Context, userID, code string) ([]string, *domain.Error)
+	IsMFAEnabled(ctx context.Context, userID string) (bool, *domain.Error)
+	VerifySecondFactor(ctx context.Context, userID, code, clientIP string) *domain.Error
+
+	CreateAPIKey(ctx context.Context, userID, name string, scopes []string) (*domain.APIKey, string, *domain.Error)
+	ListAPIKeys(ctx context.Context, userID string) ([]domain.APIKey, *domain.Error)
+	RevokeAPIKey(ctx context.Context, userID, keyID string) *domain.Error
+	AuthenticateAPIKey(ctx context.Context, plaintext string) (*domain.APIKey, *domain.Error)
 }
@@ -2,9 +2,11 @@ package app
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/auth"
 	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/mock"
@@ -120,17 +122,37 @@ func (ms *mockService) ValidatePasswordByUserName(ctx context.Context, userName,
 	return args.Get(0).(bool), err
 }
 
-func (ms *mockService) PatchUser(ctx context.Context, userID string, patchJSON []byte) *domain.Error {
-	args := ms.Called(ctx, userID, patchJSON)
+func (ms *mockService) PatchUser(ctx context.Context, userID string, versions []int64, patch domain.Patch) (*domain.User, *domain.Error) {
+	args := ms.Called(ctx, userID, versions, patch)
+	if args.Get(1) != nil {
+		return nil, args.Get(1).(*domain.Error)
+	}
+
+	return args.Get(0).(*domain.User), nil
+}
+
+func (ms *mockService) ValidateUserCredentials(ctx context.Context, userName, email, password, clientIP string) (*domain.User, *domain.Error) {
+	args := ms.Called(ctx, userName, email, password, clientIP)
+
+	var user *domain.User
 	if args.Get(0) == nil {
-		return nil
+		user = nil
+	} else {
+		user = args.Get(0).(*domain.User)
 	}
 
-	return args.Get(0).(*domain.Error)
+	var err *domain.Error
+	if args.Get(1) == nil {
+		err = nil
+	} else {
+		err = args.Get(1).(*domain.Error)
+	}
+
+	return user, err
 }
 
-func (ms *mockService) ValidateUserCredentials(ctx context.Context, userName, email, password string) (*domain.User, *domain.Error) {
-	args := ms.Called(ctx, userName, email, password)
+func (ms *mockService) LoginWithIdentity(ctx context.Context, identity domain.ExternalIdentity) (*domain.User, *domain.Error) {
+	args := ms.Called(ctx, identity)
 
 	var user *domain.User
 	if args.Get(0) == nil {
@@ -149,6 +171,113 @@ func (ms *mockService) ValidateUserCredentials(ctx context.Context, userName, em
 	return user, err
 }
 
+func (ms *mockService) EnrollTOTP(ctx context.Context, userID string) (*domain.TOTPEnrollment, *domain.Error) {
+	args := ms.Called(ctx, userID)
+
+	var enrollment *domain.TOTPEnrollment
+	if args.Get(0) != nil {
+		enrollment = args.Get(0).(*domain.TOTPEnrollment)
+	}
+
+	var err *domain.Error
+	if args.Get(1) != nil {
+		err = args.Get(1).(*domain.Error)
+	}
+
+	return enrollment, err
+}
+
+func (ms *mockService) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, *domain.Error) {
+	args := ms.Called(ctx, userID, code)
+
+	var codes []string
+	if args.Get(0) != nil {
+		codes = args.Get(0).([]string)
+	}
+
+	var err *domain.Error
+	if args.Get(1) != nil {
+		err = args.Get(1).(*domain.Error)
+	}
+
+	return codes, err
+}
+
+func (ms *mockService) IsMFAEnabled(ctx context.Context, userID string) (bool, *domain.Error) {
+	args := ms.Called(ctx, userID)
+	if args.Get(1) == nil {
+		return args.Bool(0), nil
+	}
+
+	return args.Bool(0), args.Get(1).(*domain.Error)
+}
+
+func (ms *mockService) VerifySecondFactor(ctx context.Context, userID, code, clientIP string) *domain.Error {
+	args := ms.Called(ctx, userID, code, clientIP)
+	if args.Get(0) == nil {
+		return nil
+	}
+
+	return args.Get(0).(*domain.Error)
+}
+
+func (ms *mockService) CreateAPIKey(ctx context.Context, userID, name string, scopes []string) (*domain.APIKey, string, *domain.Error) {
+	args := ms.Called(ctx, userID, name, scopes)
+
+	var key *domain.APIKey
+	if args.Get(0) != nil {
+		key = args.Get(0).(*domain.APIKey)
+	}
+
+	var err *domain.Error
+	if args.Get(2) != nil {
+		err = args.Get(2).(*domain.Error)
+	}
+
+	return key, args.String(1), err
+}
+
+func (ms *mockService) ListAPIKeys(ctx context.Context, userID string) ([]domain.APIKey, *domain.Error) {
+	args := ms.Called(ctx, userID)
+
+	var keys []domain.APIKey
+	if args.Get(0) != nil {
+		keys = args.Get(0).([]domain.APIKey)
+	}
+
+	var err *domain.Error
+	if args.Get(1) != nil {
+		err = args.Get(1).(*domain.Error)
+	}
+
+	return keys, err
+}
+
+func (ms *mockService) RevokeAPIKey(ctx context.Context, userID, keyID string) *domain.Error {
+	args := ms.Called(ctx, userID, keyID)
+	if args.Get(0) == nil {
+		return nil
+	}
+
+	return args.Get(0).(*domain.Error)
+}
+
+func (ms *mockService) AuthenticateAPIKey(ctx context.Context, plaintext string) (*domain.APIKey, *domain.Error) {
+	args := ms.Called(ctx, plaintext)
+
+	var key *domain.APIKey
+	if args.Get(0) != nil {
+		key = args.Get(0).(*domain.APIKey)
+	}
+
+	var err *domain.Error
+	if args.Get(1) != nil {
+		err = args.Get(1).(*domain.Error)
+	}
+
+	return key, err
+}
+
 type mockAuth struct {
 	mock.Mock
 }
@@ -159,6 +288,57 @@ func (ma *mockAuth) GenerateTokenString(userID string) (string, error) {
 	return args.Get(0).(string), args.Error(1)
 }
 
+func (ma *mockAuth) GetClaims(tokenString string) (*auth.JWTClaims, error) {
+	args := ma.Called(tokenString)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*auth.JWTClaims), args.Error(1)
+}
+
+func (ma *mockAuth) GenerateMFAChallengeTokenString(userID string) (string, error) {
+	args := ma.Called(userID)
+
+	return args.Get(0).(string), args.Error(1)
+}
+
+func (ma *mockAuth) GetMFAChallengeClaims(tokenString string) (*auth.JWTClaims, error) {
+	args := ma.Called(tokenString)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*auth.JWTClaims), args.Error(1)
+}
+
+func (ma *mockAuth) JWKS() auth.JWKS {
+	args := ma.Called()
+
+	return args.Get(0).(auth.JWKS)
+}
+
+// problemJSON is the problem+json body expected for an error
+func problemJSON(status int, code, detail string) string {
+	return problemJSONWithInstance(status, code, detail, "")
+}
+
+// testRequestID is sent as the X-Request-ID of requests that go through the router, which sets it as the problem instance
+const testRequestID = "test-request-id"
+
+func problemJSONWithInstance(status int, code, detail, instance string) string {
+	b, _ := json.Marshal(problem{
+		Type:     problemTypeBase + code,
+		Title:    errCodeTitles[code],
+		Status:   status,
+		Detail:   detail,
+		Instance: instance,
+		Code:     code,
+	})
+
+	return string(b)
+}
+
 // Creates a logger instance that discards all output
 func nullLogger() *logrus.Logger {
 	logger := logrus.New()
@@ -0,0 +1,36 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/sirupsen/logrus"
+)
+
+const componentAudit = "Audit"
+
+// LogAuditor writes audit events as structured log lines tagged audit=true so they can be routed to long term storage
+type LogAuditor struct {
+	logger *logrus.Entry
+}
+
+func NewLogAuditor(logger *logrus.Logger) *LogAuditor {
+	return &LogAuditor{logger: logger.WithFields(logrus.Fields{"component": componentAudit, "audit": true})}
+}
+
+func (la *LogAuditor) Audit(ctx context.Context, event domain.AuditEvent) {
+	fields := logrus.Fields{
+		"event":     string(event.Type),
+		"at":        event.At,
+		"subject":   event.Subject,
+		"client_ip": event.ClientIP,
+	}
+	if event.UserID != "" {
+		fields["user_id"] = event.UserID
+	}
+	for k, v := range event.Detail {
+		fields[k] = v
+	}
+
+	la.logger.WithFields(fields).Info(string(event.Type))
+}
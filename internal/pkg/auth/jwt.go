@@ -7,8 +7,24 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
+const (
+	headerKeyID = "kid"
+	headerType  = "typ"
+
+	// AccessTokenAudience is the audience of access tokens. Their typ header is AccessTokenType, as RFC 9068 has it.
+	// The keys published in the JWKS sign other tokens too, so anything verifying access tokens with them must check both
+	AccessTokenAudience = "graffiti-berlin-api"
+	AccessTokenType     = "at+jwt"
+
+	// audienceMFAChallenge marks tokens proving only that the password step of a two-factor login succeeded.
+	// They must never be accepted as access tokens
+	audienceMFAChallenge     = "mfa-challenge"
+	typeMFAChallenge         = "mfa-challenge+jwt"
+	mfaChallengeExpiresAfter = 5 * time.Minute
+)
+
 type JWTTool struct {
-	secretKey    []byte
+	keys         *KeySet
 	expiresAfter time.Duration
 	issuer       string
 	idTool       IDGenerator
@@ -22,50 +38,111 @@ type JWTClaims struct {
 	jwt.RegisteredClaims
 }
 
-func NewJWTTool(secretKey string, expiresAfter time.Duration, issuer string) *JWTTool {
+func NewJWTTool(keys *KeySet, expiresAfter time.Duration, issuer string, idTool IDGenerator) *JWTTool {
 	return &JWTTool{
-		secretKey:    []byte(secretKey),
+		keys:         keys,
 		expiresAfter: expiresAfter,
 		issuer:       issuer,
+		idTool:       idTool,
 	}
 }
 
+// GenerateTokenString creates a token for the given subject signed with the currently active key
 func (jt *JWTTool) GenerateTokenString(subject string) (string, error) {
+	return jt.generate(subject, jt.expiresAfter, AccessTokenType, AccessTokenAudience)
+}
+
+// GenerateMFAChallengeTokenString creates a short lived token to be exchanged, along with a second factor, for an access token
+func (jt *JWTTool) GenerateMFAChallengeTokenString(subject string) (string, error) {
+	return jt.generate(subject, mfaChallengeExpiresAfter, typeMFAChallenge, audienceMFAChallenge)
+}
+
+func (jt *JWTTool) generate(subject string, expiresAfter time.Duration, tokenType, audience string) (string, error) {
 	tokenID, err := jt.idTool.New()
 	if err != nil {
 		return "", fmt.Errorf("failed to generate token ID: %v", err)
 	}
 
-	expirationTime := time.Now().Add(jt.expiresAfter)
+	expirationTime := time.Now().Add(expiresAfter)
 	claims := &JWTClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        tokenID,
 			Issuer:    jt.issuer,
 			Subject:   subject,
+			Audience:  jwt.ClaimStrings{audience},
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	key := jt.keys.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header[headerKeyID] = key.ID
+	token.Header[headerType] = tokenType
 
-	return token.SignedString(jt.secretKey)
+	return token.SignedString(key.private)
 }
 
+// GetClaims verifies an access token against the key named in its kid header, which need not be the active key
 func (jt *JWTTool) GetClaims(tokenStr string) (*JWTClaims, error) {
-	token, err := jwt.ParseWithClaims(
-		tokenStr,
-		&JWTClaims{},
-		func(token *jwt.Token) (interface{}, error) { return jt.secretKey, nil },
-	)
+	claims, err := jt.parse(tokenStr, AccessTokenType, AccessTokenAudience)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// GetMFAChallengeClaims verifies a token issued by GenerateMFAChallengeTokenString
+func (jt *JWTTool) GetMFAChallengeClaims(tokenStr string) (*JWTClaims, error) {
+	claims, err := jt.parse(tokenStr, typeMFAChallenge, audienceMFAChallenge)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// parse verifies a token, which must be of the given type, for the given audience and issued by this tool.
+// Checking the issuer means tokens from another issuer sharing a signing key are not accepted
+func (jt *JWTTool) parse(tokenStr, tokenType, audience string) (*JWTClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &JWTClaims{}, jt.keyFunc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %v", err)
 	}
 
-	claims, ok := token.Claims.(JWTClaims)
+	claims, ok := token.Claims.(*JWTClaims)
 	if !ok {
 		return nil, fmt.Errorf("failed to extract claims from token")
 	}
 
-	return &claims, nil
+	if typ, _ := token.Header[headerType].(string); typ != tokenType || !claims.VerifyAudience(audience, true) || !claims.VerifyIssuer(jt.issuer, true) {
+		return nil, fmt.Errorf("token is not of type %s for audience %s from issuer %s", tokenType, audience, jt.issuer)
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public keys that tokens issued by this tool can be verified with
+func (jt *JWTTool) JWKS() JWKS {
+	return jt.keys.JWKS()
+}
+
+func (jt *JWTTool) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header[headerKeyID].(string)
+	if !ok || kid == "" {
+		return nil, fmt.Errorf("token has no key ID")
+	}
+
+	key, ok := jt.keys.Get(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %s", kid)
+	}
+
+	// guard against algorithm substitution e.g. an RSA public key being used as an HMAC secret
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", token.Method.Alg(), kid)
+	}
+
+	return key.public, nil
 }
@@ -1,34 +1,62 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/uuidv4"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func newTestKeySet(t *testing.T) *KeySet {
+	edKey, err := GenerateEd25519Key("2022-07-01")
+	require.NoError(t, err)
+
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaKey, err := NewSigningKey("2022-01-01", rsaPrivate)
+	require.NoError(t, err)
+
+	ks, err := NewKeySet("", edKey, rsaKey)
+	require.NoError(t, err)
+
+	return ks
+}
+
 func TestJWTToolGenerateSignedTokenString_successPath(t *testing.T) {
-	jt := NewJWTTool("supersecretkey", time.Hour, "graffiti-berlin-svc")
+	ks := newTestKeySet(t)
+	jt := NewJWTTool(ks, time.Hour, "graffiti-berlin-svc", uuidv4.NewGenerator())
 
 	tokenSigned, err := jt.GenerateTokenString("user_id")
 	assert.NoError(t, err)
 	assert.Regexp(t, `^(?:[\w-]*\.){2}[\w-]*$`, tokenSigned)
 
 	tok, err := jwt.ParseWithClaims(tokenSigned, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jt.secretKey), nil
+		return ks.Active().public, nil
 	})
 	assert.NoError(t, err)
+	assert.Equal(t, "EdDSA", tok.Method.Alg())
+	assert.Equal(t, "2022-07-01", tok.Header["kid"])
+
+	assert.Equal(t, "at+jwt", tok.Header["typ"])
 
 	claims, ok := tok.Claims.(*JWTClaims)
 	assert.True(t, ok)
+	assert.Equal(t, jwt.ClaimStrings{"graffiti-berlin-api"}, claims.Audience)
 	assert.Equal(t, "graffiti-berlin-svc", claims.Issuer)
 	assert.Equal(t, "user_id", claims.Subject)
+	assert.NotEmpty(t, claims.ID)
 }
 
 func TestJWTToolGetClaims_successPath(t *testing.T) {
-	jt := NewJWTTool("supersecretkey", time.Hour, "graffiti-berlin-svc")
+	ks := newTestKeySet(t)
+	jt := NewJWTTool(ks, time.Hour, "graffiti-berlin-svc", uuidv4.NewGenerator())
 
 	// JWT NumericDate doesn't deal in nanoseconds so we truncate to nearest second
 	issuedAt := time.Now().Truncate(time.Second)
@@ -38,13 +66,17 @@ func TestJWTToolGetClaims_successPath(t *testing.T) {
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    "graffiti-berlin-svc",
 			Subject:   userID,
+			Audience:  jwt.ClaimStrings{AccessTokenAudience},
 			IssuedAt:  jwt.NewNumericDate(issuedAt),
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedStr, err := token.SignedString(jt.secretKey)
+	key := ks.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	token.Header["typ"] = AccessTokenType
+	signedStr, err := token.SignedString(key.private)
 	assert.NoError(t, err)
 
 	decodedClaims, err := jt.GetClaims(signedStr)
@@ -56,7 +88,8 @@ func TestJWTToolGetClaims_successPath(t *testing.T) {
 }
 
 func TestJWTToolGetClaims_expiredToken_successPath(t *testing.T) {
-	jt := NewJWTTool("supersecretkey", time.Hour, "graffiti-berlin-svc")
+	ks := newTestKeySet(t)
+	jt := NewJWTTool(ks, time.Hour, "graffiti-berlin-svc", uuidv4.NewGenerator())
 
 	// JWT NumericDate doesn't deal in nanoseconds so we truncate to nearest second
 	issuedAt := time.Time{}
@@ -71,8 +104,10 @@ func TestJWTToolGetClaims_expiredToken_successPath(t *testing.T) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedStr, err := token.SignedString(jt.secretKey)
+	key := ks.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	signedStr, err := token.SignedString(key.private)
 	assert.NoError(t, err)
 
 	decodedClaims, err := jt.GetClaims(signedStr)
@@ -81,3 +116,148 @@ func TestJWTToolGetClaims_expiredToken_successPath(t *testing.T) {
 
 	assert.True(t, strings.HasPrefix(err.Error(), "failed to parse token: token is expired by"))
 }
+
+func TestJWTToolGetClaims_tokenSignedWithRotatedOutKey_successPath(t *testing.T) {
+	ks := newTestKeySet(t)
+	jt := NewJWTTool(ks, time.Hour, "graffiti-berlin-svc", uuidv4.NewGenerator())
+
+	assert.NoError(t, ks.Rotate("2022-01-01"))
+	rsaSigned, err := jt.GenerateTokenString("user_id")
+	assert.NoError(t, err)
+
+	assert.NoError(t, ks.Rotate("2022-07-01"))
+	edSigned, err := jt.GenerateTokenString("user_id")
+	assert.NoError(t, err)
+
+	for _, signed := range []string{rsaSigned, edSigned} {
+		claims, err := jt.GetClaims(signed)
+		assert.NoError(t, err)
+		assert.Equal(t, "user_id", claims.Subject)
+	}
+
+	// once the old key is retired its tokens are no longer accepted
+	assert.NoError(t, ks.Remove("2022-01-01"))
+	_, err = jt.GetClaims(rsaSigned)
+	assert.EqualError(t, err, "failed to parse token: unknown key ID 2022-01-01")
+}
+
+func TestJWTToolGetClaims_rejectedTokens_failurePath(t *testing.T) {
+	ks := newTestKeySet(t)
+	jt := NewJWTTool(ks, time.Hour, "graffiti-berlin-svc", uuidv4.NewGenerator())
+
+	claims := &JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user_id",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+
+	// a shared secret token without kid, as issued before asymmetric keys were introduced
+	noKid, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secretKey"))
+	assert.NoError(t, err)
+	_, err = jt.GetClaims(noKid)
+	assert.EqualError(t, err, "failed to parse token: token has no key ID")
+
+	// HS256 token claiming to be signed by the RSA key
+	hsToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hsToken.Header["kid"] = "2022-01-01"
+	algSwap, err := hsToken.SignedString([]byte("secretKey"))
+	assert.NoError(t, err)
+	_, err = jt.GetClaims(algSwap)
+	assert.EqualError(t, err, "failed to parse token: unexpected signing method HS256 for key 2022-01-01")
+}
+
+func TestJWTToolMFAChallengeToken_successPath(t *testing.T) {
+	jt := NewJWTTool(newTestKeySet(t), time.Hour, "graffiti-berlin-svc", uuidv4.NewGenerator())
+
+	challenge, err := jt.GenerateMFAChallengeTokenString("user_id")
+	assert.NoError(t, err)
+
+	claims, err := jt.GetMFAChallengeClaims(challenge)
+	assert.NoError(t, err)
+	assert.Equal(t, "user_id", claims.Subject)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), claims.ExpiresAt.Time, 5*time.Second)
+
+	// a challenge token must not work as an access token, nor vice versa
+	_, err = jt.GetClaims(challenge)
+	assert.EqualError(t, err, "token is not of type at+jwt for audience graffiti-berlin-api from issuer graffiti-berlin-svc")
+
+	access, err := jt.GenerateTokenString("user_id")
+	assert.NoError(t, err)
+	_, err = jt.GetMFAChallengeClaims(access)
+	assert.EqualError(t, err, "token is not of type mfa-challenge+jwt for audience mfa-challenge from issuer graffiti-berlin-svc")
+}
+
+func TestJWTToolGetClaims_tokensWithoutAccessMarkers_failurePath(t *testing.T) {
+	ks := newTestKeySet(t)
+	jt := NewJWTTool(ks, time.Hour, "graffiti-berlin-svc", uuidv4.NewGenerator())
+
+	sign := func(typ string, audience jwt.ClaimStrings, issuer string) string {
+		token := jwt.NewWithClaims(ks.Active().Method, &JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    issuer,
+				Subject:   "user_id",
+				Audience:  audience,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		})
+		token.Header["kid"] = ks.Active().ID
+		if typ != "" {
+			token.Header["typ"] = typ
+		}
+
+		signed, err := token.SignedString(ks.Active().private)
+		require.NoError(t, err)
+
+		return signed
+	}
+
+	for name, signed := range map[string]string{
+		"no audience or type, as issued before they were added": sign("", nil, "graffiti-berlin-svc"),
+		"access type but no audience":                           sign(AccessTokenType, nil, "graffiti-berlin-svc"),
+		"access audience but plain type":                        sign("JWT", jwt.ClaimStrings{AccessTokenAudience}, "graffiti-berlin-svc"),
+		// e.g. another service signing with a shared key
+		"another issuer": sign(AccessTokenType, jwt.ClaimStrings{AccessTokenAudience}, "another-svc"),
+		"no issuer":      sign(AccessTokenType, jwt.ClaimStrings{AccessTokenAudience}, ""),
+	} {
+		_, err := jt.GetClaims(signed)
+		assert.EqualError(t, err, "token is not of type at+jwt for audience graffiti-berlin-api from issuer graffiti-berlin-svc", name)
+	}
+}
+
+// TestJWTToolMFAChallengeToken_rejectedByJWKSVerifier_failurePath verifies a challenge token as another service would,
+// knowing only the published JWKS and the rules for access tokens
+func TestJWTToolMFAChallengeToken_rejectedByJWKSVerifier_failurePath(t *testing.T) {
+	ks := newTestKeySet(t)
+	jt := NewJWTTool(ks, time.Hour, "graffiti-berlin-svc", uuidv4.NewGenerator())
+
+	verifyAccessToken := func(signed string) error {
+		token, err := jwt.ParseWithClaims(signed, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
+			// the key published with the token's kid
+			for _, jwk := range jt.JWKS().Keys {
+				if key, ok := ks.Get(jwk.KeyID); ok && jwk.KeyID == token.Header["kid"] {
+					return key.public, nil
+				}
+			}
+
+			return nil, fmt.Errorf("unknown key")
+		})
+		if err != nil {
+			return err
+		}
+
+		if token.Header["typ"] != AccessTokenType || !token.Claims.(*JWTClaims).VerifyAudience(AccessTokenAudience, true) {
+			return fmt.Errorf("not an access token")
+		}
+
+		return nil
+	}
+
+	access, err := jt.GenerateTokenString("user_id")
+	require.NoError(t, err)
+	assert.NoError(t, verifyAccessToken(access))
+
+	challenge, err := jt.GenerateMFAChallengeTokenString("user_id")
+	require.NoError(t, err)
+	assert.EqualError(t, verifyAccessToken(challenge), "not an access token")
+}
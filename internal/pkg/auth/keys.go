@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const pemFileExt = ".pem"
+
+// SigningKey is a single asymmetric key pair used to sign and verify tokens, identified by its kid
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// NewSigningKey wraps the given private key, inferring the signing method from the key type.
+// Only RSA (RS256) and Ed25519 (EdDSA) keys are supported
+func NewSigningKey(kid string, private crypto.Signer) (*SigningKey, error) {
+	if kid == "" {
+		return nil, fmt.Errorf("key ID must not be empty")
+	}
+
+	var method jwt.SigningMethod
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T for key %s", private, kid)
+	}
+
+	return &SigningKey{ID: kid, Method: method, private: private, public: private.Public()}, nil
+}
+
+// GenerateEd25519Key creates a new random Ed25519 signing key. This is intended for local development and tests
+func GenerateEd25519Key(kid string) (*SigningKey, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ed25519 key: %v", err)
+	}
+
+	return NewSigningKey(kid, private)
+}
+
+// ParseSigningKeyPEM parses a PEM encoded private key.
+// Accepts PKCS#8 ("PRIVATE KEY") for RSA and Ed25519 and PKCS#1 ("RSA PRIVATE KEY") for RSA
+func ParseSigningKeyPEM(kid string, pemBytes []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found for key %s", kid)
+	}
+
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q for key %s", block.Type, kid)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %v", kid, err)
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key %s cannot be used for signing", kid)
+	}
+
+	return NewSigningKey(kid, signer)
+}
+
+// KeySet holds every key the service will accept when validating tokens alongside the single key used to sign new ones.
+// Rotating keys means adding the new key, making it active and, once all tokens signed with the old key have expired, removing it
+type KeySet struct {
+	mu       sync.RWMutex
+	keys     map[string]*SigningKey
+	activeID string
+}
+
+// NewKeySet creates a KeySet from the given keys. The active key is set to activeID,
+// or if activeID is empty to the key with the lexicographically greatest ID (e.g. date-stamped file names)
+func NewKeySet(activeID string, keys ...*SigningKey) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key set must contain at least one key")
+	}
+
+	ks := &KeySet{keys: make(map[string]*SigningKey, len(keys))}
+	for _, k := range keys {
+		if _, exists := ks.keys[k.ID]; exists {
+			return nil, fmt.Errorf("duplicate key ID %s", k.ID)
+		}
+		ks.keys[k.ID] = k
+	}
+
+	if activeID == "" {
+		ids := ks.IDs()
+		activeID = ids[len(ids)-1]
+	}
+
+	if err := ks.Rotate(activeID); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// LoadKeySetFromDir loads every *.pem file in dir as a signing key, using the file name (without extension) as the kid
+func LoadKeySetFromDir(dir, activeID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+pemFileExt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys in %s: %v", dir, err)
+	}
+
+	keys := make([]*SigningKey, 0, len(paths))
+	for _, path := range paths {
+		pemBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file %s: %v", path, err)
+		}
+
+		key, err := ParseSigningKeyPEM(strings.TrimSuffix(filepath.Base(path), pemFileExt), pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(activeID, keys...)
+}
+
+// LoadKeySetFromEnv loads a signing key from every env var with the given prefix, using the lower-cased remainder of the
+// var name as the kid. Values may be raw PEM or base64 encoded PEM, the latter being easier to pass through most tooling
+func LoadKeySetFromEnv(prefix, activeID string) (*KeySet, error) {
+	var keys []*SigningKey
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) {
+			continue
+		}
+
+		kid := strings.ToLower(strings.TrimPrefix(parts[0], prefix))
+		pemBytes := []byte(parts[1])
+		if decoded, err := base64.StdEncoding.DecodeString(parts[1]); err == nil {
+			pemBytes = decoded
+		}
+
+		key, err := ParseSigningKeyPEM(kid, pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(activeID, keys...)
+}
+
+// Rotate makes the key with the given ID the one used to sign new tokens. Previously active keys remain valid for verification
+func (ks *KeySet) Rotate(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, ok := ks.keys[kid]; !ok {
+		return fmt.Errorf("no such key %s", kid)
+	}
+
+	ks.activeID = kid
+
+	return nil
+}
+
+// Add adds a key to the set so that tokens signed with it are accepted. It does not make the key active
+func (ks *KeySet) Add(key *SigningKey) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, exists := ks.keys[key.ID]; exists {
+		return fmt.Errorf("duplicate key ID %s", key.ID)
+	}
+	ks.keys[key.ID] = key
+
+	return nil
+}
+
+// Remove retires a key so that tokens signed with it are no longer accepted. The active key cannot be removed
+func (ks *KeySet) Remove(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if kid == ks.activeID {
+		return fmt.Errorf("cannot remove active key %s", kid)
+	}
+	delete(ks.keys, kid)
+
+	return nil
+}
+
+// Active returns the key currently used for signing
+func (ks *KeySet) Active() *SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.keys[ks.activeID]
+}
+
+// Get returns the key with the given ID
+func (ks *KeySet) Get(kid string) (*SigningKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	k, ok := ks.keys[kid]
+
+	return k, ok
+}
+
+// IDs returns the IDs of all keys in the set in sorted order
+func (ks *KeySet) IDs() []string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// JWK is the RFC 7517 JSON representation of a public key
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP (Ed25519)
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is the RFC 7517 JSON Web Key Set document served to other services
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public half of the key in JWK format
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Method.Alg()}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return jwk
+}
+
+// JWKS returns the public keys of every key in the set, so that verifiers keep accepting tokens signed by retiring keys
+func (ks *KeySet) JWKS() JWKS {
+	ids := ks.IDs()
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(ids))}
+	for _, id := range ids {
+		if k, ok := ks.Get(id); ok {
+			jwks.Keys = append(jwks.Keys, k.JWK())
+		}
+	}
+
+	return jwks
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pkcs8PEM(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestLoadKeySetFromDir_successPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaPrivate)})
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "2022-01-01.pem"), pkcs1, 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "2022-07-01.pem"), pkcs8PEM(t, edPrivate), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a key"), 0600))
+
+	ks, err := LoadKeySetFromDir(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2022-01-01", "2022-07-01"}, ks.IDs())
+	assert.Equal(t, "2022-07-01", ks.Active().ID)
+
+	ks, err = LoadKeySetFromDir(dir, "2022-01-01")
+	require.NoError(t, err)
+	assert.Equal(t, "RS256", ks.Active().Method.Alg())
+}
+
+func TestLoadKeySetFromEnv_successPath(t *testing.T) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	os.Setenv("TEST_JWT_KEY_OLD", string(pkcs8PEM(t, rsaPrivate)))
+	os.Setenv("TEST_JWT_KEY_NEW", base64.StdEncoding.EncodeToString(pkcs8PEM(t, edPrivate)))
+	defer os.Unsetenv("TEST_JWT_KEY_OLD")
+	defer os.Unsetenv("TEST_JWT_KEY_NEW")
+
+	ks, err := LoadKeySetFromEnv("TEST_JWT_KEY_", "new")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new", "old"}, ks.IDs())
+	assert.Equal(t, "EdDSA", ks.Active().Method.Alg())
+}
+
+func TestNewKeySet_failurePath(t *testing.T) {
+	_, err := NewKeySet("")
+	assert.EqualError(t, err, "key set must contain at least one key")
+
+	k, err := GenerateEd25519Key("a")
+	require.NoError(t, err)
+
+	_, err = NewKeySet("", k, k)
+	assert.EqualError(t, err, "duplicate key ID a")
+
+	_, err = NewKeySet("b", k)
+	assert.EqualError(t, err, "no such key b")
+
+	_, err = ParseSigningKeyPEM("c", []byte("nope"))
+	assert.EqualError(t, err, "no PEM data found for key c")
+}
+
+func TestKeySetRemove_activeKey_failurePath(t *testing.T) {
+	k, err := GenerateEd25519Key("a")
+	require.NoError(t, err)
+	ks, err := NewKeySet("", k)
+	require.NoError(t, err)
+
+	assert.EqualError(t, ks.Remove("a"), "cannot remove active key a")
+}
+
+func TestKeySetJWKS_successPath(t *testing.T) {
+	ks := newTestKeySet(t)
+
+	jwks := ks.JWKS()
+	require.Len(t, jwks.Keys, 2)
+
+	rsaJWK := jwks.Keys[0]
+	assert.Equal(t, "2022-01-01", rsaJWK.KeyID)
+	assert.Equal(t, "RSA", rsaJWK.KeyType)
+	assert.Equal(t, "RS256", rsaJWK.Algorithm)
+	assert.Equal(t, "sig", rsaJWK.Use)
+	assert.Equal(t, "AQAB", rsaJWK.E)
+	assert.NotEmpty(t, rsaJWK.N)
+
+	edJWK := jwks.Keys[1]
+	assert.Equal(t, "2022-07-01", edJWK.KeyID)
+	assert.Equal(t, "OKP", edJWK.KeyType)
+	assert.Equal(t, "Ed25519", edJWK.Curve)
+	assert.Equal(t, "EdDSA", edJWK.Algorithm)
+
+	x, err := base64.RawURLEncoding.DecodeString(edJWK.X)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(ks.Active().public.(ed25519.PublicKey)), x)
+}
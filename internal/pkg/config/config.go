@@ -0,0 +1,254 @@
+// Package config loads the service's configuration from, in increasing order of precedence,
+// defaults, a YAML file, env vars and command line flags
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	EnvironmentDev     = "dev"
+	EnvironmentStaging = "staging"
+	EnvironmentProd    = "prod"
+
+	StorageMySQL    = "mysql"
+	StorageSQLite   = "sqlite"
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+// Config is the service's configuration. Each field can be set in the YAML file under its yaml key,
+// and leaf fields by the env var and flag named in their tags. Fields tagged secret are redacted from Dump,
+// can also be read from the file named by the env var with a _FILE suffix, and have no flag as flags are visible to
+// anyone who can list processes
+type Config struct {
+	Version     string    `yaml:"version" env:"SVC_VERSION" flag:"version"`
+	Environment string    `yaml:"environment" env:"SVC_ENVIRONMENT" flag:"environment"`
+	Server      Server    `yaml:"server"`
+	Storage     Storage   `yaml:"storage"`
+	DB          DB        `yaml:"db"`
+	SQLite      SQLite    `yaml:"sqlite"`
+	Postgres    Postgres  `yaml:"postgres"`
+	JWT         JWT       `yaml:"jwt"`
+	OIDC        OIDC      `yaml:"oidc"`
+	RateLimit   RateLimit `yaml:"rate_limit"`
+	Tracing     Tracing   `yaml:"tracing"`
+	Logging     Logging   `yaml:"logging"`
+	Cache       Cache     `yaml:"cache"`
+}
+
+type Server struct {
+	Host string `yaml:"host" env:"SVC_HOST" flag:"host"`
+	Port int    `yaml:"port" env:"SVC_PORT" flag:"port"`
+	// MetricsPort serves /metrics apart from the API, so it must not be exposed publicly
+	MetricsPort int            `yaml:"metrics_port" env:"SVC_METRICS_PORT" flag:"metrics-port"`
+	Timeouts    ServerTimeouts `yaml:"timeouts"`
+}
+
+// ServerTimeouts are set as durations e.g. 30s or 2m, see app.Timeouts
+type ServerTimeouts struct {
+	ReadHeader    time.Duration `yaml:"read_header" env:"SVC_READ_HEADER_TIMEOUT" flag:"read-header-timeout"`
+	Read          time.Duration `yaml:"read" env:"SVC_READ_TIMEOUT" flag:"read-timeout"`
+	Write         time.Duration `yaml:"write" env:"SVC_WRITE_TIMEOUT" flag:"write-timeout"`
+	Idle          time.Duration `yaml:"idle" env:"SVC_IDLE_TIMEOUT" flag:"idle-timeout"`
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" env:"SVC_SHUTDOWN_DELAY" flag:"shutdown-delay"`
+	Drain         time.Duration `yaml:"drain" env:"SVC_DRAIN_TIMEOUT" flag:"drain-timeout"`
+}
+
+type Storage struct {
+	// Backend is one of mysql, postgres, sqlite or memory. Postgres must have the PostGIS extension available.
+	// SQLite suits single node deployments. Everything held in memory is lost on restart, so it is only for running locally
+	Backend string `yaml:"backend" env:"STORAGE_BACKEND" flag:"storage-backend"`
+	// MigrateOnStart applies pending migrations before serving, otherwise they are applied with the migrate subcommand
+	MigrateOnStart bool `yaml:"migrate_on_start" env:"STORAGE_MIGRATE_ON_START" flag:"storage-migrate-on-start"`
+}
+
+type DB struct {
+	Host     string `yaml:"host" env:"DB_HOST" flag:"db-host"`
+	Port     int    `yaml:"port" env:"DB_PORT" flag:"db-port"`
+	User     string `yaml:"user" env:"DB_USER" flag:"db-user"`
+	Password string `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" env:"DB_NAME" flag:"db-name"`
+}
+
+type SQLite struct {
+	// Path is the database file, created if it doesn't exist
+	Path string `yaml:"path" env:"SQLITE_PATH" flag:"sqlite-path"`
+}
+
+type Postgres struct {
+	Host     string `yaml:"host" env:"POSTGRES_HOST" flag:"postgres-host"`
+	Port     int    `yaml:"port" env:"POSTGRES_PORT" flag:"postgres-port"`
+	User     string `yaml:"user" env:"POSTGRES_USER" flag:"postgres-user"`
+	Password string `yaml:"password" env:"POSTGRES_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" env:"POSTGRES_NAME" flag:"postgres-name"`
+	// SSLMode is passed to the driver as sslmode, e.g. disable or verify-full
+	SSLMode string `yaml:"ssl_mode" env:"POSTGRES_SSL_MODE" flag:"postgres-ssl-mode"`
+}
+
+type JWT struct {
+	// KeysDir holds a PEM file per signing key. When not set keys are read from JWT_KEY_* env vars
+	KeysDir     string `yaml:"keys_dir" env:"JWT_KEYS_DIR" flag:"jwt-keys-dir"`
+	ActiveKeyID string `yaml:"active_key_id" env:"JWT_ACTIVE_KEY_ID" flag:"jwt-active-key-id"`
+}
+
+type OIDC struct {
+	// StateSecret signs the cookie holding a login in progress. Replicas must share it, so it is required outside dev
+	// when there are providers. In dev one is generated if it's not set
+	StateSecret string `yaml:"state_secret" env:"OIDC_STATE_SECRET" secret:"true"`
+	// Providers are keyed by name. Providers can also be listed in OIDC_PROVIDERS, comma separated,
+	// each configured by OIDC_<NAME>_ISSUER_URL, _CLIENT_ID, _CLIENT_SECRET and _REDIRECT_URL
+	Providers map[string]OIDCProvider `yaml:"providers"`
+}
+
+type OIDCProvider struct {
+	IssuerURL    string `yaml:"issuer_url" env:"ISSUER_URL"`
+	ClientID     string `yaml:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `yaml:"client_secret" env:"CLIENT_SECRET" secret:"true"`
+	RedirectURL  string `yaml:"redirect_url" env:"REDIRECT_URL"`
+}
+
+type RateLimit struct {
+	// RedisAddr shares rate limits between replicas. When not set they are held in memory
+	RedisAddr string `yaml:"redis_addr" env:"RATE_LIMIT_REDIS_ADDR" flag:"rate-limit-redis-addr"`
+}
+
+type Tracing struct {
+	// Exporter is one of otlp, stdout or none. The otlp exporter is configured by the standard OTEL_EXPORTER_OTLP_* env vars
+	Exporter string `yaml:"exporter" env:"TRACE_EXPORTER" flag:"trace-exporter"`
+}
+
+type Logging struct {
+	// Bodies is one of off, errors or sampled
+	Bodies         string  `yaml:"bodies" env:"LOG_BODIES" flag:"log-bodies"`
+	BodySampleRate float64 `yaml:"body_sample_rate" env:"LOG_BODY_SAMPLE_RATE" flag:"log-body-sample-rate"`
+	MaxBodyBytes   int     `yaml:"max_body_bytes" env:"LOG_MAX_BODY_BYTES" flag:"log-max-body-bytes"`
+}
+
+// Cache sets the Cache-Control header sent with each kind of resource, e.g. "private, max-age=60".
+// Responses carry an ETag and Last-Modified whatever it is set to, so clients can always revalidate
+type Cache struct {
+	// Users must stay private as they hold email addresses
+	Users string `yaml:"users" env:"CACHE_CONTROL_USERS" flag:"cache-control-users"`
+}
+
+// Default is the configuration used for anything not set by another source, it suits running locally
+func Default() Config {
+	return Config{
+		Version:     "v0.0.0",
+		Environment: EnvironmentDev,
+		Server: Server{
+			Host:        "0.0.0.0",
+			Port:        8080,
+			MetricsPort: 9090,
+			// these match app.DefaultTimeouts, fitting within the 30 second grace period Kubernetes gives pods to terminate
+			Timeouts: ServerTimeouts{
+				ReadHeader:    10 * time.Second,
+				Read:          2 * time.Minute,
+				Write:         2 * time.Minute,
+				Idle:          2 * time.Minute,
+				ShutdownDelay: 5 * time.Second,
+				Drain:         20 * time.Second,
+			},
+		},
+		Storage:  Storage{Backend: StorageMySQL},
+		DB:       DB{Host: "localhost", Port: 3306, User: "root", Name: "graffiti"},
+		SQLite:   SQLite{Path: "graffiti.db"},
+		Postgres: Postgres{Host: "localhost", Port: 5432, User: "postgres", Name: "graffiti", SSLMode: "disable"},
+		Tracing:  Tracing{Exporter: "none"},
+		Logging:  Logging{Bodies: "errors", BodySampleRate: 0.01, MaxBodyBytes: 4 << 10},
+		Cache:    Cache{Users: "private, no-cache"},
+	}
+}
+
+// Validate reports every problem with the config at once
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(oneOf(c.Environment, EnvironmentDev, EnvironmentStaging, EnvironmentProd), "environment must be one of dev, staging, prod")
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535")
+	check(c.Server.MetricsPort > 0 && c.Server.MetricsPort <= 65535, "server.metrics_port must be between 1 and 65535")
+	check(c.Server.MetricsPort != c.Server.Port, "server.metrics_port must differ from server.port")
+	timeouts := c.Server.Timeouts
+	for _, t := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read_header", timeouts.ReadHeader},
+		{"read", timeouts.Read},
+		{"write", timeouts.Write},
+		{"idle", timeouts.Idle},
+		{"shutdown_delay", timeouts.ShutdownDelay},
+		{"drain", timeouts.Drain},
+	} {
+		check(t.value > 0, "server.timeouts.%s must be positive", t.name)
+	}
+	check(
+		oneOf(c.Storage.Backend, StorageMySQL, StoragePostgres, StorageSQLite, StorageMemory),
+		"storage.backend must be one of mysql, postgres, sqlite, memory",
+	)
+	if c.Storage.Backend == StorageMySQL {
+		check(c.DB.Host != "", "db.host must not be empty")
+		check(c.DB.Port > 0 && c.DB.Port <= 65535, "db.port must be between 1 and 65535")
+		check(c.DB.User != "", "db.user must not be empty")
+		check(c.DB.Name != "", "db.name must not be empty")
+	} else if c.Storage.Backend == StoragePostgres {
+		check(c.Postgres.Host != "", "postgres.host must not be empty")
+		check(c.Postgres.Port > 0 && c.Postgres.Port <= 65535, "postgres.port must be between 1 and 65535")
+		check(c.Postgres.User != "", "postgres.user must not be empty")
+		check(c.Postgres.Name != "", "postgres.name must not be empty")
+		check(
+			oneOf(c.Postgres.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+			"postgres.ssl_mode must be one of disable, allow, prefer, require, verify-ca, verify-full",
+		)
+	} else if c.Storage.Backend == StorageSQLite {
+		check(c.SQLite.Path != "", "sqlite.path must not be empty")
+	}
+
+	check(oneOf(c.Tracing.Exporter, "otlp", "stdout", "none"), "tracing.exporter must be one of otlp, stdout, none")
+	check(oneOf(c.Logging.Bodies, "off", "errors", "sampled"), "logging.bodies must be one of off, errors, sampled")
+	check(c.Logging.BodySampleRate >= 0 && c.Logging.BodySampleRate <= 1, "logging.body_sample_rate must be between 0 and 1")
+	check(c.Logging.MaxBodyBytes >= 0, "logging.max_body_bytes must not be negative")
+
+	check(!strings.Contains(strings.ToLower(c.Cache.Users), "public"), "cache.users must not be public")
+
+	for name, p := range c.OIDC.Providers {
+		check(p.IssuerURL != "", "oidc.providers.%s.issuer_url must not be empty", name)
+		check(p.ClientID != "", "oidc.providers.%s.client_id must not be empty", name)
+		check(p.RedirectURL != "", "oidc.providers.%s.redirect_url must not be empty", name)
+	}
+
+	if c.Environment == EnvironmentProd {
+		check(c.Storage.Backend != StorageMemory, "storage.backend must not be memory in prod")
+		check(c.Storage.Backend != StorageMySQL || c.DB.Password != "", "db.password must be set in prod")
+		check(c.Storage.Backend != StoragePostgres || c.Postgres.Password != "", "postgres.password must be set in prod")
+	}
+
+	if c.Environment != EnvironmentDev {
+		// replicas must share the secret for logins started on one to complete on another
+		check(len(c.OIDC.Providers) == 0 || c.OIDC.StateSecret != "", "oidc.state_secret must be set outside dev when oidc providers are")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func oneOf(v string, allowed ...string) bool {
+	for _, a := range allowed {
+		if v == a {
+			return true
+		}
+	}
+
+	return false
+}
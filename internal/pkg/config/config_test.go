@@ -0,0 +1,261 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func envFrom(vars map[string]string) LookupEnv {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func TestLoad_defaults(t *testing.T) {
+	cfg, err := Load("test", nil, envFrom(nil))
+	require.NoError(t, err)
+
+	assert.Equal(t, Default(), *cfg)
+}
+
+func TestLoad_precedence(t *testing.T) {
+	configFile := writeFile(t, "config.yaml", `
+version: v1.0.0
+server:
+  port: 9000
+db:
+  host: db.internal
+  user: file-user
+logging:
+  bodies: sampled
+`)
+
+	env := envFrom(map[string]string{
+		"SVC_CONFIG_FILE": configFile,
+		"DB_HOST":         "db.env",
+		"SVC_PORT":        "9001",
+		// empty env vars are treated as unset
+		"SVC_VERSION": "",
+	})
+
+	cfg, err := Load("test", []string{"-port", "9002", "-log-body-sample-rate", "0.5"}, env)
+	require.NoError(t, err)
+
+	assert.Equal(t, "v1.0.0", cfg.Version)
+	assert.Equal(t, 9002, cfg.Server.Port)
+	assert.Equal(t, "db.env", cfg.DB.Host)
+	assert.Equal(t, "file-user", cfg.DB.User)
+	assert.Equal(t, 3306, cfg.DB.Port)
+	assert.Equal(t, "sampled", cfg.Logging.Bodies)
+	assert.Equal(t, 0.5, cfg.Logging.BodySampleRate)
+}
+
+func TestLoad_timeouts(t *testing.T) {
+	configFile := writeFile(t, "config.yaml", `
+server:
+  timeouts:
+    read: 30s
+    write: 1m
+`)
+
+	env := envFrom(map[string]string{"SVC_CONFIG_FILE": configFile, "SVC_WRITE_TIMEOUT": "45s"})
+
+	cfg, err := Load("test", []string{"-drain-timeout", "10s"}, env)
+	require.NoError(t, err)
+
+	assert.Equal(t, 30*time.Second, cfg.Server.Timeouts.Read)
+	assert.Equal(t, 45*time.Second, cfg.Server.Timeouts.Write)
+	assert.Equal(t, 10*time.Second, cfg.Server.Timeouts.Drain)
+	assert.Equal(t, Default().Server.Timeouts.Idle, cfg.Server.Timeouts.Idle)
+}
+
+func TestLoad_configFileFlagOverridesEnv(t *testing.T) {
+	flagFile := writeFile(t, "flag.yaml", "version: from-flag\n")
+	envFile := writeFile(t, "env.yaml", "version: from-env\n")
+
+	cfg, err := Load("test", []string{"-config", flagFile}, envFrom(map[string]string{"SVC_CONFIG_FILE": envFile}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "from-flag", cfg.Version)
+}
+
+func TestLoad_secretsFromFiles(t *testing.T) {
+	env := envFrom(map[string]string{
+		"DB_PASSWORD_FILE":           writeFile(t, "db_password", "s3cret\n"),
+		"OIDC_PROVIDERS":             "google",
+		"OIDC_GOOGLE_ISSUER_URL":     "https://accounts.google.com",
+		"OIDC_GOOGLE_CLIENT_ID":      "client-id",
+		"OIDC_GOOGLE_CLIENT_SECRET":  "client-secret",
+		"OIDC_GOOGLE_REDIRECT_URL":   "https://example.com/auth/oidc/google/callback",
+		"OIDC_STATE_SECRET_FILE":     writeFile(t, "state_secret", "state-secret"),
+		"SVC_ENVIRONMENT":            EnvironmentProd,
+		"OIDC_GITHUB_CLIENT_ID":      "not listed so ignored",
+		"OIDC_GOOGLE_CLIENT_ID_FILE": "not a secret so ignored",
+	})
+
+	cfg, err := Load("test", nil, env)
+	require.NoError(t, err)
+
+	assert.Equal(t, "s3cret", cfg.DB.Password)
+	assert.Equal(t, "state-secret", cfg.OIDC.StateSecret)
+	assert.Equal(t, map[string]OIDCProvider{
+		"google": {
+			IssuerURL:    "https://accounts.google.com",
+			ClientID:     "client-id",
+			ClientSecret: "client-secret",
+			RedirectURL:  "https://example.com/auth/oidc/google/callback",
+		},
+	}, cfg.OIDC.Providers)
+}
+
+func TestLoad_failurePath(t *testing.T) {
+	testCases := []struct {
+		name        string
+		args        []string
+		env         map[string]string
+		file        string
+		expectedErr string
+	}{
+		{
+			name:        "secret set both directly and from file",
+			env:         map[string]string{"DB_PASSWORD": "a", "DB_PASSWORD_FILE": "/run/secrets/db_password"},
+			expectedErr: "only one of DB_PASSWORD and DB_PASSWORD_FILE may be set",
+		},
+		{
+			name:        "secret file missing",
+			env:         map[string]string{"DB_PASSWORD_FILE": "/does/not/exist"},
+			expectedErr: "failed to read DB_PASSWORD_FILE: open /does/not/exist: no such file or directory",
+		},
+		{
+			name:        "env var of wrong type",
+			env:         map[string]string{"DB_PORT": "mysql"},
+			expectedErr: `env var DB_PORT must be an integer, got "mysql"`,
+		},
+		{
+			name:        "flag of wrong type",
+			args:        []string{"-log-body-sample-rate", "often"},
+			expectedErr: `flag -log-body-sample-rate must be a number, got "often"`,
+		},
+		{
+			name:        "timeout of wrong type",
+			env:         map[string]string{"SVC_READ_TIMEOUT": "30"},
+			expectedErr: `env var SVC_READ_TIMEOUT must be a duration e.g. 30s, got "30"`,
+		},
+		{
+			name:        "non positive timeouts",
+			args:        []string{"-idle-timeout", "0s", "-shutdown-delay", "-1s"},
+			expectedErr: "invalid config: server.timeouts.idle must be positive; server.timeouts.shutdown_delay must be positive",
+		},
+		{
+			name:        "unknown flag",
+			args:        []string{"-db-password", "s3cret"},
+			expectedErr: "flag provided but not defined: -db-password",
+		},
+		{
+			name:        "unknown key in file",
+			file:        "db:\n  hostname: db.internal\n",
+			expectedErr: "field hostname not found in type config.DB",
+		},
+		{
+			name: "invalid values",
+			env: map[string]string{
+				"SVC_ENVIRONMENT": EnvironmentProd,
+				"SVC_PORT":        "70000",
+				"TRACE_EXPORTER":  "zipkin",
+			},
+			expectedErr: "invalid config: server.port must be between 1 and 65535; " +
+				"tracing.exporter must be one of otlp, stdout, none; db.password must be set in prod",
+		},
+		{
+			name: "invalid postgres config",
+			env: map[string]string{
+				"SVC_ENVIRONMENT":   EnvironmentProd,
+				"STORAGE_BACKEND":   StoragePostgres,
+				"POSTGRES_SSL_MODE": "maybe",
+			},
+			expectedErr: "invalid config: postgres.ssl_mode must be one of disable, allow, prefer, require, verify-ca, verify-full; " +
+				"postgres.password must be set in prod",
+		},
+		{
+			name:        "memory storage in prod",
+			env:         map[string]string{"SVC_ENVIRONMENT": EnvironmentProd, "STORAGE_BACKEND": StorageMemory},
+			expectedErr: "invalid config: storage.backend must not be memory in prod",
+		},
+		{
+			name:        "metrics served with the api",
+			env:         map[string]string{"SVC_METRICS_PORT": "8080"},
+			expectedErr: "invalid config: server.metrics_port must differ from server.port",
+		},
+		{
+			name: "oidc without a state secret outside dev",
+			env: map[string]string{
+				"SVC_ENVIRONMENT":          EnvironmentStaging,
+				"OIDC_PROVIDERS":           "google",
+				"OIDC_GOOGLE_ISSUER_URL":   "https://accounts.google.com",
+				"OIDC_GOOGLE_CLIENT_ID":    "client-id",
+				"OIDC_GOOGLE_REDIRECT_URL": "https://example.com/auth/oidc/google/callback",
+			},
+			expectedErr: "invalid config: oidc.state_secret must be set outside dev when oidc providers are",
+		},
+		{
+			name:        "users cached publicly",
+			env:         map[string]string{"CACHE_CONTROL_USERS": "public, max-age=60"},
+			expectedErr: "invalid config: cache.users must not be public",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.file != "" {
+				if tc.env == nil {
+					tc.env = map[string]string{}
+				}
+
+				tc.env["SVC_CONFIG_FILE"] = writeFile(t, "config.yaml", tc.file)
+			}
+
+			_, err := Load("test", tc.args, envFrom(tc.env))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestLoad_help(t *testing.T) {
+	_, err := Load("test", []string{"-h"}, envFrom(nil))
+	assert.Equal(t, flag.ErrHelp, err)
+}
+
+func TestDump(t *testing.T) {
+	cfg := Default()
+	cfg.DB.Password = "s3cret"
+	cfg.OIDC.Providers = map[string]OIDCProvider{"google": {ClientID: "client-id", ClientSecret: "client-secret"}}
+
+	dump := cfg.Dump()
+
+	assert.NotContains(t, dump, "s3cret")
+	assert.NotContains(t, dump, "client-secret")
+	assert.Contains(t, dump, "password: '[REDACTED]'")
+	assert.Contains(t, dump, "client_secret: '[REDACTED]'")
+	assert.Contains(t, dump, "client_id: client-id")
+	// unset secrets are left empty
+	assert.Contains(t, dump, `state_secret: ""`)
+
+	// the config itself is untouched
+	assert.Equal(t, "s3cret", cfg.DB.Password)
+	assert.Equal(t, "client-secret", cfg.OIDC.Providers["google"].ClientSecret)
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Dump returns the config as YAML with secrets redacted, for logging the effective config at startup.
+// Unset secrets are left empty so it's clear they're missing
+func (c Config) Dump() string {
+	providers := make(map[string]OIDCProvider, len(c.OIDC.Providers))
+	for name, p := range c.OIDC.Providers {
+		redactSecrets(reflect.ValueOf(&p).Elem())
+		providers[name] = p
+	}
+
+	c.OIDC.Providers = providers
+	redactSecrets(reflect.ValueOf(&c).Elem())
+
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("failed to dump config: %v", err)
+	}
+
+	return string(b)
+}
+
+func redactSecrets(v reflect.Value) {
+	eachField(v, "", func(f field) error {
+		if f.secret && f.value.String() != "" {
+			f.value.SetString(redacted)
+		}
+
+		return nil
+	})
+}
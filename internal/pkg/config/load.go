@@ -0,0 +1,272 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFileFlag = "config"
+	configFileEnv  = "SVC_CONFIG_FILE"
+	// fileEnvSuffix names an env var holding the path to a file containing a secret, as mounted by Docker and Kubernetes
+	fileEnvSuffix = "_FILE"
+
+	oidcProvidersEnv = "OIDC_PROVIDERS"
+	oidcEnvPrefix    = "OIDC_"
+)
+
+// LookupEnv retrieves env vars, it matches os.LookupEnv
+type LookupEnv func(key string) (string, bool)
+
+// Load builds the config from defaults, the YAML file named by the -config flag or SVC_CONFIG_FILE, env vars and
+// the command line arguments, which should not include the program name. The config is validated before it is returned.
+// flag.ErrHelp is returned if the arguments ask for usage
+func Load(name string, args []string, lookupEnv LookupEnv) (*Config, error) {
+	flagVals, configFile, err := parseFlags(name, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if configFile == "" {
+		configFile, _ = lookupEnv(configFileEnv)
+	}
+
+	cfg := Default()
+	if configFile != "" {
+		if err := loadFile(&cfg, configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loadEnv(&cfg, lookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := applyFlags(&cfg, flagVals); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// field is a leaf of the config
+type field struct {
+	path   string
+	env    string
+	flag   string
+	secret bool
+	value  reflect.Value
+}
+
+// eachField calls fn for each leaf field of the struct v, skipping maps as they have no fixed fields
+func eachField(v reflect.Value, prefix string, fn func(field) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		path := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+
+		switch fv := v.Field(i); fv.Kind() {
+		case reflect.Struct:
+			if err := eachField(fv, path, fn); err != nil {
+				return err
+			}
+		case reflect.Map:
+			continue
+		default:
+			f := field{path: path, env: sf.Tag.Get("env"), flag: sf.Tag.Get("flag"), secret: sf.Tag.Get("secret") == "true", value: fv}
+			if err := fn(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setField(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("must be a duration e.g. 30s, got %q", s)
+		}
+
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("must be an integer, got %q", s)
+		}
+
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("must be a number, got %q", s)
+		}
+
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("must be true or false, got %q", s)
+		}
+
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported config field type %s", v.Type())
+	}
+
+	return nil
+}
+
+// parseFlags returns the values of the flags that were set, keyed by name, along with the config file flag
+func parseFlags(name string, args []string) (map[string]string, string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String(configFileFlag, "", "path to a YAML config file, also set by "+configFileEnv)
+
+	defaults := Default()
+	eachField(reflect.ValueOf(&defaults).Elem(), "", func(f field) error {
+		if f.flag != "" {
+			fs.String(f.flag, fmt.Sprint(f.value.Interface()), fmt.Sprintf("%s, also set by %s", f.path, f.env))
+		}
+
+		return nil
+	})
+
+	if err := fs.Parse(args); err != nil {
+		return nil, "", err
+	}
+
+	flagVals := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		flagVals[f.Name] = f.Value.String()
+	})
+
+	return flagVals, *configFile, nil
+}
+
+// loadFile rejects unknown keys so that typos don't silently leave the default in place
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+func loadEnv(cfg *Config, lookupEnv LookupEnv) error {
+	err := eachField(reflect.ValueOf(cfg).Elem(), "", func(f field) error {
+		return loadEnvField(f, "", lookupEnv)
+	})
+	if err != nil {
+		return err
+	}
+
+	providers, _ := lookupEnv(oidcProvidersEnv)
+	for _, name := range strings.Split(providers, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if cfg.OIDC.Providers == nil {
+			cfg.OIDC.Providers = map[string]OIDCProvider{}
+		}
+
+		p := cfg.OIDC.Providers[name]
+		envPrefix := oidcEnvPrefix + strings.ToUpper(name) + "_"
+		err := eachField(reflect.ValueOf(&p).Elem(), "oidc.providers."+name, func(f field) error {
+			return loadEnvField(f, envPrefix, lookupEnv)
+		})
+		if err != nil {
+			return err
+		}
+
+		cfg.OIDC.Providers[name] = p
+	}
+
+	return nil
+}
+
+// loadEnvField treats empty env vars as unset
+func loadEnvField(f field, envPrefix string, lookupEnv LookupEnv) error {
+	if f.env == "" {
+		return nil
+	}
+
+	name := envPrefix + f.env
+	val, _ := lookupEnv(name)
+
+	if f.secret {
+		if path, _ := lookupEnv(name + fileEnvSuffix); path != "" {
+			if val != "" {
+				return fmt.Errorf("only one of %s and %s%s may be set", name, name, fileEnvSuffix)
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s%s: %v", name, fileEnvSuffix, err)
+			}
+
+			val = strings.TrimRight(string(b), "\r\n")
+		}
+	}
+
+	if val == "" {
+		return nil
+	}
+
+	if err := setField(f.value, val); err != nil {
+		return fmt.Errorf("env var %s %v", name, err)
+	}
+
+	return nil
+}
+
+func applyFlags(cfg *Config, flagVals map[string]string) error {
+	return eachField(reflect.ValueOf(cfg).Elem(), "", func(f field) error {
+		val, ok := flagVals[f.flag]
+		if f.flag == "" || !ok {
+			return nil
+		}
+
+		if err := setField(f.value, val); err != nil {
+			return fmt.Errorf("flag -%s %v", f.flag, err)
+		}
+
+		return nil
+	})
+}
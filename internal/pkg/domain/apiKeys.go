@@ -0,0 +1,196 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeUsersRead   = "users:read"
+	ScopeUsersWrite  = "users:write"
+	ScopePiecesRead  = "pieces:read"
+	ScopePiecesWrite = "pieces:write"
+
+	// apiKeyPrefix makes keys recognisable e.g. to secret scanners
+	apiKeyPrefix      = "gbs_"
+	apiKeySecretBytes = 32
+	// apiKeyDisplayLen is how much of the key is kept in the clear so users can tell their keys apart
+	apiKeyDisplayLen  = len(apiKeyPrefix) + 8
+	maxAPIKeyNameLen  = 100
+	maxAPIKeysPerUser = 20
+	// apiKeyTouchInterval limits how often last used timestamps are written for busy keys
+	apiKeyTouchInterval = time.Minute
+)
+
+var validScopes = map[string]bool{
+	ScopeUsersRead:   true,
+	ScopeUsersWrite:  true,
+	ScopePiecesRead:  true,
+	ScopePiecesWrite: true,
+}
+
+// APIKey is a long lived credential a user can give to scripts and integrations. Only a hash of the key is stored
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// HasScope reports whether the key grants the given scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateAPIKey creates a key for the user, returning it along with the plaintext key which is never available again
+func (s *Service) CreateAPIKey(ctx context.Context, userID, name string, scopes []string) (_ *APIKey, _ string, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "CreateAPIKey")
+	defer func() { endSpan(span, dErr) }()
+
+	name = strings.TrimSpace(name)
+	if err := validateAPIKey(name, scopes); err != nil {
+		return nil, "", newInvalidInputError("api key is invalid", err)
+	}
+
+	if _, dErr := s.GetUser(ctx, userID); dErr != nil {
+		return nil, "", dErr
+	}
+
+	existing, err := s.repo.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, "", newSystemError("failed to retrieve api keys", err)
+	} else if len(existing) >= maxAPIKeysPerUser {
+		return nil, "", newResourceConflictError(fmt.Sprintf("users may have at most %d api keys", maxAPIKeysPerUser), nil)
+	}
+
+	id, err := s.idTool.New()
+	if err != nil {
+		return nil, "", newSystemError("failed to generate id", err)
+	}
+
+	plaintext, err := newAPIKeyString()
+	if err != nil {
+		return nil, "", newSystemError("failed to generate api key", err)
+	}
+
+	key := APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Prefix:    plaintext[:apiKeyDisplayLen],
+		Hash:      hashAPIKey(plaintext),
+		Scopes:    scopes,
+		CreatedAt: s.now(),
+	}
+
+	if err := s.repo.CreateAPIKey(ctx, key); err != nil {
+		return nil, "", newSystemError("failed to create api key", err)
+	}
+
+	return &key, plaintext, nil
+}
+
+// ListAPIKeys returns the user's unrevoked keys
+func (s *Service) ListAPIKeys(ctx context.Context, userID string) (_ []APIKey, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "ListAPIKeys")
+	defer func() { endSpan(span, dErr) }()
+
+	keys, err := s.repo.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, newSystemError("failed to retrieve api keys", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey stops the key from being accepted
+func (s *Service) RevokeAPIKey(ctx context.Context, userID, keyID string) (dErr *Error) {
+	ctx, span := s.startSpan(ctx, "RevokeAPIKey")
+	defer func() { endSpan(span, dErr) }()
+
+	revoked, err := s.repo.RevokeAPIKey(ctx, userID, keyID, s.now())
+	if err != nil {
+		return newSystemError("failed to revoke api key", err)
+	} else if !revoked {
+		return newResourceNotFoundError("api key not found", nil)
+	}
+
+	return nil
+}
+
+// AuthenticateAPIKey returns the unrevoked key matching the plaintext key, recording that it has been used
+func (s *Service) AuthenticateAPIKey(ctx context.Context, plaintext string) (_ *APIKey, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "AuthenticateAPIKey")
+	defer func() { endSpan(span, dErr) }()
+
+	if !strings.HasPrefix(plaintext, apiKeyPrefix) {
+		return nil, newUnauthorizedError("api key is invalid", nil)
+	}
+
+	key, err := s.repo.GetAPIKeyByHash(ctx, hashAPIKey(plaintext))
+	if errors.Is(err, ErrNotFound) {
+		return nil, newUnauthorizedError("api key is invalid", nil)
+	} else if err != nil {
+		return nil, newSystemError("failed to retrieve api key", err)
+	}
+
+	now := s.now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		// failing to record usage shouldn't stop the key from working
+		if err := s.repo.TouchAPIKey(ctx, key.ID, now); err != nil {
+			s.logger.WithContext(ctx).WithError(err).WithField("api_key_id", key.ID).Warn("failed to record api key usage")
+		} else {
+			key.LastUsedAt = &now
+		}
+	}
+
+	return key, nil
+}
+
+func validateAPIKey(name string, scopes []string) error {
+	v := &validator{}
+
+	if v.required("name", name) {
+		v.maxLength("name", name, maxAPIKeyNameLen)
+	}
+
+	v.check(len(scopes) > 0, "scopes", RuleRequired, "must not be empty")
+	for _, scope := range scopes {
+		v.oneOf("scopes", scope, validScopes)
+	}
+
+	return v.err()
+}
+
+func newAPIKeyString() (string, error) {
+	b := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashAPIKey uses a fast unsalted hash so keys can be looked up by it, which is safe as keys are random rather than user chosen
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+
+	return hex.EncodeToString(sum[:])
+}
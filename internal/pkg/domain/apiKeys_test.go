@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateAPIKey_successPath(t *testing.T) {
+	mr := &mockRepo{}
+	mIDt := &mockIDTool{}
+
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+	keyID := "4d0e2d7e-8f2c-4a39-9a0a-0ad3e6d9b6f1"
+	now := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+
+	mIDt.On("IsValid", uID).Return(true).Once()
+	mIDt.On("New").Return(keyID, nil).Once()
+	mr.On("GetUser", mock.Anything, uID).Return(NewUser(uID, "JohnDoe", "test@example.com", "hash"), nil).Once()
+	mr.On("ListAPIKeys", mock.Anything, uID).Return([]APIKey{}, nil).Once()
+	mr.On("CreateAPIKey", mock.Anything, mock.MatchedBy(func(k APIKey) bool {
+		return k.ID == keyID && k.UserID == uID && k.Name == "mapping script" && len(k.Hash) == 64
+	})).Return(nil).Once()
+
+	service := NewService(nullLogger(), mr, mIDt, nil)
+	service.now = func() time.Time { return now }
+
+	key, plaintext, err := service.CreateAPIKey(context.Background(), uID, " mapping script ", []string{ScopePiecesRead})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(plaintext, "gbs_"))
+	assert.Equal(t, plaintext[:12], key.Prefix)
+	assert.Equal(t, hashAPIKey(plaintext), key.Hash)
+	assert.Equal(t, []string{ScopePiecesRead}, key.Scopes)
+	assert.Equal(t, now, key.CreatedAt)
+
+	mr.AssertExpectations(t)
+	mIDt.AssertExpectations(t)
+}
+
+func TestCreateAPIKey_invalidInput_failurePath(t *testing.T) {
+	service := NewService(nullLogger(), nil, nil, nil)
+
+	_, _, err := service.CreateAPIKey(context.Background(), "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", "", nil)
+	assert.Equal(t, "api key is invalid", err.Msg)
+	assert.Equal(t, []FieldError{
+		{Field: "name", Rule: RuleRequired, Message: "must not be empty"},
+		{Field: "scopes", Rule: RuleRequired, Message: "must not be empty"},
+	}, err.Fields)
+
+	_, _, err = service.CreateAPIKey(context.Background(), "9abc46be-3bcd-42b1-aeb2-ac6ff557a580", "script", []string{ScopePiecesRead, "users:admin"})
+	assert.Equal(t, []FieldError{{Field: "scopes", Rule: RuleOneOf, Message: "users:admin is not an allowed value"}}, err.Fields)
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+	now := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	recentlyUsed := now.Add(-10 * time.Second)
+	plaintext := "gbs_c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+
+	t.Run("records first use", func(t *testing.T) {
+		mr := &mockRepo{}
+		mr.On("GetAPIKeyByHash", mock.Anything, hashAPIKey(plaintext)).Return(&APIKey{ID: "key", UserID: uID}, nil).Once()
+		mr.On("TouchAPIKey", mock.Anything, "key", now).Return(nil).Once()
+
+		service := NewService(nullLogger(), mr, nil, nil)
+		service.now = func() time.Time { return now }
+
+		key, err := service.AuthenticateAPIKey(context.Background(), plaintext)
+		assert.Nil(t, err)
+		assert.Equal(t, uID, key.UserID)
+		assert.Equal(t, &now, key.LastUsedAt)
+		mr.AssertExpectations(t)
+	})
+
+	t.Run("does not rewrite recent use", func(t *testing.T) {
+		mr := &mockRepo{}
+		mr.On("GetAPIKeyByHash", mock.Anything, hashAPIKey(plaintext)).Return(&APIKey{ID: "key", UserID: uID, LastUsedAt: &recentlyUsed}, nil).Once()
+
+		service := NewService(nullLogger(), mr, nil, nil)
+		service.now = func() time.Time { return now }
+
+		_, err := service.AuthenticateAPIKey(context.Background(), plaintext)
+		assert.Nil(t, err)
+		mr.AssertExpectations(t)
+	})
+
+	t.Run("unknown or revoked key", func(t *testing.T) {
+		mr := &mockRepo{}
+		mr.On("GetAPIKeyByHash", mock.Anything, hashAPIKey(plaintext)).Return(nil, ErrNotFound).Once()
+
+		service := NewService(nullLogger(), mr, nil, nil)
+
+		key, err := service.AuthenticateAPIKey(context.Background(), plaintext)
+		assert.Nil(t, key)
+		assert.Equal(t, newUnauthorizedError("api key is invalid", nil), err)
+		mr.AssertExpectations(t)
+	})
+
+	t.Run("not an api key", func(t *testing.T) {
+		service := NewService(nullLogger(), nil, nil, nil)
+
+		_, err := service.AuthenticateAPIKey(context.Background(), "eyJhbGciOiJIUzI1NiJ9")
+		assert.Equal(t, Unauthorized, err.Code)
+	})
+}
+
+func TestRevokeAPIKey_notFound_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+	now := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	mr.On("RevokeAPIKey", mock.Anything, "user", "key", now).Return(false, nil).Once()
+
+	service := NewService(nullLogger(), mr, nil, nil)
+	service.now = func() time.Time { return now }
+
+	assert.Equal(t, newResourceNotFoundError("api key not found", nil), service.RevokeAPIKey(context.Background(), "user", "key"))
+	mr.AssertExpectations(t)
+}
@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+type AuditEventType string
+
+const (
+	AuditLoginSucceeded AuditEventType = "login_succeeded"
+	AuditLoginFailed    AuditEventType = "login_failed"
+	AuditLoginThrottled AuditEventType = "login_throttled"
+	AuditAccountLocked  AuditEventType = "account_locked"
+	AuditClientLocked   AuditEventType = "client_locked"
+)
+
+// AuditEvent is a security relevant occurrence that should be retained separately from ordinary logs
+type AuditEvent struct {
+	Type     AuditEventType
+	At       time.Time
+	Subject  string
+	UserID   string
+	ClientIP string
+	Detail   map[string]interface{}
+}
+
+type Auditor interface {
+	Audit(ctx context.Context, event AuditEvent)
+}
+
+// WithAuditor sets where audit events are sent. By default they are discarded
+func WithAuditor(a Auditor) ServiceOption {
+	return func(s *Service) {
+		s.auditor = a
+	}
+}
+
+func (s *Service) audit(ctx context.Context, event AuditEvent) {
+	if s.auditor == nil {
+		return
+	}
+
+	event.At = s.now()
+	s.auditor.Audit(ctx, event)
+}
@@ -1,6 +1,10 @@
 package domain
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 type ErrorType int
 
@@ -11,6 +15,8 @@ const (
 	ResourceConflict
 	Unauthorized
 	NotImplemented
+	TooManyRequests
+	PreconditionFailed
 
 	errInvalidInputDataStr = "invalid input data"
 	errResourceNotFoundStr = "resource not found"
@@ -18,15 +24,19 @@ const (
 	errResourceConflictStr = "resource state conflict"
 	errUnauthroizedStr     = "unauthorized"
 	errNotImplementedStr   = "not implemented"
+	errTooManyRequestsStr  = "too many requests"
+	errPreconditionFailed  = "precondition failed"
 )
 
 var domainErrors = map[ErrorType]string{
-	InvalidInput:     errInvalidInputDataStr,
-	ResourceNotFound: errResourceNotFoundStr,
-	SystemError:      errSystemErrorStr,
-	ResourceConflict: errResourceConflictStr,
-	Unauthorized:     errUnauthroizedStr,
-	NotImplemented:   errNotImplementedStr,
+	InvalidInput:       errInvalidInputDataStr,
+	ResourceNotFound:   errResourceNotFoundStr,
+	SystemError:        errSystemErrorStr,
+	ResourceConflict:   errResourceConflictStr,
+	Unauthorized:       errUnauthroizedStr,
+	NotImplemented:     errNotImplementedStr,
+	TooManyRequests:    errTooManyRequestsStr,
+	PreconditionFailed: errPreconditionFailed,
 }
 
 func (errT ErrorType) String() string {
@@ -43,11 +53,23 @@ type Error struct {
 	Code ErrorType
 	Msg  string
 	Err  error
+	// RetryAfter is set on TooManyRequests errors to how long the caller should wait before trying again
+	RetryAfter time.Duration
+	// Fields is set on InvalidInput errors caused by invalid fields of an entity
+	Fields []FieldError
 }
 
 // newInvalidInputError is a helper function that constructs a new domainError of code invalidInputData with the given message and error.
+// If err is a ValidationError its field errors are set as the error's Fields.
 func newInvalidInputError(msg string, err error) *Error {
-	return &Error{Code: InvalidInput, Msg: msg, Err: err}
+	dErr := &Error{Code: InvalidInput, Msg: msg, Err: err}
+
+	var validationErr ValidationError
+	if errors.As(err, &validationErr) {
+		dErr.Fields = validationErr
+	}
+
+	return dErr
 }
 
 // newResourceNotFoundError is a helper function that constructs a new domainError of code resourceNotFound with the given message and error.
@@ -75,6 +97,16 @@ func newNotImplementedError(msg string, err error) *Error {
 	return &Error{Code: NotImplemented, Msg: msg, Err: err}
 }
 
+// newPreconditionFailedError is a helper function that constructs a new domainError of code preconditionFailed with the given message and error.
+func newPreconditionFailedError(msg string, err error) *Error {
+	return &Error{Code: PreconditionFailed, Msg: msg, Err: err}
+}
+
+// newTooManyRequestsError is a helper function that constructs a new domainError of code tooManyRequests with the given message and error.
+func newTooManyRequestsError(msg string, err error) *Error {
+	return &Error{Code: TooManyRequests, Msg: msg, Err: err}
+}
+
 func (cerr *Error) Error() string {
 	errMsg := fmt.Sprintf("%s - %v", domainErrors[cerr.Code], cerr.Msg)
 	if cerr.Err != nil {
@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	maxUserNameLen = 20
+	// length in bytes of the unusable password given to users created via an external identity provider
+	externalUserPasswordBytes = 32
+	userNameSuffixBytes       = 2
+	// how many suffixed user names are tried when the one derived from an identity is taken
+	maxUserNameSuffixAttempts = 5
+)
+
+var userNameDisallowedChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// UserIdentity links an account at an external identity provider to a User
+type UserIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ExternalIdentity is what an external identity provider has asserted about the user logging in
+type ExternalIdentity struct {
+	Provider          string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUserName string
+}
+
+// candidateUserName derives a user name from the identity's preferred user name or failing that its email
+func (ei ExternalIdentity) candidateUserName() string {
+	name := ei.PreferredUserName
+	if name == "" {
+		name = strings.SplitN(ei.Email, "@", 2)[0]
+	}
+
+	name = userNameDisallowedChars.ReplaceAllString(name, "")
+	if len(name) > maxUserNameLen {
+		name = name[:maxUserNameLen]
+	}
+
+	return name
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
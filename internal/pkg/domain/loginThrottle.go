@@ -0,0 +1,205 @@
+package domain
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	loginAttemptKeyAccount = "account:"
+	loginAttemptKeyIP      = "ip:"
+)
+
+// LoginAttempts records recent failed logins for a single key, either an account identifier or a client IP
+type LoginAttempts struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+}
+
+// LoginAttemptStore persists failed login counts. Implementations must make IncrementFailedLogins atomic
+// since concurrent guesses are exactly what we are defending against
+type LoginAttemptStore interface {
+	GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error)
+	// IncrementFailedLogins adds a failure at the given time. If the previous failure was longer than window ago the count restarts at 1
+	IncrementFailedLogins(ctx context.Context, key string, at time.Time, window time.Duration) (*LoginAttempts, error)
+	ResetLoginAttempts(ctx context.Context, key string) error
+}
+
+// ThrottlePolicy describes how quickly logins are slowed down after repeated failures
+type ThrottlePolicy struct {
+	// FreeAttempts is the number of failures allowed before any back-off applies
+	FreeAttempts int
+	// BaseDelay is the back-off after the first failure beyond FreeAttempts, doubling with each subsequent failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutThreshold is the number of failures after which logins are refused for LockoutDuration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// Window is how long failures are remembered for
+	Window time.Duration
+}
+
+var (
+	DefaultAccountThrottlePolicy = ThrottlePolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+		Window:           time.Hour,
+	}
+
+	// many users can share an IP behind NAT so we are more lenient here
+	DefaultIPThrottlePolicy = ThrottlePolicy{
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+		Window:           time.Hour,
+	}
+)
+
+// blockedUntil returns the time before which further login attempts are refused
+func (p ThrottlePolicy) blockedUntil(attempts *LoginAttempts) time.Time {
+	if attempts == nil || attempts.Failures <= p.FreeAttempts {
+		return time.Time{}
+	}
+
+	if attempts.Failures >= p.LockoutThreshold {
+		return attempts.LastFailureAt.Add(p.LockoutDuration)
+	}
+
+	exp := attempts.Failures - p.FreeAttempts - 1
+	delay := time.Duration(float64(p.BaseDelay) * math.Pow(2, float64(exp)))
+	if delay > p.MaxDelay || delay <= 0 {
+		delay = p.MaxDelay
+	}
+
+	return attempts.LastFailureAt.Add(delay)
+}
+
+func (p ThrottlePolicy) isLockedOut(attempts *LoginAttempts) bool {
+	return attempts != nil && attempts.Failures >= p.LockoutThreshold
+}
+
+type loginThrottle struct {
+	store         LoginAttemptStore
+	accountPolicy ThrottlePolicy
+	ipPolicy      ThrottlePolicy
+}
+
+// WithLoginThrottle enables brute-force protection on ValidateUserCredentials
+func WithLoginThrottle(store LoginAttemptStore, accountPolicy, ipPolicy ThrottlePolicy) ServiceOption {
+	return func(s *Service) {
+		s.loginThrottle = &loginThrottle{store: store, accountPolicy: accountPolicy, ipPolicy: ipPolicy}
+	}
+}
+
+// accountAttemptKey identifies the account being logged into. Failures against a user are keyed on their ID,
+// otherwise switching between their user name and email would give twice the budget
+func accountAttemptKey(user *User, userName, email string) string {
+	if user != nil {
+		return loginAttemptKeyAccount + "user:" + user.ID
+	} else if userName != "" {
+		return loginAttemptKeyAccount + "user_name:" + strings.ToLower(userName)
+	}
+
+	return loginAttemptKeyAccount + "email:" + strings.ToLower(email)
+}
+
+func ipAttemptKey(clientIP string) string {
+	return loginAttemptKeyIP + clientIP
+}
+
+// checkLoginAllowed returns a TooManyRequests error if either the account or the client IP is currently backed off
+func (s *Service) checkLoginAllowed(ctx context.Context, accountKey, ipKey string) *Error {
+	if s.loginThrottle == nil {
+		return nil
+	}
+
+	now := s.now()
+	var blockedUntil time.Time
+	for _, check := range []struct {
+		key    string
+		policy ThrottlePolicy
+	}{
+		{accountKey, s.loginThrottle.accountPolicy},
+		{ipKey, s.loginThrottle.ipPolicy},
+	} {
+		if check.key == ipAttemptKey("") {
+			continue
+		}
+
+		attempts, err := s.loginThrottle.store.GetLoginAttempts(ctx, check.key)
+		if err != nil {
+			return newSystemError("failed to retrieve login attempts", err)
+		}
+
+		if until := check.policy.blockedUntil(attempts); until.After(blockedUntil) {
+			blockedUntil = until
+		}
+	}
+
+	if !blockedUntil.After(now) {
+		return nil
+	}
+
+	retryAfter := blockedUntil.Sub(now)
+	s.audit(ctx, AuditEvent{Type: AuditLoginThrottled, Subject: accountKey, ClientIP: ipKeyToIP(ipKey), Detail: map[string]interface{}{"retry_after_seconds": int(math.Ceil(retryAfter.Seconds()))}})
+
+	dErr := newTooManyRequestsError("too many failed login attempts", nil)
+	dErr.RetryAfter = retryAfter
+
+	return dErr
+}
+
+// recordFailedLogin counts a failed login against both the account and the client IP
+func (s *Service) recordFailedLogin(ctx context.Context, accountKey, ipKey, reason string) {
+	clientIP := ipKeyToIP(ipKey)
+	s.audit(ctx, AuditEvent{Type: AuditLoginFailed, Subject: accountKey, ClientIP: clientIP, Detail: map[string]interface{}{"reason": reason}})
+
+	if s.loginThrottle == nil {
+		return
+	}
+
+	now := s.now()
+	attempts, err := s.loginThrottle.store.IncrementFailedLogins(ctx, accountKey, now, s.loginThrottle.accountPolicy.Window)
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).Error("failed to record failed login for account")
+	} else if attempts.Failures == s.loginThrottle.accountPolicy.LockoutThreshold {
+		s.audit(ctx, AuditEvent{Type: AuditAccountLocked, Subject: accountKey, ClientIP: clientIP, Detail: map[string]interface{}{"failures": attempts.Failures}})
+	}
+
+	if clientIP == "" {
+		return
+	}
+
+	attempts, err = s.loginThrottle.store.IncrementFailedLogins(ctx, ipKey, now, s.loginThrottle.ipPolicy.Window)
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).Error("failed to record failed login for client IP")
+	} else if attempts.Failures == s.loginThrottle.ipPolicy.LockoutThreshold {
+		s.audit(ctx, AuditEvent{Type: AuditClientLocked, Subject: accountKey, ClientIP: clientIP, Detail: map[string]interface{}{"failures": attempts.Failures}})
+	}
+}
+
+// recordSuccessfulLogin clears the account's failures. The IP's failures are deliberately kept,
+// otherwise an attacker could reset their budget by periodically logging into an account of their own
+func (s *Service) recordSuccessfulLogin(ctx context.Context, accountKey, ipKey, userID string) {
+	s.audit(ctx, AuditEvent{Type: AuditLoginSucceeded, Subject: accountKey, ClientIP: ipKeyToIP(ipKey), UserID: userID})
+
+	if s.loginThrottle == nil {
+		return
+	}
+
+	if err := s.loginThrottle.store.ResetLoginAttempts(ctx, accountKey); err != nil {
+		s.logger.WithContext(ctx).WithError(err).Error("failed to reset login attempts for account")
+	}
+}
+
+func ipKeyToIP(ipKey string) string {
+	return strings.TrimPrefix(ipKey, loginAttemptKeyIP)
+}
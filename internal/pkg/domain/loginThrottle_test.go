@@ -0,0 +1,181 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestThrottlePolicyBlockedUntil(t *testing.T) {
+	policy := ThrottlePolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         10 * time.Second,
+		LockoutThreshold: 8,
+		LockoutDuration:  time.Hour,
+		Window:           time.Hour,
+	}
+	last := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		failures      int
+		expectedDelay time.Duration
+	}{
+		{failures: 0, expectedDelay: 0},
+		{failures: 3, expectedDelay: 0},
+		{failures: 4, expectedDelay: time.Second},
+		{failures: 5, expectedDelay: 2 * time.Second},
+		{failures: 6, expectedDelay: 4 * time.Second},
+		{failures: 7, expectedDelay: 8 * time.Second},
+		{failures: 8, expectedDelay: time.Hour},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d failures", tc.failures), func(t *testing.T) {
+			until := policy.blockedUntil(&LoginAttempts{Failures: tc.failures, LastFailureAt: last})
+			if tc.expectedDelay == 0 {
+				assert.True(t, until.IsZero())
+			} else {
+				assert.Equal(t, last.Add(tc.expectedDelay), until)
+			}
+		})
+	}
+
+	// back-off is capped at MaxDelay below the lockout threshold
+	policy.LockoutThreshold = 100
+	assert.Equal(t, last.Add(10*time.Second), policy.blockedUntil(&LoginAttempts{Failures: 50, LastFailureAt: last}))
+}
+
+func TestValidateUserCredentials_backOffAfterRepeatedFailures_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+	mpt := &mockPasswordTool{}
+	store := newFakeLoginAttemptStore()
+	auditor := &recordingAuditor{}
+
+	user := NewUser("9abc46be-3bcd-42b1-aeb2-ac6ff557a580", "JohnDoe", "test@example.com", "hash")
+	mr.On("GetUserByUserName", mock.Anything, "JohnDoe").Return(user, nil)
+	mpt.On("Check", "hash", "wrong").Return(ErrPasswordMismatch)
+	mpt.On("Check", "hash", "right").Return(nil)
+
+	policy := ThrottlePolicy{FreeAttempts: 2, BaseDelay: time.Second, MaxDelay: time.Minute, LockoutThreshold: 4, LockoutDuration: time.Hour, Window: time.Hour}
+	service := NewService(nullLogger(), mr, nil, mpt, WithLoginThrottle(store, policy, DefaultIPThrottlePolicy), WithAuditor(auditor))
+
+	now := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err := service.ValidateUserCredentials(context.Background(), "JohnDoe", "", "wrong", "10.0.0.1")
+		assert.Equal(t, Unauthorized, err.Code)
+	}
+
+	// third failure is beyond the free attempts so even the right password is refused until the back-off has passed
+	_, err := service.ValidateUserCredentials(context.Background(), "JohnDoe", "", "right", "10.0.0.1")
+	assert.Equal(t, TooManyRequests, err.Code)
+	assert.Equal(t, time.Second, err.RetryAfter)
+
+	now = now.Add(time.Second)
+	_, err = service.ValidateUserCredentials(context.Background(), "JohnDoe", "", "wrong", "10.0.0.1")
+	assert.Equal(t, Unauthorized, err.Code)
+
+	// fourth failure hits the lockout threshold
+	_, err = service.ValidateUserCredentials(context.Background(), "JohnDoe", "", "right", "10.0.0.1")
+	assert.Equal(t, TooManyRequests, err.Code)
+	assert.Equal(t, time.Hour, err.RetryAfter)
+
+	now = now.Add(time.Hour)
+	u, err := service.ValidateUserCredentials(context.Background(), "JohnDoe", "", "right", "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, user, u)
+
+	// success clears the account's failures but not the IP's
+	a, _ := store.GetLoginAttempts(context.Background(), "account:user:"+user.ID)
+	assert.Nil(t, a)
+	a, _ = store.GetLoginAttempts(context.Background(), "ip:10.0.0.1")
+	assert.Equal(t, 4, a.Failures)
+
+	assert.Equal(t, []AuditEventType{
+		AuditLoginFailed, AuditLoginFailed, AuditLoginFailed,
+		AuditLoginThrottled,
+		AuditLoginFailed, AuditAccountLocked,
+		AuditLoginThrottled,
+		AuditLoginSucceeded,
+	}, auditor.types())
+	assert.Equal(t, "10.0.0.1", auditor.events[0].ClientIP)
+	assert.Equal(t, user.ID, auditor.events[len(auditor.events)-1].UserID)
+}
+
+func TestValidateUserCredentials_accountThrottledAcrossIdentifiers_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+	mpt := &mockPasswordTool{}
+	store := newFakeLoginAttemptStore()
+
+	user := NewUser("9abc46be-3bcd-42b1-aeb2-ac6ff557a580", "JohnDoe", "test@example.com", "hash")
+	mr.On("GetUserByUserName", mock.Anything, "JohnDoe").Return(user, nil)
+	mr.On("GetUserByEmail", mock.Anything, "test@example.com").Return(user, nil)
+	mpt.On("Check", "hash", "wrong").Return(ErrPasswordMismatch)
+
+	policy := ThrottlePolicy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, LockoutThreshold: 100, LockoutDuration: time.Hour, Window: time.Hour}
+	service := NewService(nullLogger(), mr, nil, mpt, WithLoginThrottle(store, policy, DefaultIPThrottlePolicy))
+
+	// switching between user name and email counts against the same account
+	for _, creds := range [][2]string{{"JohnDoe", ""}, {"", "test@example.com"}, {"JohnDoe", ""}} {
+		_, err := service.ValidateUserCredentials(context.Background(), creds[0], creds[1], "wrong", "10.0.0.1")
+		assert.Equal(t, Unauthorized, err.Code)
+	}
+
+	_, err := service.ValidateUserCredentials(context.Background(), "", "test@example.com", "wrong", "10.0.0.2")
+	assert.Equal(t, TooManyRequests, err.Code)
+}
+
+func TestValidateUserCredentials_ipThrottledAcrossAccounts_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+	store := newFakeLoginAttemptStore()
+
+	mpt := &mockPasswordTool{}
+
+	mr.On("GetUserByUserName", mock.Anything, mock.Anything).Return(nil, ErrNotFound)
+	mpt.On("Check", testDummyHash, "guess").Return(ErrPasswordMismatch)
+
+	ipPolicy := ThrottlePolicy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, LockoutThreshold: 100, LockoutDuration: time.Hour, Window: time.Hour}
+	service := NewService(nullLogger(), mr, nil, mpt, WithLoginThrottle(store, DefaultAccountThrottlePolicy, ipPolicy))
+
+	for i := 0; i < 3; i++ {
+		_, err := service.ValidateUserCredentials(context.Background(), fmt.Sprintf("user%d", i), "", "guess", "10.0.0.1")
+		assert.Equal(t, Unauthorized, err.Code, "unknown users are indistinguishable from wrong passwords")
+	}
+
+	_, err := service.ValidateUserCredentials(context.Background(), "user3", "", "guess", "10.0.0.1")
+	assert.Equal(t, TooManyRequests, err.Code)
+
+	// another client is unaffected
+	_, err = service.ValidateUserCredentials(context.Background(), "user3", "", "guess", "10.0.0.2")
+	assert.Equal(t, Unauthorized, err.Code)
+}
+
+func TestValidateUserCredentials_unknownUser_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+	mpt := &mockPasswordTool{}
+
+	user := NewUser("9abc46be-3bcd-42b1-aeb2-ac6ff557a580", "JohnDoe", "test@example.com", "hash")
+	mr.On("GetUserByUserName", mock.Anything, "JohnDoe").Return(user, nil)
+	mr.On("GetUserByUserName", mock.Anything, "nobody").Return(nil, ErrNotFound)
+	mpt.On("Check", "hash", "guess").Return(ErrPasswordMismatch).Once()
+	mpt.On("Check", testDummyHash, "guess").Return(ErrPasswordMismatch).Twice()
+
+	service := NewService(nullLogger(), mr, nil, mpt)
+	// the dummy hash is made when the service is built rather than on the first unknown user
+	assert.Equal(t, testDummyHash, service.dummyHash)
+
+	_, wrongPasswordErr := service.ValidateUserCredentials(context.Background(), "JohnDoe", "", "guess", "10.0.0.1")
+	for i := 0; i < 2; i++ {
+		_, err := service.ValidateUserCredentials(context.Background(), "nobody", "", "guess", "10.0.0.1")
+		assert.Equal(t, wrongPasswordErr, err, "unknown users are indistinguishable from wrong passwords")
+	}
+
+	mr.AssertExpectations(t)
+	mpt.AssertExpectations(t)
+}
@@ -0,0 +1,259 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	recoveryCodeCount = 10
+	// recovery codes are 10 chars from a 32 char alphabet giving 50 bits, presented as xxxxx-xxxxx.
+	// The alphabet omits easily confused letters and, as 256 is a multiple of 32, maps random bytes onto it without bias
+	recoveryCodeLen      = 10
+	recoveryCodeAlphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+	totpCodeLen          = 6
+
+	mfaAttemptKeyPrefix = "mfa:"
+)
+
+// UserMFA is a user's TOTP second factor. It is not Enabled until the user has proven they can generate codes
+type UserMFA struct {
+	UserID  string
+	Secret  string
+	Enabled bool
+	// LastUsedStep is the TOTP time step of the last accepted code, used to stop a code being replayed
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+// TOTPEnrollment is returned to the user so they can add the secret to their authenticator app
+type TOTPEnrollment struct {
+	Secret    string
+	URI       string
+	QRCodePNG []byte
+}
+
+type TOTPTool interface {
+	NewSecret() (string, error)
+	URI(accountName, secret string) string
+	QRCodePNG(accountName, secret string) ([]byte, error)
+	Validate(secret, code string, at time.Time) (int64, bool)
+}
+
+// WithTOTP enables TOTP two-factor authentication
+func WithTOTP(tool TOTPTool) ServiceOption {
+	return func(s *Service) {
+		s.totpTool = tool
+	}
+}
+
+// EnrollTOTP generates a new TOTP secret for the user. The secret is not used for logins until confirmed with ConfirmTOTP
+func (s *Service) EnrollTOTP(ctx context.Context, userID string) (_ *TOTPEnrollment, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "EnrollTOTP")
+	defer func() { endSpan(span, dErr) }()
+
+	if s.totpTool == nil {
+		return nil, newNotImplementedError("two-factor authentication is not enabled", nil)
+	}
+
+	user, dErr := s.GetUser(ctx, userID)
+	if dErr != nil {
+		return nil, dErr
+	}
+
+	existing, err := s.repo.GetUserMFA(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, newSystemError("failed to retrieve two-factor settings", err)
+	} else if err == nil && existing.Enabled {
+		return nil, newResourceConflictError("two-factor authentication is already enabled", nil)
+	}
+
+	secret, err := s.totpTool.NewSecret()
+	if err != nil {
+		return nil, newSystemError("failed to generate secret", err)
+	}
+
+	qrPNG, err := s.totpTool.QRCodePNG(user.Attributes.Email, secret)
+	if err != nil {
+		return nil, newSystemError("failed to generate QR code", err)
+	}
+
+	if err := s.repo.SaveUserMFA(ctx, UserMFA{UserID: userID, Secret: secret}); err != nil {
+		return nil, newSystemError("failed to store two-factor settings", err)
+	}
+
+	return &TOTPEnrollment{
+		Secret:    secret,
+		URI:       s.totpTool.URI(user.Attributes.Email, secret),
+		QRCodePNG: qrPNG,
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication once the user has entered a valid code from their authenticator app.
+// It returns a set of single use recovery codes which are only ever shown this once
+func (s *Service) ConfirmTOTP(ctx context.Context, userID, code string) (_ []string, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "ConfirmTOTP")
+	defer func() { endSpan(span, dErr) }()
+
+	if s.totpTool == nil {
+		return nil, newNotImplementedError("two-factor authentication is not enabled", nil)
+	}
+
+	mfa, err := s.repo.GetUserMFA(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, newResourceNotFoundError("no two-factor enrolment in progress", nil)
+	} else if err != nil {
+		return nil, newSystemError("failed to retrieve two-factor settings", err)
+	} else if mfa.Enabled {
+		return nil, newResourceConflictError("two-factor authentication is already enabled", nil)
+	}
+
+	step, ok := s.totpTool.Validate(mfa.Secret, code, s.now())
+	if !ok {
+		return nil, newInvalidInputError("code is invalid", nil)
+	}
+
+	recoveryCodes, codeHashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, newSystemError("failed to generate recovery codes", err)
+	}
+
+	// the recovery codes are stored along with enabling two-factor authentication or not at all
+	mfa.Enabled = true
+	mfa.LastUsedStep = step
+	if dErr := s.inTx(ctx, func(tx Repo) *Error {
+		if err := tx.ReplaceRecoveryCodes(ctx, userID, codeHashes); err != nil {
+			return newSystemError("failed to store recovery codes", err)
+		}
+
+		if err := tx.SaveUserMFA(ctx, *mfa); err != nil {
+			return newSystemError("failed to store two-factor settings", err)
+		}
+
+		return nil
+	}); dErr != nil {
+		return nil, dErr
+	}
+
+	return recoveryCodes, nil
+}
+
+// IsMFAEnabled reports whether logins for the user require a second factor
+func (s *Service) IsMFAEnabled(ctx context.Context, userID string) (_ bool, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "IsMFAEnabled")
+	defer func() { endSpan(span, dErr) }()
+
+	if s.totpTool == nil {
+		return false, nil
+	}
+
+	mfa, err := s.repo.GetUserMFA(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, newSystemError("failed to retrieve two-factor settings", err)
+	}
+
+	return mfa.Enabled, nil
+}
+
+// VerifySecondFactor checks a TOTP code or, failing that, a single use recovery code.
+// Failures are throttled like password guesses since a 6 digit code is otherwise easily brute forced
+func (s *Service) VerifySecondFactor(ctx context.Context, userID, code, clientIP string) (dErr *Error) {
+	ctx, span := s.startSpan(ctx, "VerifySecondFactor")
+	defer func() { endSpan(span, dErr) }()
+
+	if s.totpTool == nil {
+		return newNotImplementedError("two-factor authentication is not enabled", nil)
+	}
+
+	attemptKey := mfaAttemptKeyPrefix + userID
+	ipKey := ipAttemptKey(clientIP)
+	if dErr := s.checkLoginAllowed(ctx, attemptKey, ipKey); dErr != nil {
+		return dErr
+	}
+
+	mfa, err := s.repo.GetUserMFA(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return newSystemError("failed to retrieve two-factor settings", err)
+	} else if err != nil || !mfa.Enabled {
+		return newInvalidInputError("two-factor authentication is not enabled for user", nil)
+	}
+
+	code = strings.TrimSpace(code)
+	if isTOTPCode(code) {
+		step, ok := s.totpTool.Validate(mfa.Secret, code, s.now())
+		if ok {
+			// only the first use of a code within its time step is accepted
+			updated, err := s.repo.UpdateMFALastUsedStep(ctx, userID, step)
+			if err != nil {
+				return newSystemError("failed to store two-factor settings", err)
+			} else if updated {
+				s.recordSuccessfulLogin(ctx, attemptKey, ipKey, userID)
+				return nil
+			}
+		}
+	} else {
+		used, err := s.repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+		if err != nil {
+			return newSystemError("failed to check recovery code", err)
+		} else if used {
+			s.recordSuccessfulLogin(ctx, attemptKey, ipKey, userID)
+			return nil
+		}
+	}
+
+	s.recordFailedLogin(ctx, attemptKey, ipKey, "invalid second factor")
+
+	return newUnauthorizedError("code is invalid", nil)
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != totpCodeLen {
+		return false
+	}
+
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRecoveryCodes returns the codes to show the user along with the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, recoveryCodeLen)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		for j := range b {
+			b[j] = recoveryCodeAlphabet[int(b[j])%len(recoveryCodeAlphabet)]
+		}
+
+		codes[i] = string(b[:recoveryCodeLen/2]) + "-" + string(b[recoveryCodeLen/2:])
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode uses a fast unsalted hash, which is fine since recovery codes are random rather than user chosen.
+// Hyphens and case are ignored so codes can be typed loosely
+func hashRecoveryCode(code string) string {
+	normalised := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalised))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,200 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEnrollTOTP_successPath(t *testing.T) {
+	mr := &mockRepo{}
+	mIDt := &mockIDTool{}
+	mt := &mockTOTPTool{}
+
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+	user := NewUser(uID, "JohnDoe", "test@example.com", "hash")
+
+	mIDt.On("IsValid", uID).Return(true).Once()
+	mr.On("GetUser", mock.Anything, uID).Return(user, nil).Once()
+	mr.On("GetUserMFA", mock.Anything, uID).Return(nil, ErrNotFound).Once()
+	mr.On("SaveUserMFA", mock.Anything, UserMFA{UserID: uID, Secret: "SECRET"}).Return(nil).Once()
+	mt.On("NewSecret").Return("SECRET", nil).Once()
+	mt.On("QRCodePNG", "test@example.com", "SECRET").Return([]byte("png"), nil).Once()
+	mt.On("URI", "test@example.com", "SECRET").Return("otpauth://totp/x", nil).Once()
+
+	service := NewService(nullLogger(), mr, mIDt, nil, WithTOTP(mt))
+	enrollment, err := service.EnrollTOTP(context.Background(), uID)
+	assert.Nil(t, err)
+	assert.Equal(t, &TOTPEnrollment{Secret: "SECRET", URI: "otpauth://totp/x", QRCodePNG: []byte("png")}, enrollment)
+
+	mr.AssertExpectations(t)
+	mIDt.AssertExpectations(t)
+	mt.AssertExpectations(t)
+}
+
+func TestEnrollTOTP_alreadyEnabled_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+	mIDt := &mockIDTool{}
+
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+
+	mIDt.On("IsValid", uID).Return(true).Once()
+	mr.On("GetUser", mock.Anything, uID).Return(NewUser(uID, "JohnDoe", "test@example.com", "hash"), nil).Once()
+	mr.On("GetUserMFA", mock.Anything, uID).Return(&UserMFA{UserID: uID, Secret: "SECRET", Enabled: true}, nil).Once()
+
+	service := NewService(nullLogger(), mr, mIDt, nil, WithTOTP(&mockTOTPTool{}))
+	enrollment, err := service.EnrollTOTP(context.Background(), uID)
+	assert.Nil(t, enrollment)
+	assert.Equal(t, newResourceConflictError("two-factor authentication is already enabled", nil), err)
+
+	mr.AssertExpectations(t)
+}
+
+func TestEnrollTOTP_totpNotConfigured_failurePath(t *testing.T) {
+	service := NewService(nullLogger(), nil, nil, nil)
+	enrollment, err := service.EnrollTOTP(context.Background(), "9abc46be-3bcd-42b1-aeb2-ac6ff557a580")
+	assert.Nil(t, enrollment)
+	assert.Equal(t, NotImplemented, err.Code)
+}
+
+func TestConfirmTOTP_successPath(t *testing.T) {
+	mr := &mockRepo{}
+	mt := &mockTOTPTool{}
+
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+	now := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	mr.On("GetUserMFA", mock.Anything, uID).Return(&UserMFA{UserID: uID, Secret: "SECRET"}, nil).Once()
+	mr.On("ReplaceRecoveryCodes", mock.Anything, uID, mock.AnythingOfType("[]string")).Return(nil).Once()
+	mr.On("SaveUserMFA", mock.Anything, UserMFA{UserID: uID, Secret: "SECRET", Enabled: true, LastUsedStep: 42}).Return(nil).Once()
+	mt.On("Validate", "SECRET", "123456", now).Return(int64(42), true).Once()
+
+	service := NewService(nullLogger(), mr, nil, nil, WithTOTP(mt))
+	service.now = func() time.Time { return now }
+
+	codes, err := service.ConfirmTOTP(context.Background(), uID, "123456")
+	assert.Nil(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+	for _, c := range codes {
+		assert.Regexp(t, `^[0-9a-z]{5}-[0-9a-z]{5}$`, c)
+	}
+
+	// only hashes of the codes are stored
+	storedHashes := mr.Calls[1].Arguments.Get(2).([]string)
+	assert.Equal(t, hashRecoveryCode(codes[0]), storedHashes[0])
+	assert.NotContains(t, storedHashes, codes[0])
+
+	mr.AssertExpectations(t)
+	mt.AssertExpectations(t)
+}
+
+func TestConfirmTOTP_invalidCode_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+	mt := &mockTOTPTool{}
+
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+
+	mr.On("GetUserMFA", mock.Anything, uID).Return(&UserMFA{UserID: uID, Secret: "SECRET"}, nil).Once()
+	mt.On("Validate", "SECRET", "000000", mock.Anything).Return(int64(0), false).Once()
+
+	service := NewService(nullLogger(), mr, nil, nil, WithTOTP(mt))
+	codes, err := service.ConfirmTOTP(context.Background(), uID, "000000")
+	assert.Nil(t, codes)
+	assert.Equal(t, newInvalidInputError("code is invalid", nil), err)
+
+	mr.AssertExpectations(t)
+	mt.AssertExpectations(t)
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+	enabled := &UserMFA{UserID: uID, Secret: "SECRET", Enabled: true, LastUsedStep: 41}
+
+	testCases := []struct {
+		name        string
+		code        string
+		setup       func(mr *mockRepo, mt *mockTOTPTool)
+		expectedErr *Error
+	}{
+		{
+			name: "valid TOTP code",
+			code: "123456",
+			setup: func(mr *mockRepo, mt *mockTOTPTool) {
+				mt.On("Validate", "SECRET", "123456", mock.Anything).Return(int64(42), true).Once()
+				mr.On("UpdateMFALastUsedStep", mock.Anything, uID, int64(42)).Return(true, nil).Once()
+			},
+		},
+		{
+			name: "replayed TOTP code",
+			code: "123456",
+			setup: func(mr *mockRepo, mt *mockTOTPTool) {
+				mt.On("Validate", "SECRET", "123456", mock.Anything).Return(int64(41), true).Once()
+				mr.On("UpdateMFALastUsedStep", mock.Anything, uID, int64(41)).Return(false, nil).Once()
+			},
+			expectedErr: newUnauthorizedError("code is invalid", nil),
+		},
+		{
+			name: "invalid TOTP code",
+			code: "654321",
+			setup: func(mr *mockRepo, mt *mockTOTPTool) {
+				mt.On("Validate", "SECRET", "654321", mock.Anything).Return(int64(0), false).Once()
+			},
+			expectedErr: newUnauthorizedError("code is invalid", nil),
+		},
+		{
+			name: "valid recovery code typed loosely",
+			code: "ABCDE FGHJK",
+			setup: func(mr *mockRepo, mt *mockTOTPTool) {
+				mr.On("UseRecoveryCode", mock.Anything, uID, hashRecoveryCode("abcde-fghjk")).Return(true, nil).Once()
+			},
+		},
+		{
+			name: "used recovery code",
+			code: "abcde-fghjk",
+			setup: func(mr *mockRepo, mt *mockTOTPTool) {
+				mr.On("UseRecoveryCode", mock.Anything, uID, hashRecoveryCode("abcde-fghjk")).Return(false, nil).Once()
+			},
+			expectedErr: newUnauthorizedError("code is invalid", nil),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr := &mockRepo{}
+			mt := &mockTOTPTool{}
+			mr.On("GetUserMFA", mock.Anything, uID).Return(enabled, nil).Once()
+			tc.setup(mr, mt)
+
+			service := NewService(nullLogger(), mr, nil, nil, WithTOTP(mt))
+			err := service.VerifySecondFactor(context.Background(), uID, tc.code, "10.0.0.1")
+			assert.Equal(t, tc.expectedErr, err)
+
+			mr.AssertExpectations(t)
+			mt.AssertExpectations(t)
+		})
+	}
+}
+
+func TestVerifySecondFactor_throttled_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+	mt := &mockTOTPTool{}
+	store := newFakeLoginAttemptStore()
+
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+	mr.On("GetUserMFA", mock.Anything, uID).Return(&UserMFA{UserID: uID, Secret: "SECRET", Enabled: true}, nil)
+	mt.On("Validate", "SECRET", mock.Anything, mock.Anything).Return(int64(0), false)
+
+	policy := ThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, LockoutThreshold: 10, LockoutDuration: time.Hour, Window: time.Hour}
+	service := NewService(nullLogger(), mr, nil, nil, WithTOTP(mt), WithLoginThrottle(store, policy, DefaultIPThrottlePolicy))
+
+	for i := 0; i < 4; i++ {
+		err := service.VerifySecondFactor(context.Background(), uID, "000000", "10.0.0.1")
+		assert.Equal(t, Unauthorized, err.Code)
+	}
+
+	err := service.VerifySecondFactor(context.Background(), uID, "000000", "10.0.0.1")
+	assert.Equal(t, TooManyRequests, err.Code)
+}
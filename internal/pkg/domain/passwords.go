@@ -1,5 +1,10 @@
 package domain
 
+import "errors"
+
+// ErrPasswordMismatch is returned by PasswordChecker.Check when the password does not match the hash
+var ErrPasswordMismatch = errors.New("mismatched hash and password")
+
 type PasswordTool interface {
 	PasswordGenerator
 	PasswordValidator
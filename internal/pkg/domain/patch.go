@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// PatchFormat is the format of a patch document, named by its media type
+type PatchFormat string
+
+const (
+	// JSONPatch is a list of operations, see RFC 6902 https://datatracker.ietf.org/doc/html/rfc6902/
+	JSONPatch PatchFormat = "application/json-patch+json"
+	// MergePatch is a partial document whose fields replace the resource's, a null removing the field,
+	// see RFC 7396 https://datatracker.ietf.org/doc/html/rfc7396/
+	MergePatch PatchFormat = "application/merge-patch+json"
+)
+
+// PatchFormats are the formats every PATCH endpoint accepts
+var PatchFormats = []PatchFormat{JSONPatch, MergePatch}
+
+// Patch is a patch document to be applied to a resource's JSON representation
+type Patch struct {
+	Format PatchFormat
+	Doc    []byte
+}
+
+// patchApplier applies a decoded patch to a JSON document, returning the patched document
+type patchApplier func(doc []byte) ([]byte, error)
+
+// decode checks the patch is well formed so that it can be rejected before the resource is retrieved
+func (p Patch) decode() (patchApplier, *Error) {
+	switch p.Format {
+	case JSONPatch:
+		patch, err := jsonpatch.DecodePatch(p.Doc)
+		if err != nil {
+			return nil, newInvalidInputError("patch could not be decoded", err)
+		}
+
+		return patch.Apply, nil
+	case MergePatch:
+		// any other JSON value would replace the whole resource
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(p.Doc, &fields); err != nil {
+			return nil, newInvalidInputError("patch could not be decoded", err)
+		} else if fields == nil {
+			return nil, newInvalidInputError("patch could not be decoded", fmt.Errorf("merge patch must be an object"))
+		}
+
+		return func(doc []byte) ([]byte, error) { return jsonpatch.MergePatch(doc, p.Doc) }, nil
+	default:
+		return nil, newInvalidInputError(fmt.Sprintf("unsupported patch format %q", p.Format), nil)
+	}
+}
+
+// applyPatch applies the patch to the JSON representation of current, unmarshalling the result into patched,
+// which should be zero so that fields removed by the patch are left unset
+func applyPatch(apply patchApplier, current, patched interface{}) *Error {
+	currentJSON, err := json.Marshal(current)
+	if err != nil {
+		return newSystemError("failed to marshal current resource", err)
+	}
+
+	patchedJSON, err := apply(currentJSON)
+	if err != nil {
+		return newInvalidInputError("patch invalid", err)
+	}
+
+	// check if the patch actually changed anything
+	if jsonpatch.Equal(currentJSON, patchedJSON) {
+		return newInvalidInputError("patch does not effect any change", nil)
+	}
+
+	if err := json.Unmarshal(patchedJSON, patched); err != nil {
+		return newInvalidInputError("patch would leave the resource malformed", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package domain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatch_decode_failurePath(t *testing.T) {
+	testCases := []struct {
+		name        string
+		patch       Patch
+		expectedErr string
+	}{
+		{
+			name:        "json patch is not a list of operations",
+			patch:       Patch{Format: JSONPatch, Doc: []byte(`{"user_name": "foo"}`)},
+			expectedErr: "patch could not be decoded",
+		},
+		{
+			name:        "merge patch is malformed",
+			patch:       Patch{Format: MergePatch, Doc: []byte(`{"user_name": `)},
+			expectedErr: "patch could not be decoded",
+		},
+		{
+			name:        "merge patch is not an object",
+			patch:       Patch{Format: MergePatch, Doc: []byte(`null`)},
+			expectedErr: "patch could not be decoded",
+		},
+		{
+			name:        "unsupported format",
+			patch:       Patch{Format: "application/xml", Doc: []byte(`<user/>`)},
+			expectedErr: `unsupported patch format "application/xml"`,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			_, dErr := tc.patch.decode()
+			if assert.NotNil(t, dErr) {
+				assert.Equal(t, InvalidInput, dErr.Code)
+				assert.Equal(t, tc.expectedErr, dErr.Msg)
+			}
+		})
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	current := UserAttributes{UserName: "JohnDoe", Email: "test@example.com"}
+
+	testCases := []struct {
+		name        string
+		patch       Patch
+		expected    UserAttributes
+		expectedErr string
+	}{
+		{
+			name:     "json patch",
+			patch:    Patch{Format: JSONPatch, Doc: []byte(`[{"op": "replace", "path": "/user_name", "value": "foo"}]`)},
+			expected: UserAttributes{UserName: "foo", Email: "test@example.com"},
+		},
+		{
+			name:     "merge patch",
+			patch:    Patch{Format: MergePatch, Doc: []byte(`{"user_name": "foo"}`)},
+			expected: UserAttributes{UserName: "foo", Email: "test@example.com"},
+		},
+		{
+			name:     "merge patch removing a field",
+			patch:    Patch{Format: MergePatch, Doc: []byte(`{"email": null}`)},
+			expected: UserAttributes{UserName: "JohnDoe"},
+		},
+		{
+			name:        "json patch operation fails",
+			patch:       Patch{Format: JSONPatch, Doc: []byte(`[{"op": "remove", "path": "/nope"}]`)},
+			expectedErr: "patch invalid",
+		},
+		{
+			name:        "no change",
+			patch:       Patch{Format: MergePatch, Doc: []byte(`{"user_name": "JohnDoe"}`)},
+			expectedErr: "patch does not effect any change",
+		},
+		{
+			name:        "wrong type",
+			patch:       Patch{Format: MergePatch, Doc: []byte(`{"user_name": 5}`)},
+			expectedErr: "patch would leave the resource malformed",
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			apply, dErr := tc.patch.decode()
+			if !assert.Nil(t, dErr) {
+				return
+			}
+
+			var patched UserAttributes
+			dErr = applyPatch(apply, current, &patched)
+			if tc.expectedErr != "" {
+				if assert.NotNil(t, dErr) {
+					assert.Equal(t, InvalidInput, dErr.Code)
+					assert.Equal(t, tc.expectedErr, dErr.Msg)
+				}
+
+				return
+			}
+
+			assert.Nil(t, dErr)
+			assert.Equal(t, tc.expected, patched)
+		})
+	}
+}
@@ -1,11 +1,51 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
+var (
+	// ErrNotFound is returned by getters when there is nothing to get, and by writes referring to something that doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned by writes that would break a unique constraint, e.g. a second user with the same email
+	ErrConflict = errors.New("conflict")
+	// ErrVersionMismatch is returned by updates when the stored version isn't the one the update was based on
+	ErrVersionMismatch = errors.New("version mismatch")
+)
+
+// Repo implementations wrap ErrNotFound and ErrConflict so check for them with errors.Is
 type Repo interface {
+	// WithTx runs fn in a transaction, committing if it returns nil and rolling back otherwise.
+	// Everything in the transaction must go through the Repo passed to fn, calling WithTx on which joins the transaction
+	WithTx(ctx context.Context, fn func(tx Repo) error) error
+
 	CreateUser(ctx context.Context, user User) error
 	GetUser(ctx context.Context, userID string) (*User, error)
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
 	GetUserByUserName(ctx context.Context, userName string) (*User, error)
+	// UpdateUser only updates the user if their stored version is user.Version, incrementing it
 	UpdateUser(ctx context.Context, user User) error
+
+	GetUserIdentity(ctx context.Context, provider, subject string) (*UserIdentity, error)
+	CreateUserIdentity(ctx context.Context, identity UserIdentity) error
+
+	GetUserMFA(ctx context.Context, userID string) (*UserMFA, error)
+	// SaveUserMFA creates or replaces the user's two-factor settings
+	SaveUserMFA(ctx context.Context, mfa UserMFA) error
+	// UpdateMFALastUsedStep sets the last used TOTP step only if it is greater than the stored one, reporting whether it did
+	UpdateMFALastUsedStep(ctx context.Context, userID string, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error
+	// UseRecoveryCode marks a matching unused recovery code as used, reporting whether there was one
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error)
+
+	CreateAPIKey(ctx context.Context, key APIKey) error
+	// GetAPIKeyByHash returns the unrevoked key with the given hash
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	// ListAPIKeys returns the user's unrevoked keys
+	ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+	// RevokeAPIKey revokes the user's key, reporting whether there was an unrevoked key to revoke
+	RevokeAPIKey(ctx context.Context, userID, keyID string, at time.Time) (bool, error)
+	TouchAPIKey(ctx context.Context, keyID string, at time.Time) error
 }
@@ -2,15 +2,19 @@ package domain
 
 import (
 	"context"
-	"encoding/json"
-	"strings"
+	"errors"
+	"time"
 
-	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	componentService = "Service"
+
+	// dummyPassword is hashed for checkDummyPassword
+	dummyPassword = "dummy password"
 )
 
 type Service struct {
@@ -19,18 +23,51 @@ type Service struct {
 
 	passWordTool PasswordTool
 	idTool       IDTool
+
+	loginThrottle *loginThrottle
+	auditor       Auditor
+	totpTool      TOTPTool
+	tracer        trace.Tracer
+	now           func() time.Time
+
+	// dummyHash is checked against when there is no user to check a password against, see checkDummyPassword
+	dummyHash string
 }
 
-func NewService(logger *logrus.Logger, repo Repo, idTool IDTool, passwordTool PasswordTool) *Service {
-	return &Service{
+// ServiceOption configures optional Service functionality
+type ServiceOption func(*Service)
+
+func NewService(logger *logrus.Logger, repo Repo, idTool IDTool, passwordTool PasswordTool, opts ...ServiceOption) *Service {
+	s := &Service{
 		logger:       logger.WithField("component", componentService),
 		repo:         repo,
 		idTool:       idTool,
 		passWordTool: passwordTool,
+		tracer:       otel.Tracer(tracerName),
+		now:          time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// made up front so that the first login by an unknown user takes no longer than any other
+	if passwordTool != nil {
+		hash, err := passwordTool.New(dummyPassword)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to generate dummy password hash")
+		}
+
+		s.dummyHash = hash
 	}
+
+	return s
 }
 
-func (s *Service) CreateUser(ctx context.Context, userName, email, password string) (*User, *Error) {
+func (s *Service) CreateUser(ctx context.Context, userName, email, password string) (_ *User, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "CreateUser")
+	defer func() { endSpan(span, dErr) }()
+
 	if userName == "" || email == "" || password == "" {
 		return nil, newInvalidInputError("each of userName, email, password must not be empty", nil)
 	}
@@ -52,147 +89,374 @@ func (s *Service) CreateUser(ctx context.Context, userName, email, password stri
 		return nil, newInvalidInputError("user is invalid", err)
 	}
 
-	if err := s.repo.CreateUser(ctx, *user); err != nil {
+	if err := s.repo.CreateUser(ctx, *user); errors.Is(err, ErrConflict) {
+		return nil, newResourceConflictError("a user with this user name or email already exists", err)
+	} else if err != nil {
 		return nil, newSystemError("failed to store new user", err)
 	}
 
 	return user, nil
 }
 
-func (s *Service) GetUser(ctx context.Context, userID string) (*User, *Error) {
+func (s *Service) GetUser(ctx context.Context, userID string) (_ *User, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "GetUser")
+	defer func() { endSpan(span, dErr) }()
+
 	if !s.idTool.IsValid(userID) {
 		return nil, newInvalidInputError("format of userID is invalid", nil)
 	}
 
 	user, err := s.repo.GetUser(ctx, userID)
-	if err != nil {
-		return nil, newSystemError("failed to retrieve user", err)
-	} else if user == nil {
+	if errors.Is(err, ErrNotFound) {
 		return nil, newResourceNotFoundError("user does not exist", nil)
+	} else if err != nil {
+		return nil, newSystemError("failed to retrieve user", err)
 	}
 
 	return user, nil
 }
 
-// PatchUser updates the user attributes with the given patch
-func (s *Service) PatchUser(ctx context.Context, userID string, patchJSON []byte) *Error {
-	if !s.idTool.IsValid(userID) {
-		return newInvalidInputError("format of userID is invalid", nil)
-	}
-
-	patch, err := jsonpatch.DecodePatch(patchJSON)
-	if err != nil {
-		return newInvalidInputError("patch could not be decoded", err)
-	}
+// PatchUser updates the user attributes with the given patch, returning the updated user.
+// versions are those the patch may be applied to, typically from an If-Match header, and nil allows any version.
+// The user is only updated if it is still at the version that was patched, so concurrent patches can't overwrite each other
+func (s *Service) PatchUser(ctx context.Context, userID string, versions []int64, patch Patch) (_ *User, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "PatchUser")
+	defer func() { endSpan(span, dErr) }()
 
-	user, err := s.repo.GetUser(ctx, userID)
-	if err != nil {
-		return newSystemError("failed to retrieve user", err)
-	} else if user == nil {
-		return newResourceNotFoundError("user does not exist", nil)
-	}
-
-	currentUserAttrJSON, err := json.Marshal(user.Attributes)
-	if err != nil {
-		return newSystemError("failed to marshal existing user", err)
+	if !s.idTool.IsValid(userID) {
+		return nil, newInvalidInputError("format of userID is invalid", nil)
 	}
 
-	patchedUserAttr, dErr := s.createPatchedUser(currentUserAttrJSON, patch)
+	apply, dErr := patch.decode()
 	if dErr != nil {
-		return dErr.WrapMessage("failed to patch user")
+		return nil, dErr
 	}
 
-	user.Attributes = *patchedUserAttr
-
-	// need to validate user post-patch to ensure we're not left in an invalid state
-	if err := user.Validate(s.idTool, s.passWordTool); err != nil {
-		return newInvalidInputError("patch would leave user in invalid state", err)
-	}
-
-	if err := s.repo.UpdateUser(ctx, *user); err != nil {
-		return newSystemError("failed to update user with patched attributes", err)
+	var user *User
+	if dErr := s.inTx(ctx, func(tx Repo) *Error {
+		var err error
+		user, err = tx.GetUser(ctx, userID)
+		if errors.Is(err, ErrNotFound) {
+			return newResourceNotFoundError("user does not exist", nil)
+		} else if err != nil {
+			return newSystemError("failed to retrieve user", err)
+		} else if !matchesVersion(user.Version, versions) {
+			return newPreconditionFailedError("user has been modified since the given version", nil)
+		}
+
+		var patchedUserAttr UserAttributes
+		if dErr := applyPatch(apply, user.Attributes, &patchedUserAttr); dErr != nil {
+			return dErr.WrapMessage("failed to patch user")
+		}
+
+		user.Attributes = patchedUserAttr
+
+		// need to validate user post-patch to ensure we're not left in an invalid state
+		if err := user.Validate(s.idTool, s.passWordTool); err != nil {
+			return newInvalidInputError("patch would leave user in invalid state", err)
+		}
+
+		if err := tx.UpdateUser(ctx, *user); errors.Is(err, ErrConflict) {
+			return newResourceConflictError("a user with this user name or email already exists", err)
+		} else if errors.Is(err, ErrVersionMismatch) {
+			// another update got in between retrieving the user and storing the patched one
+			return newPreconditionFailedError("user has been modified since the given version", err)
+		} else if errors.Is(err, ErrNotFound) {
+			return newResourceNotFoundError("user does not exist", nil)
+		} else if err != nil {
+			return newSystemError("failed to update user with patched attributes", err)
+		}
+
+		// the repo sets the version and modification time, so the user is read back as stored for the response to match a GET
+		if user, err = tx.GetUser(ctx, userID); err != nil {
+			return newSystemError("failed to retrieve updated user", err)
+		}
+
+		return nil
+	}); dErr != nil {
+		return nil, dErr
 	}
 
-	return nil
+	return user, nil
 }
 
-// createPatchedUser creates a new user from the current user and the patch.
-// https://jsonpatch.com/
-func (s *Service) createPatchedUser(userAttr []byte, patch jsonpatch.Patch) (*UserAttributes, *Error) {
-	patchedUserAttr, err := patch.Apply(userAttr)
-	if err != nil {
-		return nil, newInvalidInputError("patch invalid", err)
-	}
-
-	// check if the patch actually changed anything
-	if jsonpatch.Equal(userAttr, patchedUserAttr) {
-		return nil, newInvalidInputError("patch does not effect any change", nil)
+// matchesVersion reports whether version is one of versions, any version matching when there are none
+func matchesVersion(version int64, versions []int64) bool {
+	if versions == nil {
+		return true
 	}
 
-	var patchedUser UserAttributes
-	if err := json.Unmarshal(patchedUserAttr, &patchedUser); err != nil {
-		return nil, newSystemError("could not unmarshal patched user", err)
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
 	}
 
-	return &patchedUser, nil
+	return false
 }
 
-// ValidateUserCredentials checks if the given credentials are valid. If they are, we return the User, if not we return an error
-func (s *Service) ValidateUserCredentials(ctx context.Context, userName, email, password string) (*User, *Error) {
+// ValidateUserCredentials checks if the given credentials are valid. If they are, we return the User, if not we return an error.
+// Repeated failures for the same account or from the same client IP are met with exponential back-off and eventually a temporary lockout
+func (s *Service) ValidateUserCredentials(ctx context.Context, userName, email, password, clientIP string) (_ *User, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "ValidateUserCredentials")
+	defer func() { endSpan(span, dErr) }()
+
 	if password == "" {
 		return nil, newInvalidInputError("password must not be empty", nil)
+	} else if userName == "" && email == "" {
+		return nil, newInvalidInputError("must provide either username or email", nil)
 	}
 
 	var user *User
 	var err error
 	if userName != "" {
 		user, err = s.repo.GetUserByUserName(ctx, userName)
-	} else if email != "" {
-		user, err = s.repo.GetUserByEmail(ctx, email)
 	} else {
-		return nil, newInvalidInputError("must provide either username or email", nil)
+		user, err = s.repo.GetUserByEmail(ctx, email)
 	}
 
-	if user == nil {
-		return nil, newResourceNotFoundError("user does not exist", nil)
+	if errors.Is(err, ErrNotFound) {
+		user = nil
 	} else if err != nil {
 		return nil, newSystemError("failed to retrieve user", err)
 	}
 
-	if err := s.passWordTool.Check(user.Password, password); err != nil && strings.Contains(err.Error(), "mismatched hash and password") {
+	accountKey := accountAttemptKey(user, userName, email)
+	ipKey := ipAttemptKey(clientIP)
+	if dErr := s.checkLoginAllowed(ctx, accountKey, ipKey); dErr != nil {
+		return nil, dErr
+	}
+
+	if user == nil {
+		// guesses against accounts that don't exist still count, and are answered exactly as a wrong password is,
+		// taking as long, so they can't be used to probe for valid user names
+		s.checkDummyPassword(password)
+		s.recordFailedLogin(ctx, accountKey, ipKey, "unknown user")
+		return nil, newUnauthorizedError("credentials are invalid", nil)
+	}
+
+	if err := s.passWordTool.Check(user.Password, password); err == ErrPasswordMismatch {
+		s.recordFailedLogin(ctx, accountKey, ipKey, "invalid password")
 		return nil, newUnauthorizedError("credentials are invalid", nil)
 	} else if err != nil {
 		return nil, newSystemError("failed to validate password", err)
 	}
 
+	s.recordSuccessfulLogin(ctx, accountKey, ipKey, user.ID)
+
+	return user, nil
+}
+
+// checkDummyPassword takes as long as checking a password against a user's hash. The dummy hash is made by the password tool
+// when the service is built, so it has the same cost as users' hashes
+func (s *Service) checkDummyPassword(password string) {
+	if s.dummyHash != "" {
+		s.passWordTool.Check(s.dummyHash, password)
+	}
+}
+
+// LoginWithIdentity returns the User linked to the given external identity.
+// On first login the identity is linked to the existing user with the same email if the provider has verified it,
+// otherwise a new user is created
+func (s *Service) LoginWithIdentity(ctx context.Context, ei ExternalIdentity) (_ *User, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "LoginWithIdentity")
+	defer func() { endSpan(span, dErr) }()
+
+	if ei.Provider == "" || ei.Subject == "" {
+		return nil, newInvalidInputError("each of provider, subject must not be empty", nil)
+	}
+
+	identity, err := s.repo.GetUserIdentity(ctx, ei.Provider, ei.Subject)
+	if err == nil {
+		user, err := s.repo.GetUser(ctx, identity.UserID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, newResourceNotFoundError("user linked to identity does not exist", nil)
+		} else if err != nil {
+			return nil, newSystemError("failed to retrieve user", err)
+		}
+
+		return user, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, newSystemError("failed to retrieve user identity", err)
+	}
+
+	if ei.Email == "" {
+		return nil, newInvalidInputError("identity provider did not supply an email", nil)
+	}
+
+	// hashing the new user's password is slow so is done before the transaction, which would block other writers meanwhile
+	var newUser *User
+	if _, err := s.repo.GetUserByEmail(ctx, ei.Email); errors.Is(err, ErrNotFound) {
+		if newUser, dErr = s.newExternalUser(ei); dErr != nil {
+			return nil, dErr
+		}
+	} else if err != nil {
+		return nil, newSystemError("failed to retrieve user", err)
+	}
+
+	// a new user is only kept if their identity is linked to them
+	var user *User
+	if dErr := s.inTx(ctx, func(tx Repo) *Error {
+		var err error
+		user, err = tx.GetUserByEmail(ctx, ei.Email)
+		if errors.Is(err, ErrNotFound) {
+			if newUser == nil {
+				// the user with the email was removed, or changed their email, since it was looked up
+				return newResourceConflictError("user with this email changed during login, try again", nil)
+			}
+
+			user = newUser
+			if dErr := s.storeExternalUser(ctx, tx, user, ei); dErr != nil {
+				return dErr
+			}
+		} else if err != nil {
+			return newSystemError("failed to retrieve user", err)
+		} else if !ei.EmailVerified {
+			// linking on an unverified email would let anyone claim an account by registering its email with a provider
+			return newResourceConflictError("a user with this email already exists", nil)
+		}
+
+		identity = &UserIdentity{Provider: ei.Provider, Subject: ei.Subject, UserID: user.ID}
+		if err := tx.CreateUserIdentity(ctx, *identity); errors.Is(err, ErrConflict) {
+			// a concurrent first login with the same identity got there first
+			return newResourceConflictError("identity is already linked to a user", err)
+		} else if err != nil {
+			return newSystemError("failed to link user identity", err)
+		}
+
+		return nil
+	}); dErr != nil {
+		return nil, dErr
+	}
+
+	s.logger.WithContext(ctx).WithField("provider", ei.Provider).Infof("linked external identity to user %s", user.ID)
+
 	return user, nil
 }
 
-func (s *Service) GetUserByEmail(ctx context.Context, email string) (*User, *Error) {
+// newExternalUser makes a user for someone signing in via an identity provider for the first time, without a user name.
+// They get a random password they don't know so the account can only be accessed through the provider
+func (s *Service) newExternalUser(ei ExternalIdentity) (*User, *Error) {
+	password, err := randomHex(externalUserPasswordBytes)
+	if err != nil {
+		return nil, newSystemError("failed to generate password", err)
+	}
+
+	id, err := s.idTool.New()
+	if err != nil {
+		return nil, newSystemError("failed to generate valid ID", err)
+	}
+
+	saltedHash, err := s.passWordTool.New(password)
+	if err != nil {
+		return nil, newSystemError("failed to hash password", err)
+	}
+
+	return NewUser(id, "", ei.Email, saltedHash), nil
+}
+
+// storeExternalUser gives a user made by newExternalUser an available user name and stores them
+func (s *Service) storeExternalUser(ctx context.Context, repo Repo, user *User, ei ExternalIdentity) *Error {
+	userName, dErr := availableUserName(ctx, repo, ei.candidateUserName())
+	if dErr != nil {
+		return dErr
+	}
+
+	user.Attributes.UserName = userName
+	if err := user.Validate(s.idTool, s.passWordTool); err != nil {
+		return newInvalidInputError("identity does not make a valid user", err)
+	}
+
+	if err := repo.CreateUser(ctx, *user); errors.Is(err, ErrConflict) {
+		return newResourceConflictError("a user with this user name or email already exists", err)
+	} else if err != nil {
+		return newSystemError("failed to store new user", err)
+	}
+
+	return nil
+}
+
+// availableUserName returns the candidate if no user has it, otherwise the candidate with a random suffix that no user has.
+// The suffix is kept short to leave room for the candidate, so a few are tried in case they are taken too
+func availableUserName(ctx context.Context, repo Repo, candidate string) (string, *Error) {
+	userName := candidate
+	for attempt := 0; ; attempt++ {
+		if userName != "" {
+			_, err := repo.GetUserByUserName(ctx, userName)
+			if errors.Is(err, ErrNotFound) {
+				return userName, nil
+			} else if err != nil {
+				return "", newSystemError("failed to retrieve user", err)
+			}
+		}
+
+		if attempt == maxUserNameSuffixAttempts {
+			return "", newResourceConflictError("no available user name could be found", nil)
+		}
+
+		suffix, err := randomHex(userNameSuffixBytes)
+		if err != nil {
+			return "", newSystemError("failed to generate user name", err)
+		}
+
+		base := candidate
+		if len(base)+len(suffix)+1 > maxUserNameLen {
+			base = base[:maxUserNameLen-len(suffix)-1]
+		}
+		userName = base + "_" + suffix
+	}
+}
+
+// inTx runs fn in a transaction which is rolled back if fn returns an error
+func (s *Service) inTx(ctx context.Context, fn func(tx Repo) *Error) *Error {
+	var dErr *Error
+	err := s.repo.WithTx(ctx, func(tx Repo) error {
+		if dErr = fn(tx); dErr != nil {
+			return dErr
+		}
+
+		return nil
+	})
+	if dErr != nil {
+		return dErr
+	} else if err != nil {
+		return newSystemError("failed to commit transaction", err)
+	}
+
+	return nil
+}
+
+func (s *Service) GetUserByEmail(ctx context.Context, email string) (_ *User, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "GetUserByEmail")
+	defer func() { endSpan(span, dErr) }()
+
 	if email == "" {
 		return nil, newInvalidInputError("email must not be empty", nil)
 	}
 
 	user, err := s.repo.GetUserByEmail(ctx, email)
-	if err != nil {
-		return nil, newSystemError("failed to retrieve user", err)
-	} else if user == nil {
+	if errors.Is(err, ErrNotFound) {
 		return nil, newResourceNotFoundError("user does not exist", nil)
+	} else if err != nil {
+		return nil, newSystemError("failed to retrieve user", err)
 	}
 
 	return user, nil
 }
 
-func (s *Service) GetUserByUserName(ctx context.Context, userName string) (*User, *Error) {
+func (s *Service) GetUserByUserName(ctx context.Context, userName string) (_ *User, dErr *Error) {
+	ctx, span := s.startSpan(ctx, "GetUserByUserName")
+	defer func() { endSpan(span, dErr) }()
+
 	if userName == "" {
 		return nil, newInvalidInputError("username must not be empty", nil)
 	}
 
 	user, err := s.repo.GetUserByUserName(ctx, userName)
-	if err != nil {
-		return nil, newSystemError("failed to retrieve user", err)
-	} else if user == nil {
+	if errors.Is(err, ErrNotFound) {
 		return nil, newResourceNotFoundError("user does not exist", nil)
+	} else if err != nil {
+		return nil, newSystemError("failed to retrieve user", err)
 	}
 
 	return user, nil
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -33,6 +34,7 @@ func TestCreateUser_successPath(t *testing.T) {
 			Email:    email,
 		},
 		Password: saltedHash,
+		Version:  1,
 	}
 
 	mIDt.On("New").Return(uID, nil).Once()
@@ -119,10 +121,6 @@ func TestCreateUser_errorGeneratingID_successPath(t *testing.T) {
 }
 
 func TestCreateUser_repoError_failurePath(t *testing.T) {
-	mr := &mockRepo{}
-	mIDt := &mockIDTool{}
-	mpt := &mockPasswordTool{}
-
 	const (
 		uID        = "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
 		userName   = "JohnDoe"
@@ -138,25 +136,53 @@ func TestCreateUser_repoError_failurePath(t *testing.T) {
 			Email:    email,
 		},
 		Password: saltedHash,
+		Version:  1,
 	}
 
-	mIDt.On("New").Return(uID, nil).Once()
-	mIDt.On("IsValid", uID).Return(true).Once()
+	repoErr := fmt.Errorf("repo error")
+	conflictErr := fmt.Errorf("%w: duplicate email", ErrConflict)
 
-	mpt.On("New", password).Return(saltedHash, nil).Once()
-	mpt.On("IsValid", saltedHash).Return(true).Once()
+	testCases := []struct {
+		name        string
+		repoErr     error
+		expectedErr *Error
+	}{
+		{
+			name:        "unexpected error",
+			repoErr:     repoErr,
+			expectedErr: newSystemError("failed to store new user", repoErr),
+		},
+		{
+			name:        "user name or email taken",
+			repoErr:     conflictErr,
+			expectedErr: newResourceConflictError("a user with this user name or email already exists", conflictErr),
+		},
+	}
 
-	repoErr := fmt.Errorf("repo error")
-	mr.On("CreateUser", mock.Anything, expectedUser).Return(repoErr).Once()
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			mr := &mockRepo{}
+			mIDt := &mockIDTool{}
+			mpt := &mockPasswordTool{}
 
-	service := NewService(nullLogger(), mr, mIDt, mpt)
-	user, err := service.CreateUser(context.Background(), userName, email, password)
-	assert.Nil(t, user)
-	assert.Equal(t, newSystemError("failed to store new user", repoErr), err)
+			mIDt.On("New").Return(uID, nil).Once()
+			mIDt.On("IsValid", uID).Return(true).Once()
 
-	mr.AssertExpectations(t)
-	mIDt.AssertExpectations(t)
-	mpt.AssertExpectations(t)
+			mpt.On("New", password).Return(saltedHash, nil).Once()
+			mpt.On("IsValid", saltedHash).Return(true).Once()
+
+			mr.On("CreateUser", mock.Anything, expectedUser).Return(tc.repoErr).Once()
+
+			service := NewService(nullLogger(), mr, mIDt, mpt)
+			user, err := service.CreateUser(context.Background(), userName, email, password)
+			assert.Nil(t, user)
+			assert.Equal(t, tc.expectedErr, err)
+
+			mr.AssertExpectations(t)
+			mIDt.AssertExpectations(t)
+			mpt.AssertExpectations(t)
+		})
+	}
 }
 
 func TestCreateUser_badInput_failurePath(t *testing.T) {
@@ -170,19 +196,33 @@ func TestCreateUser_badInput_failurePath(t *testing.T) {
 	)
 
 	testCases := []struct {
-		name     string
-		userName string
-		email    string
+		name           string
+		userName       string
+		email          string
+		expectedFields []FieldError
 	}{
 		{
-			name:     "bad email",
-			userName: userName,
-			email:    "notAnEmail",
+			name:           "bad email",
+			userName:       userName,
+			email:          "notAnEmail",
+			expectedFields: []FieldError{{Field: "email", Rule: RuleFormat, Message: "format is invalid"}},
 		},
 		{
 			name:     "bad userName",
 			userName: randomCharsLen21,
-			email:    email,
+			email:    "test@example.com",
+			expectedFields: []FieldError{
+				{Field: "user_name", Rule: RuleMaxLength, Message: "must not be longer than 20 characters"},
+			},
+		},
+		{
+			name:     "bad userName and email",
+			userName: randomCharsLen21,
+			email:    "notAnEmail",
+			expectedFields: []FieldError{
+				{Field: "user_name", Rule: RuleMaxLength, Message: "must not be longer than 20 characters"},
+				{Field: "email", Rule: RuleFormat, Message: "format is invalid"},
+			},
 		},
 	}
 
@@ -196,12 +236,14 @@ func TestCreateUser_badInput_failurePath(t *testing.T) {
 			mIDt.On("IsValid", uID).Return(true).Once()
 
 			mpt.On("New", password).Return(saltedHash, nil).Once()
+			mpt.On("IsValid", saltedHash).Return(true).Once()
 
 			service := NewService(nullLogger(), mr, mIDt, mpt)
-			user, err := service.CreateUser(context.Background(), userName, email, password)
+			user, err := service.CreateUser(context.Background(), tc.userName, tc.email, password)
 			assert.Nil(t, user)
 			assert.Equal(t, "user is invalid", err.Msg)
 			assert.Equal(t, InvalidInput, err.Code)
+			assert.Equal(t, tc.expectedFields, err.Fields)
 
 			mr.AssertExpectations(t)
 			mIDt.AssertExpectations(t)
@@ -284,7 +326,7 @@ func TestGetUser_userNotFound_failurePath(t *testing.T) {
 
 	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
 
-	mr.On("GetUser", mock.Anything, uID).Return(nil, nil).Once()
+	mr.On("GetUser", mock.Anything, uID).Return(nil, ErrNotFound).Once()
 	mIDt.On("IsValid", uID).Return(true).Once()
 
 	service := NewService(nullLogger(), mr, mIDt, nil)
@@ -315,6 +357,7 @@ func TestPatchUser_successPath(t *testing.T) {
 			Email:    "test@example.com",
 		},
 		Password: "password",
+		Version:  3,
 	}
 
 	patchJSON := `[
@@ -330,19 +373,73 @@ func TestPatchUser_successPath(t *testing.T) {
 			Email:    "bar@example.com",
 		},
 		Password: "password",
+		Version:  3,
 	}
 
-	mr.On("GetUser", mock.Anything, uID).Return(&originalUser, nil)
+	// as the repo stores it, with its own version and modification time
+	storedUser := patchedUser
+	storedUser.Version = 4
+	storedUser.ModifiedAt = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	mr.On("GetUser", mock.Anything, uID).Return(&originalUser, nil).Once()
 	mr.On("UpdateUser", mock.Anything, patchedUser).Return(nil)
+	mr.On("GetUser", mock.Anything, uID).Return(&storedUser, nil).Once()
 
 	mIDt.On("IsValid", uID).Return(true).Twice()
 
 	mpt.On("IsValid", "password").Return(true).Once()
 
 	service := NewService(nullLogger(), mr, mIDt, mpt)
-	err := service.PatchUser(context.Background(), uID, []byte(patchJSON))
+	service.now = func() time.Time { return time.Date(2021, 6, 1, 12, 0, 0, 500, time.UTC) }
+	user, err := service.PatchUser(context.Background(), uID, []int64{3}, Patch{Format: JSONPatch, Doc: []byte(patchJSON)})
 	assert.Nil(t, err)
 
+	assert.Equal(t, &storedUser, user, "the user is returned as stored rather than as the service expects it to be")
+
+	mr.AssertExpectations(t)
+	mIDt.AssertExpectations(t)
+}
+
+func TestPatchUser_mergePatch_successPath(t *testing.T) {
+	mr := &mockRepo{}
+	mIDt := &mockIDTool{}
+	mpt := &mockPasswordTool{}
+
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+	originalUser := User{
+		ID: uID,
+		Attributes: UserAttributes{
+			UserName: "JohnDoe",
+			Email:    "test@example.com",
+		},
+		Password: "password",
+		Version:  3,
+	}
+
+	// the email is left as it is
+	patchJSON := `{"user_name": "foo"}`
+
+	patchedUser := originalUser
+	patchedUser.Attributes.UserName = "foo"
+
+	storedUser := patchedUser
+	storedUser.Version = 4
+	storedUser.ModifiedAt = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	mr.On("GetUser", mock.Anything, uID).Return(&originalUser, nil).Once()
+	mr.On("UpdateUser", mock.Anything, patchedUser).Return(nil)
+	mr.On("GetUser", mock.Anything, uID).Return(&storedUser, nil).Once()
+
+	mIDt.On("IsValid", uID).Return(true).Twice()
+
+	mpt.On("IsValid", "password").Return(true).Once()
+
+	service := NewService(nullLogger(), mr, mIDt, mpt)
+	user, err := service.PatchUser(context.Background(), uID, []int64{3}, Patch{Format: MergePatch, Doc: []byte(patchJSON)})
+	assert.Nil(t, err)
+
+	assert.Equal(t, &storedUser, user)
+
 	mr.AssertExpectations(t)
 	mIDt.AssertExpectations(t)
 }
@@ -352,7 +449,7 @@ func TestPatchUser_invalidID_failurePath(t *testing.T) {
 	mIDt.On("IsValid", "nope").Return(false).Once()
 
 	service := NewService(nullLogger(), nil, mIDt, nil)
-	err := service.PatchUser(context.Background(), "nope", []byte("[]"))
+	_, err := service.PatchUser(context.Background(), "nope", nil, Patch{Format: JSONPatch, Doc: []byte("[]")})
 
 	expectedErr := newInvalidInputError("format of userID is invalid", nil)
 	assert.Equal(t, expectedErr.Error(), err.Error())
@@ -368,7 +465,7 @@ func TestPatchUser_invalidJSONInPatchJSON_failurePath(t *testing.T) {
 	mIDt.On("IsValid", uID).Return(true).Once()
 
 	service := NewService(nullLogger(), nil, mIDt, nil)
-	err := service.PatchUser(context.Background(), uID, []byte(patchJSON))
+	_, err := service.PatchUser(context.Background(), uID, nil, Patch{Format: JSONPatch, Doc: []byte(patchJSON)})
 
 	expectedErr := newInvalidInputError("patch could not be decoded", fmt.Errorf("unexpected end of JSON input"))
 	assert.Equal(t, expectedErr.Error(), err.Error())
@@ -396,7 +493,7 @@ func TestPatchUser_invalidPatchJSON_failurePath(t *testing.T) {
 	mIDt.On("IsValid", uID).Return(true).Once()
 
 	service := NewService(nullLogger(), mr, mIDt, nil)
-	err := service.PatchUser(context.Background(), uID, []byte(patchJSON))
+	_, err := service.PatchUser(context.Background(), uID, nil, Patch{Format: JSONPatch, Doc: []byte(patchJSON)})
 
 	expectedErr := newInvalidInputError("failed to patch user, patch invalid", fmt.Errorf("Unexpected kind: unknown"))
 	assert.Equal(t, expectedErr.Error(), err.Error())
@@ -421,7 +518,7 @@ func TestPatchUser_repoErrorRetrievingExistingUser_failurePath(t *testing.T) {
 	mIDt.On("IsValid", uID).Return(true).Once()
 
 	service := NewService(nullLogger(), mr, mIDt, nil)
-	err := service.PatchUser(context.Background(), uID, []byte(patchJSON))
+	_, err := service.PatchUser(context.Background(), uID, nil, Patch{Format: JSONPatch, Doc: []byte(patchJSON)})
 
 	expectedErr := newSystemError("failed to retrieve user", repoErr)
 	assert.Equal(t, expectedErr, err)
@@ -441,12 +538,12 @@ func TestPatchUser_userDoesNotExist_failurePath(t *testing.T) {
 		{ "op": "replace", "path": "/email", "value": "bar@example.com" }
 	]`
 
-	mr.On("GetUser", mock.Anything, uID).Return(nil, nil).Once()
+	mr.On("GetUser", mock.Anything, uID).Return(nil, ErrNotFound).Once()
 
 	mIDt.On("IsValid", uID).Return(true).Once()
 
 	service := NewService(nullLogger(), mr, mIDt, nil)
-	err := service.PatchUser(context.Background(), uID, []byte(patchJSON))
+	_, err := service.PatchUser(context.Background(), uID, nil, Patch{Format: JSONPatch, Doc: []byte(patchJSON)})
 
 	expectedErr := newResourceNotFoundError("user does not exist", nil)
 	assert.Equal(t, expectedErr, err)
@@ -479,7 +576,7 @@ func TestPatchUser_patchDoesntChangeAnything_failurePath(t *testing.T) {
 	mIDt.On("IsValid", uID).Return(true).Once()
 
 	service := NewService(nullLogger(), mr, mIDt, nil)
-	err := service.PatchUser(context.Background(), uID, []byte(patchJSON))
+	_, err := service.PatchUser(context.Background(), uID, nil, Patch{Format: JSONPatch, Doc: []byte(patchJSON)})
 
 	expectedErr := newInvalidInputError("patch does not effect any change", nil).WrapMessage("failed to patch user")
 	assert.Equal(t, expectedErr, err)
@@ -521,9 +618,11 @@ func TestPatchUser_patchMakesInvalidChanges_successPath(t *testing.T) {
 			mr.On("GetUser", mock.Anything, uID).Return(&originalUser, nil).Once()
 
 			mIDt.On("IsValid", uID).Return(true).Twice()
+			mpt := &mockPasswordTool{}
+			mpt.On("IsValid", "password").Return(true).Once()
 
-			service := NewService(nullLogger(), mr, mIDt, nil)
-			err := service.PatchUser(context.Background(), uID, []byte(tc.patchJSON))
+			service := NewService(nullLogger(), mr, mIDt, mpt)
+			_, err := service.PatchUser(context.Background(), uID, nil, Patch{Format: JSONPatch, Doc: []byte(tc.patchJSON)})
 
 			assert.Equal(t, InvalidInput, err.Code)
 			assert.Equal(t, "patch would leave user in invalid state", err.Msg)
@@ -542,10 +641,6 @@ func TestPatchUser_repoErrorUpdatingUser_failurePath(t *testing.T) {
 		saltedHash = "$2a$10$zKDq1KOCqy430Fa1oyZs5eqSvyk7U6e8.wlgXTGEUDy7nX/a7lnWK"
 	)
 
-	mr := &mockRepo{}
-	mIDt := &mockIDTool{}
-	mpt := &mockPasswordTool{}
-
 	originalUser := User{
 		ID: uID,
 		Attributes: UserAttributes{
@@ -571,19 +666,287 @@ func TestPatchUser_repoErrorUpdatingUser_failurePath(t *testing.T) {
 	}
 
 	repoErr := fmt.Errorf("repo error")
-	mr.On("GetUser", mock.Anything, uID).Return(&originalUser, nil).Once()
-	mr.On("UpdateUser", mock.Anything, patchedUser).Return(repoErr).Once()
+	conflictErr := fmt.Errorf("%w: duplicate user_name", ErrConflict)
+	versionErr := fmt.Errorf("%w: user is at version 2 not 1", ErrVersionMismatch)
 
-	mpt.On("IsValid", saltedHash).Return(true).Once()
+	testCases := []struct {
+		name        string
+		repoErr     error
+		expectedErr *Error
+	}{
+		{
+			name:        "unexpected error",
+			repoErr:     repoErr,
+			expectedErr: newSystemError("failed to update user with patched attributes", repoErr),
+		},
+		{
+			name:        "user name or email taken",
+			repoErr:     conflictErr,
+			expectedErr: newResourceConflictError("a user with this user name or email already exists", conflictErr),
+		},
+		{
+			name:        "user updated since it was retrieved",
+			repoErr:     versionErr,
+			expectedErr: newPreconditionFailedError("user has been modified since the given version", versionErr),
+		},
+		{
+			name:        "user deleted since it was retrieved",
+			repoErr:     ErrNotFound,
+			expectedErr: newResourceNotFoundError("user does not exist", nil),
+		},
+	}
 
-	mIDt.On("IsValid", uID).Return(true).Twice()
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			mr := &mockRepo{}
+			mIDt := &mockIDTool{}
+			mpt := &mockPasswordTool{}
+
+			// PatchUser modifies the user it retrieves
+			user := originalUser
+			mr.On("GetUser", mock.Anything, uID).Return(&user, nil).Once()
+			mr.On("UpdateUser", mock.Anything, patchedUser).Return(tc.repoErr).Once()
+
+			mpt.On("IsValid", saltedHash).Return(true).Once()
+
+			mIDt.On("IsValid", uID).Return(true).Twice()
+
+			service := NewService(nullLogger(), mr, mIDt, mpt)
+			_, err := service.PatchUser(context.Background(), uID, nil, Patch{Format: JSONPatch, Doc: []byte(patchJSON)})
+			assert.Equal(t, tc.expectedErr, err)
+
+			mr.AssertExpectations(t)
+			mIDt.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPatchUser_versionDoesNotMatch_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+	mIDt := &mockIDTool{}
+
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+	user := User{
+		ID: uID,
+		Attributes: UserAttributes{
+			UserName: "JohnDoe",
+			Email:    "test@example.com",
+		},
+		Password: "password",
+		Version:  3,
+	}
+
+	patchJSON := `[{ "op": "replace", "path": "/user_name", "value": "foo" }]`
+
+	mr.On("GetUser", mock.Anything, uID).Return(&user, nil).Once()
+	mIDt.On("IsValid", uID).Return(true).Once()
+
+	service := NewService(nullLogger(), mr, mIDt, nil)
+	patched, err := service.PatchUser(context.Background(), uID, []int64{1, 2}, Patch{Format: JSONPatch, Doc: []byte(patchJSON)})
+	assert.Nil(t, patched)
+	assert.Equal(t, newPreconditionFailedError("user has been modified since the given version", nil), err)
+
+	// UpdateUser isn't expected
+	mr.AssertExpectations(t)
+	mIDt.AssertExpectations(t)
+}
+
+// ///////////////////////
+// // LoginWithIdentity //
+// /////////////////////
+
+func TestLoginWithIdentity_existingIdentity_successPath(t *testing.T) {
+	mr := &mockRepo{}
+
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+	expectedUser := NewUser(uID, "JohnDoe", "test@example.com", "password")
+
+	mr.On("GetUserIdentity", mock.Anything, "google", "12345").
+		Return(&UserIdentity{Provider: "google", Subject: "12345", UserID: uID}, nil).Once()
+	mr.On("GetUser", mock.Anything, uID).Return(expectedUser, nil).Once()
+
+	service := NewService(nullLogger(), mr, nil, nil)
+	user, err := service.LoginWithIdentity(context.Background(), ExternalIdentity{Provider: "google", Subject: "12345"})
+	assert.Nil(t, err)
+	assert.Equal(t, expectedUser, user)
+
+	mr.AssertExpectations(t)
+}
+
+func TestLoginWithIdentity_linksExistingUserWithVerifiedEmail_successPath(t *testing.T) {
+	mr := &mockRepo{}
+
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+	existingUser := NewUser(uID, "JohnDoe", "test@example.com", "password")
+
+	mr.On("GetUserIdentity", mock.Anything, "google", "12345").Return(nil, ErrNotFound).Once()
+	mr.On("GetUserByEmail", mock.Anything, "test@example.com").Return(existingUser, nil).Twice()
+	mr.On("CreateUserIdentity", mock.Anything, UserIdentity{Provider: "google", Subject: "12345", UserID: uID}).Return(nil).Once()
+
+	service := NewService(nullLogger(), mr, nil, nil)
+	user, err := service.LoginWithIdentity(context.Background(), ExternalIdentity{
+		Provider:      "google",
+		Subject:       "12345",
+		Email:         "test@example.com",
+		EmailVerified: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, existingUser, user)
+
+	mr.AssertExpectations(t)
+}
+
+func TestLoginWithIdentity_existingUserWithUnverifiedEmail_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+
+	existingUser := NewUser("9abc46be-3bcd-42b1-aeb2-ac6ff557a580", "JohnDoe", "test@example.com", "password")
+
+	mr.On("GetUserIdentity", mock.Anything, "google", "12345").Return(nil, ErrNotFound).Once()
+	mr.On("GetUserByEmail", mock.Anything, "test@example.com").Return(existingUser, nil).Twice()
+
+	service := NewService(nullLogger(), mr, nil, nil)
+	user, err := service.LoginWithIdentity(context.Background(), ExternalIdentity{
+		Provider: "google",
+		Subject:  "12345",
+		Email:    "test@example.com",
+	})
+	assert.Nil(t, user)
+	assert.Equal(t, newResourceConflictError("a user with this email already exists", nil), err)
+
+	mr.AssertExpectations(t)
+}
+
+func TestLoginWithIdentity_createsNewUser_successPath(t *testing.T) {
+	testCases := []struct {
+		name             string
+		identity         ExternalIdentity
+		userNamesTaken   int
+		expectedUserName string
+	}{
+		{
+			name:             "user name from preferred user name",
+			identity:         ExternalIdentity{Provider: "google", Subject: "12345", Email: "jd@example.com", PreferredUserName: "John Doe!"},
+			expectedUserName: "JohnDoe",
+		},
+		{
+			name:             "user name from email",
+			identity:         ExternalIdentity{Provider: "google", Subject: "12345", Email: "jd@example.com"},
+			expectedUserName: "jd",
+		},
+		{
+			name:             "user name taken",
+			identity:         ExternalIdentity{Provider: "google", Subject: "12345", Email: "jd@example.com", PreferredUserName: "averyveryverylongname"},
+			userNamesTaken:   1,
+			expectedUserName: "averyveryverylo_",
+		},
+		{
+			name:             "suffixed user name taken too",
+			identity:         ExternalIdentity{Provider: "google", Subject: "12345", Email: "jd@example.com", PreferredUserName: "JohnDoe"},
+			userNamesTaken:   3,
+			expectedUserName: "JohnDoe_",
+		},
+	}
+
+	uID := "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+	saltedHash := "$2a$10$zKDq1KOCqy430Fa1oyZs5eqSvyk7U6e8.wlgXTGEUDy7nX/a7lnWK"
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			mr := &mockRepo{}
+			mIDt := &mockIDTool{}
+			mpt := &mockPasswordTool{}
+
+			mr.On("GetUserIdentity", mock.Anything, "google", "12345").Return(nil, ErrNotFound).Once()
+			mr.On("GetUserByEmail", mock.Anything, "jd@example.com").Return(nil, ErrNotFound).Twice()
+			if tc.userNamesTaken > 0 {
+				mr.On("GetUserByUserName", mock.Anything, mock.Anything).Return(&User{}, nil).Times(tc.userNamesTaken)
+				mr.On("GetUserByUserName", mock.Anything, mock.Anything).Return(nil, ErrNotFound).Once()
+			} else {
+				mr.On("GetUserByUserName", mock.Anything, tc.expectedUserName).Return(nil, ErrNotFound).Once()
+			}
+			mr.On("CreateUser", mock.Anything, mock.AnythingOfType("User")).Return(nil).Once()
+			mr.On("CreateUserIdentity", mock.Anything, UserIdentity{Provider: "google", Subject: "12345", UserID: uID}).Return(nil).Once()
+
+			mIDt.On("New").Return(uID, nil).Once()
+			mIDt.On("IsValid", uID).Return(true).Once()
+
+			mpt.On("New", mock.AnythingOfType("string")).Return(saltedHash, nil).Once()
+			mpt.On("IsValid", saltedHash).Return(true).Once()
+
+			service := NewService(nullLogger(), mr, mIDt, mpt)
+			user, err := service.LoginWithIdentity(context.Background(), tc.identity)
+			assert.Nil(t, err)
+			assert.Equal(t, uID, user.ID)
+			assert.Equal(t, "jd@example.com", user.Attributes.Email)
+			assert.Equal(t, saltedHash, user.Password)
+			if tc.userNamesTaken > 0 {
+				assert.Regexp(t, "^"+tc.expectedUserName+"[0-9a-f]{4}$", user.Attributes.UserName)
+			} else {
+				assert.Equal(t, tc.expectedUserName, user.Attributes.UserName)
+			}
+
+			mr.AssertExpectations(t)
+			mIDt.AssertExpectations(t)
+			mpt.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginWithIdentity_noAvailableUserName_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+
+	mr.On("GetUserIdentity", mock.Anything, "google", "12345").Return(nil, ErrNotFound).Once()
+	mr.On("GetUserByEmail", mock.Anything, "jd@example.com").Return(nil, ErrNotFound).Twice()
+	mr.On("GetUserByUserName", mock.Anything, mock.Anything).Return(&User{}, nil).Times(maxUserNameSuffixAttempts + 1)
+
+	mIDt := &mockIDTool{}
+	mIDt.On("New").Return("9abc46be-3bcd-42b1-aeb2-ac6ff557a580", nil).Once()
+
+	mpt := &mockPasswordTool{}
+	mpt.On("New", mock.AnythingOfType("string")).Return("$2a$10$zKDq1KOCqy430Fa1oyZs5eqSvyk7U6e8.wlgXTGEUDy7nX/a7lnWK", nil).Once()
 
 	service := NewService(nullLogger(), mr, mIDt, mpt)
-	err := service.PatchUser(context.Background(), uID, []byte(patchJSON))
+	_, err := service.LoginWithIdentity(context.Background(), ExternalIdentity{Provider: "google", Subject: "12345", Email: "jd@example.com"})
 
-	expectedErr := newSystemError("failed to update user with patched attributes", repoErr)
-	assert.Equal(t, expectedErr, err)
+	expectedErr := newResourceConflictError("no available user name could be found", nil)
+	assert.Equal(t, expectedErr.Error(), err.Error())
 
 	mr.AssertExpectations(t)
 	mIDt.AssertExpectations(t)
+	mpt.AssertExpectations(t)
+}
+
+func TestLoginWithIdentity_userRemovedDuringLogin_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+
+	mr.On("GetUserIdentity", mock.Anything, "google", "12345").Return(nil, ErrNotFound).Once()
+	mr.On("GetUserByEmail", mock.Anything, "jd@example.com").Return(&User{ID: "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"}, nil).Once()
+	mr.On("GetUserByEmail", mock.Anything, "jd@example.com").Return(nil, ErrNotFound).Once()
+
+	// no new user is made for an email that was taken, so neither tool is expected to be used
+	service := NewService(nullLogger(), mr, &mockIDTool{}, &mockPasswordTool{})
+	user, err := service.LoginWithIdentity(context.Background(), ExternalIdentity{Provider: "google", Subject: "12345", Email: "jd@example.com", EmailVerified: true})
+	assert.Nil(t, user)
+
+	expectedErr := newResourceConflictError("user with this email changed during login, try again", nil)
+	assert.Equal(t, expectedErr.Error(), err.Error())
+
+	mr.AssertExpectations(t)
+}
+
+func TestLoginWithIdentity_badInput_failurePath(t *testing.T) {
+	mr := &mockRepo{}
+	mr.On("GetUserIdentity", mock.Anything, "google", "12345").Return(nil, ErrNotFound).Once()
+
+	service := NewService(nullLogger(), mr, nil, nil)
+
+	user, err := service.LoginWithIdentity(context.Background(), ExternalIdentity{Provider: "google"})
+	assert.Nil(t, user)
+	assert.Equal(t, newInvalidInputError("each of provider, subject must not be empty", nil), err)
+
+	user, err = service.LoginWithIdentity(context.Background(), ExternalIdentity{Provider: "google", Subject: "12345"})
+	assert.Nil(t, user)
+	assert.Equal(t, newInvalidInputError("identity provider did not supply an email", nil), err)
+
+	mr.AssertExpectations(t)
 }
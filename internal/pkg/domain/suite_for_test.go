@@ -3,6 +3,7 @@ package domain
 import (
 	"context"
 	"io/ioutil"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/mock"
@@ -12,6 +13,11 @@ type mockRepo struct {
 	mock.Mock
 }
 
+// WithTx runs fn with the mock itself, so expectations are set the same whether or not calls are made in a transaction
+func (mr *mockRepo) WithTx(ctx context.Context, fn func(tx Repo) error) error {
+	return fn(mr)
+}
+
 func (mr *mockRepo) CreateUser(ctx context.Context, user User) error {
 	args := mr.Called(ctx, user)
 	if args.Error(0) != nil {
@@ -57,6 +63,82 @@ func (mr *mockRepo) GetUserByEmail(ctx context.Context, email string) (*User, er
 	return args.Get(0).(*User), args.Error(1)
 }
 
+func (mr *mockRepo) GetUserIdentity(ctx context.Context, provider, subject string) (*UserIdentity, error) {
+	args := mr.Called(ctx, provider, subject)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*UserIdentity), args.Error(1)
+}
+
+func (mr *mockRepo) CreateUserIdentity(ctx context.Context, identity UserIdentity) error {
+	args := mr.Called(ctx, identity)
+	return args.Error(0)
+}
+
+func (mr *mockRepo) GetUserMFA(ctx context.Context, userID string) (*UserMFA, error) {
+	args := mr.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*UserMFA), args.Error(1)
+}
+
+func (mr *mockRepo) SaveUserMFA(ctx context.Context, mfa UserMFA) error {
+	args := mr.Called(ctx, mfa)
+	return args.Error(0)
+}
+
+func (mr *mockRepo) UpdateMFALastUsedStep(ctx context.Context, userID string, step int64) (bool, error) {
+	args := mr.Called(ctx, userID, step)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mr *mockRepo) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	args := mr.Called(ctx, userID, codeHashes)
+	return args.Error(0)
+}
+
+func (mr *mockRepo) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	args := mr.Called(ctx, userID, codeHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mr *mockRepo) CreateAPIKey(ctx context.Context, key APIKey) error {
+	args := mr.Called(ctx, key)
+	return args.Error(0)
+}
+
+func (mr *mockRepo) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	args := mr.Called(ctx, keyHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*APIKey), args.Error(1)
+}
+
+func (mr *mockRepo) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	args := mr.Called(ctx, userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]APIKey), args.Error(1)
+}
+
+func (mr *mockRepo) RevokeAPIKey(ctx context.Context, userID, keyID string, at time.Time) (bool, error) {
+	args := mr.Called(ctx, userID, keyID, at)
+	return args.Bool(0), args.Error(1)
+}
+
+func (mr *mockRepo) TouchAPIKey(ctx context.Context, keyID string, at time.Time) error {
+	args := mr.Called(ctx, keyID, at)
+	return args.Error(0)
+}
+
 type mockIDTool struct {
 	mock.Mock
 }
@@ -76,7 +158,14 @@ type mockPasswordTool struct {
 	mock.Mock
 }
 
+// testDummyHash is what NewService is given for the dummy password, without tests having to expect it
+const testDummyHash = "dummy"
+
 func (mpt *mockPasswordTool) New(password string) (string, error) {
+	if password == dummyPassword {
+		return testDummyHash, nil
+	}
+
 	args := mpt.Called(password)
 
 	return args.Get(0).(string), args.Error(1)
@@ -92,6 +181,83 @@ func (mpt *mockPasswordTool) Check(hash, password string) error {
 	return args.Error(0)
 }
 
+type mockTOTPTool struct {
+	mock.Mock
+}
+
+func (mt *mockTOTPTool) NewSecret() (string, error) {
+	args := mt.Called()
+	return args.String(0), args.Error(1)
+}
+
+func (mt *mockTOTPTool) URI(accountName, secret string) string {
+	args := mt.Called(accountName, secret)
+	return args.String(0)
+}
+
+func (mt *mockTOTPTool) QRCodePNG(accountName, secret string) ([]byte, error) {
+	args := mt.Called(accountName, secret)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (mt *mockTOTPTool) Validate(secret, code string, at time.Time) (int64, bool) {
+	args := mt.Called(secret, code, at)
+	return args.Get(0).(int64), args.Bool(1)
+}
+
+// fakeLoginAttemptStore is a minimal in-memory LoginAttemptStore
+type fakeLoginAttemptStore struct {
+	attempts map[string]LoginAttempts
+}
+
+func newFakeLoginAttemptStore() *fakeLoginAttemptStore {
+	return &fakeLoginAttemptStore{attempts: map[string]LoginAttempts{}}
+}
+
+func (f *fakeLoginAttemptStore) GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+	a, ok := f.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return &a, nil
+}
+
+func (f *fakeLoginAttemptStore) IncrementFailedLogins(ctx context.Context, key string, at time.Time, window time.Duration) (*LoginAttempts, error) {
+	a, ok := f.attempts[key]
+	if !ok || at.Sub(a.LastFailureAt) > window {
+		a = LoginAttempts{Key: key}
+	}
+	a.Failures++
+	a.LastFailureAt = at
+	f.attempts[key] = a
+
+	return &a, nil
+}
+
+func (f *fakeLoginAttemptStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	delete(f.attempts, key)
+	return nil
+}
+
+// recordingAuditor keeps every audit event it receives
+type recordingAuditor struct {
+	events []AuditEvent
+}
+
+func (ra *recordingAuditor) Audit(ctx context.Context, event AuditEvent) {
+	ra.events = append(ra.events, event)
+}
+
+func (ra *recordingAuditor) types() []AuditEventType {
+	types := make([]AuditEventType, 0, len(ra.events))
+	for _, e := range ra.events {
+		types = append(types, e.Type)
+	}
+
+	return types
+}
+
 // Creates a silent logger instance that discards all output
 func nullLogger() *logrus.Logger {
 	logger := logrus.New()
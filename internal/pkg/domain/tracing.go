@@ -0,0 +1,34 @@
+package domain
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+
+	attrErrorType = "domain.error_type"
+)
+
+// startSpan starts a span for a Service method, to be ended with endSpan
+func (s *Service) startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, componentService+"."+method)
+}
+
+// endSpan records the error type of any error the method returned. Only system errors mark the span as failed,
+// the rest are the caller's fault
+func endSpan(span trace.Span, dErr *Error) {
+	if dErr != nil {
+		span.SetAttributes(attribute.String(attrErrorType, dErr.Code.String()))
+		if dErr.Code == SystemError {
+			span.RecordError(dErr)
+			span.SetStatus(codes.Error, dErr.Msg)
+		}
+	}
+
+	span.End()
+}
@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestServiceSpans(t *testing.T) {
+	const uID = "9abc46be-3bcd-42b1-aeb2-ac6ff557a580"
+
+	mr := &mockRepo{}
+	mIDt := &mockIDTool{}
+	mIDt.On("IsValid", uID).Return(true)
+	mIDt.On("IsValid", "bad-id").Return(false)
+	mr.On("GetUser", mock.Anything, uID).Return(nil, errors.New("connection refused")).Once()
+
+	recorder := tracetest.NewSpanRecorder()
+	service := NewService(nullLogger(), mr, mIDt, &mockPasswordTool{})
+	service.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	_, dErr := service.GetUser(context.Background(), "bad-id")
+	require.NotNil(t, dErr)
+	_, dErr = service.GetUser(context.Background(), uID)
+	require.NotNil(t, dErr)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	// the caller's mistakes are recorded but don't fail the span
+	assert.Equal(t, "Service.GetUser", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), attribute.String(attrErrorType, InvalidInput.String()))
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.Contains(t, spans[1].Attributes(), attribute.String(attrErrorType, SystemError.String()))
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+
+	mr.AssertExpectations(t)
+}
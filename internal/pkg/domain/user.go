@@ -1,7 +1,6 @@
 package domain
 
 import (
-	"fmt"
 	"net/mail"
 	"time"
 )
@@ -12,6 +11,8 @@ type User struct {
 	Password   string         `json:"-"`
 	CreatedAt  time.Time      `json:"created_at"`
 	ModifiedAt time.Time      `json:"modifiedAt"`
+	// Version is incremented by every update. It is exposed as the user's ETag rather than in the body
+	Version int64 `json:"-"`
 }
 
 type UserAttributes struct {
@@ -27,45 +28,31 @@ func NewUser(id, userName, email, password string) *User {
 			Email:    email,
 		},
 		Password: password,
+		// the version users are created at
+		Version: 1,
 	}
 }
 
+// Validate checks every field of the user, returning a ValidationError listing all that are invalid
 func (u *User) Validate(idValidator IDValidator, pwordValidator PasswordValidator) error {
-	if !idValidator.IsValid(u.ID) {
-		return fmt.Errorf("id format is invalid")
-	}
-
-	// UserName
-	switch {
-	case u.Attributes.UserName == "":
-		return fmt.Errorf("user name must not be empty")
-	case len(u.Attributes.UserName) > 20:
-		return fmt.Errorf("user name must not be longer than 20 characters")
-	}
+	v := &validator{}
 
-	// Email
-	switch {
-	case u.Attributes.Email == "":
-		return fmt.Errorf("email must not be empty")
-	case len(u.Attributes.Email) > 255:
-		return fmt.Errorf("email must not be longer than 255 characters")
-	}
+	v.check(idValidator.IsValid(u.ID), "id", RuleFormat, "format is invalid")
 
-	if _, err := mail.ParseAddress(u.Attributes.Email); err != nil {
-		return fmt.Errorf("email format is invalid: %v", err)
+	if v.required("user_name", u.Attributes.UserName) {
+		v.maxLength("user_name", u.Attributes.UserName, maxUserNameLen)
 	}
 
-	// Password
-	switch {
-	case u.Password == "":
-		return fmt.Errorf("password must not be empty")
-	case len(u.Password) > 255:
-		return fmt.Errorf("password must not be longer than 255 characters")
+	if v.required("email", u.Attributes.Email) {
+		v.maxLength("email", u.Attributes.Email, 255)
+		_, err := mail.ParseAddress(u.Attributes.Email)
+		v.check(err == nil, "email", RuleFormat, "format is invalid")
 	}
 
-	if !pwordValidator.IsValid(u.Password) {
-		return fmt.Errorf("password is not in expected salted hash format")
+	if v.required("password", u.Password) {
+		v.maxLength("password", u.Password, 255)
+		v.check(pwordValidator.IsValid(u.Password), "password", RuleFormat, "is not in expected salted hash format")
 	}
 
-	return nil
+	return v.err()
 }
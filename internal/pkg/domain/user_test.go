@@ -1,3 +1,36 @@
 package domain
 
-// TODO: write tests for user.Validate
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserValidate(t *testing.T) {
+	mIDt := &mockIDTool{}
+	mpt := &mockPasswordTool{}
+
+	mIDt.On("IsValid", "9abc46be-3bcd-42b1-aeb2-ac6ff557a580").Return(true)
+	mIDt.On("IsValid", "not-an-id").Return(false)
+	mpt.On("IsValid", "hash").Return(true)
+	mpt.On("IsValid", "plaintext").Return(false)
+
+	valid := NewUser("9abc46be-3bcd-42b1-aeb2-ac6ff557a580", "JohnDoe", "test@example.com", "hash")
+	assert.NoError(t, valid.Validate(mIDt, mpt))
+
+	invalid := NewUser("not-an-id", strings.Repeat("a", 21), "", "plaintext")
+	err := invalid.Validate(mIDt, mpt)
+	assert.Equal(t, ValidationError{
+		{Field: "id", Rule: RuleFormat, Message: "format is invalid"},
+		{Field: "user_name", Rule: RuleMaxLength, Message: "must not be longer than 20 characters"},
+		{Field: "email", Rule: RuleRequired, Message: "must not be empty"},
+		{Field: "password", Rule: RuleFormat, Message: "is not in expected salted hash format"},
+	}, err)
+	assert.EqualError(t, err, "id format is invalid; user_name must not be longer than 20 characters; "+
+		"email must not be empty; password is not in expected salted hash format")
+
+	// the invalid fields are passed on to clients
+	dErr := newInvalidInputError("user is invalid", err)
+	assert.Len(t, dErr.Fields, 4)
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validation rules, reported to clients alongside each invalid field so must not change
+const (
+	RuleRequired  = "required"
+	RuleMaxLength = "max_length"
+	RuleFormat    = "format"
+	RuleOneOf     = "one_of"
+)
+
+// FieldError describes why a field of an entity is invalid. Field is named as in the entity's json
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError holds every rule an entity breaks, so they can all be fixed at once
+type ValidationError []FieldError
+
+func (ve ValidationError) Error() string {
+	msgs := make([]string, len(ve))
+	for i, fe := range ve {
+		msgs[i] = fmt.Sprintf("%s %s", fe.Field, fe.Message)
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// validator collects the field errors of an entity. Entities' Validate methods use it to check each of their fields in turn
+type validator struct {
+	errs ValidationError
+}
+
+// check records a field error unless ok
+func (v *validator) check(ok bool, field, rule, message string) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{Field: field, Rule: rule, Message: message})
+	}
+}
+
+// required checks the value is non-empty, reporting whether it is so callers can skip rules that only make sense for a value
+func (v *validator) required(field, value string) bool {
+	v.check(value != "", field, RuleRequired, "must not be empty")
+	return value != ""
+}
+
+func (v *validator) maxLength(field, value string, max int) {
+	v.check(len(value) <= max, field, RuleMaxLength, fmt.Sprintf("must not be longer than %d characters", max))
+}
+
+func (v *validator) oneOf(field, value string, allowed map[string]bool) {
+	v.check(allowed[value], field, RuleOneOf, fmt.Sprintf("%s is not an allowed value", value))
+}
+
+// err returns the collected field errors, or nil if there were none
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return v.errs
+}
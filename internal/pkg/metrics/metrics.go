@@ -0,0 +1,110 @@
+// Package metrics collects Prometheus metrics for the service
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	outcomeOK    = "ok"
+	outcomeError = "error"
+)
+
+// Metrics holds the service's collectors in its own registry, so tests can create as many as they like
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+	domainErrors        *prometheus.CounterVec
+	repoDuration        *prometheus.HistogramVec
+	passwordDuration    *prometheus.HistogramVec
+}
+
+// New creates the metrics, prefixing their names with namespace
+func New(namespace string) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by route template, method and status code.",
+		}, []string{"route", "method", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by route template, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		domainErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "domain_errors_total",
+			Help:      "Errors returned by the domain service, by error type.",
+		}, []string{"type"}),
+		repoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "repo_operation_duration_seconds",
+			Help:      "Time taken by repository operations, by operation and outcome.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation", "outcome"}),
+		// bcrypt is deliberately slow, taking seconds at the cost we use
+		passwordDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "password_hash_duration_seconds",
+			Help:      "Time taken to hash or check passwords, by operation.",
+			Buckets:   prometheus.ExponentialBuckets(.05, 2, 10),
+		}, []string{"operation"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.domainErrors,
+		m.repoDuration,
+		m.passwordDuration,
+	)
+
+	return m
+}
+
+// RegisterDBStats exposes the connection pool statistics of db
+func (m *Metrics) RegisterDBStats(db *sql.DB, dbName string) error {
+	return m.registry.Register(collectors.NewDBStatsCollector(db, dbName))
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a handled HTTP request. route should be the route template rather than the path
+// so that IDs in paths don't create a time series per resource
+func (m *Metrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	statusStr := strconv.Itoa(status)
+	m.httpRequests.WithLabelValues(route, method, statusStr).Inc()
+	m.httpRequestDuration.WithLabelValues(route, method, statusStr).Observe(duration.Seconds())
+}
+
+// CountDomainError records an error returned by the domain service
+func (m *Metrics) CountDomainError(errType domain.ErrorType) {
+	m.domainErrors.WithLabelValues(errType.String()).Inc()
+}
+
+func (m *Metrics) observeRepo(operation string, start time.Time, err *error) {
+	outcome := outcomeOK
+	if *err != nil {
+		outcome = outcomeError
+	}
+
+	m.repoDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubRepo implements only the operations under test, calling any other will panic
+type stubRepo struct {
+	domain.Repo
+	err error
+}
+
+func (r *stubRepo) GetUser(ctx context.Context, userID string) (*domain.User, error) {
+	return nil, r.err
+}
+
+type stubPasswordTool struct {
+	domain.PasswordTool
+}
+
+func (pt *stubPasswordTool) New(password string) (string, error) {
+	return "hash", nil
+}
+
+func (pt *stubPasswordTool) Check(hash, password string) error {
+	return errors.New("mismatch")
+}
+
+func TestObserveRequest(t *testing.T) {
+	m := New("test")
+
+	m.ObserveRequest("/api/v1/users/{userID}", http.MethodGet, http.StatusOK, 10*time.Millisecond)
+	m.ObserveRequest("/api/v1/users/{userID}", http.MethodGet, http.StatusOK, 20*time.Millisecond)
+	m.ObserveRequest("/api/v1/users/{userID}", http.MethodGet, http.StatusNotFound, time.Millisecond)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("/api/v1/users/{userID}", http.MethodGet, "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("/api/v1/users/{userID}", http.MethodGet, "404")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.httpRequestDuration))
+}
+
+func TestCountDomainError(t *testing.T) {
+	m := New("test")
+
+	m.CountDomainError(domain.ResourceNotFound)
+	m.CountDomainError(domain.ResourceNotFound)
+	m.CountDomainError(domain.SystemError)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.domainErrors.WithLabelValues(domain.ResourceNotFound.String())))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.domainErrors.WithLabelValues(domain.SystemError.String())))
+}
+
+func TestInstrumentRepo(t *testing.T) {
+	m := New("test")
+
+	InstrumentRepo(&stubRepo{}, m).GetUser(context.Background(), "id")
+	InstrumentRepo(&stubRepo{err: errors.New("boom")}, m).GetUser(context.Background(), "id")
+	InstrumentRepo(&stubRepo{err: errors.New("boom")}, m).GetUser(context.Background(), "id")
+
+	assert.Equal(t, 2, testutil.CollectAndCount(m.repoDuration))
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `test_repo_operation_duration_seconds_count{operation="GetUser",outcome="ok"} 1`)
+	assert.Contains(t, body, `test_repo_operation_duration_seconds_count{operation="GetUser",outcome="error"} 2`)
+}
+
+func TestInstrumentPasswordTool(t *testing.T) {
+	m := New("test")
+	pt := InstrumentPasswordTool(&stubPasswordTool{}, m)
+
+	hash, err := pt.New("password")
+	assert.NoError(t, err)
+	assert.Equal(t, "hash", hash)
+	assert.EqualError(t, pt.Check(hash, "password"), "mismatch")
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `test_password_hash_duration_seconds_count{operation="hash"} 1`)
+	assert.Contains(t, body, `test_password_hash_duration_seconds_count{operation="check"} 1`)
+}
+
+func scrape(t *testing.T, m *Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	return w.Body.String()
+}
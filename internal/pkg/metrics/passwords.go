@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+)
+
+type instrumentedPasswordTool struct {
+	domain.PasswordTool
+	metrics *Metrics
+}
+
+// InstrumentPasswordTool records how long hashing and checking passwords take
+func InstrumentPasswordTool(pt domain.PasswordTool, m *Metrics) domain.PasswordTool {
+	return &instrumentedPasswordTool{PasswordTool: pt, metrics: m}
+}
+
+func (pt *instrumentedPasswordTool) New(password string) (string, error) {
+	defer pt.observe("hash", time.Now())
+	return pt.PasswordTool.New(password)
+}
+
+func (pt *instrumentedPasswordTool) Check(hash, password string) error {
+	defer pt.observe("check", time.Now())
+	return pt.PasswordTool.Check(hash, password)
+}
+
+func (pt *instrumentedPasswordTool) observe(operation string, start time.Time) {
+	pt.metrics.passwordDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+)
+
+type instrumentedRepo struct {
+	repo    domain.Repo
+	metrics *Metrics
+}
+
+// InstrumentRepo records the duration and outcome of each repository operation
+func InstrumentRepo(repo domain.Repo, m *Metrics) domain.Repo {
+	return &instrumentedRepo{repo: repo, metrics: m}
+}
+
+// WithTx observes the whole transaction, and the operations in it are observed individually as well
+func (r *instrumentedRepo) WithTx(ctx context.Context, fn func(tx domain.Repo) error) (err error) {
+	defer r.metrics.observeRepo("WithTx", time.Now(), &err)
+	return r.repo.WithTx(ctx, func(tx domain.Repo) error {
+		return fn(&instrumentedRepo{repo: tx, metrics: r.metrics})
+	})
+}
+
+func (r *instrumentedRepo) CreateUser(ctx context.Context, user domain.User) (err error) {
+	defer r.metrics.observeRepo("CreateUser", time.Now(), &err)
+	return r.repo.CreateUser(ctx, user)
+}
+
+func (r *instrumentedRepo) GetUser(ctx context.Context, userID string) (_ *domain.User, err error) {
+	defer r.metrics.observeRepo("GetUser", time.Now(), &err)
+	return r.repo.GetUser(ctx, userID)
+}
+
+func (r *instrumentedRepo) GetUserByEmail(ctx context.Context, email string) (_ *domain.User, err error) {
+	defer r.metrics.observeRepo("GetUserByEmail", time.Now(), &err)
+	return r.repo.GetUserByEmail(ctx, email)
+}
+
+func (r *instrumentedRepo) GetUserByUserName(ctx context.Context, userName string) (_ *domain.User, err error) {
+	defer r.metrics.observeRepo("GetUserByUserName", time.Now(), &err)
+	return r.repo.GetUserByUserName(ctx, userName)
+}
+
+func (r *instrumentedRepo) UpdateUser(ctx context.Context, user domain.User) (err error) {
+	defer r.metrics.observeRepo("UpdateUser", time.Now(), &err)
+	return r.repo.UpdateUser(ctx, user)
+}
+
+func (r *instrumentedRepo) GetUserIdentity(ctx context.Context, provider, subject string) (_ *domain.UserIdentity, err error) {
+	defer r.metrics.observeRepo("GetUserIdentity", time.Now(), &err)
+	return r.repo.GetUserIdentity(ctx, provider, subject)
+}
+
+func (r *instrumentedRepo) CreateUserIdentity(ctx context.Context, identity domain.UserIdentity) (err error) {
+	defer r.metrics.observeRepo("CreateUserIdentity", time.Now(), &err)
+	return r.repo.CreateUserIdentity(ctx, identity)
+}
+
+func (r *instrumentedRepo) GetUserMFA(ctx context.Context, userID string) (_ *domain.UserMFA, err error) {
+	defer r.metrics.observeRepo("GetUserMFA", time.Now(), &err)
+	return r.repo.GetUserMFA(ctx, userID)
+}
+
+func (r *instrumentedRepo) SaveUserMFA(ctx context.Context, mfa domain.UserMFA) (err error) {
+	defer r.metrics.observeRepo("SaveUserMFA", time.Now(), &err)
+	return r.repo.SaveUserMFA(ctx, mfa)
+}
+
+func (r *instrumentedRepo) UpdateMFALastUsedStep(ctx context.Context, userID string, step int64) (_ bool, err error) {
+	defer r.metrics.observeRepo("UpdateMFALastUsedStep", time.Now(), &err)
+	return r.repo.UpdateMFALastUsedStep(ctx, userID, step)
+}
+
+func (r *instrumentedRepo) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) (err error) {
+	defer r.metrics.observeRepo("ReplaceRecoveryCodes", time.Now(), &err)
+	return r.repo.ReplaceRecoveryCodes(ctx, userID, codeHashes)
+}
+
+func (r *instrumentedRepo) UseRecoveryCode(ctx context.Context, userID, codeHash string) (_ bool, err error) {
+	defer r.metrics.observeRepo("UseRecoveryCode", time.Now(), &err)
+	return r.repo.UseRecoveryCode(ctx, userID, codeHash)
+}
+
+func (r *instrumentedRepo) CreateAPIKey(ctx context.Context, key domain.APIKey) (err error) {
+	defer r.metrics.observeRepo("CreateAPIKey", time.Now(), &err)
+	return r.repo.CreateAPIKey(ctx, key)
+}
+
+func (r *instrumentedRepo) GetAPIKeyByHash(ctx context.Context, keyHash string) (_ *domain.APIKey, err error) {
+	defer r.metrics.observeRepo("GetAPIKeyByHash", time.Now(), &err)
+	return r.repo.GetAPIKeyByHash(ctx, keyHash)
+}
+
+func (r *instrumentedRepo) ListAPIKeys(ctx context.Context, userID string) (_ []domain.APIKey, err error) {
+	defer r.metrics.observeRepo("ListAPIKeys", time.Now(), &err)
+	return r.repo.ListAPIKeys(ctx, userID)
+}
+
+func (r *instrumentedRepo) RevokeAPIKey(ctx context.Context, userID, keyID string, at time.Time) (_ bool, err error) {
+	defer r.metrics.observeRepo("RevokeAPIKey", time.Now(), &err)
+	return r.repo.RevokeAPIKey(ctx, userID, keyID, at)
+}
+
+func (r *instrumentedRepo) TouchAPIKey(ctx context.Context, keyID string, at time.Time) (err error) {
+	defer r.metrics.observeRepo("TouchAPIKey", time.Now(), &err)
+	return r.repo.TouchAPIKey(ctx, keyID, at)
+}
+
+type instrumentedLoginAttemptStore struct {
+	store   domain.LoginAttemptStore
+	metrics *Metrics
+}
+
+// InstrumentLoginAttemptStore records the duration and outcome of each login attempt store operation
+func InstrumentLoginAttemptStore(store domain.LoginAttemptStore, m *Metrics) domain.LoginAttemptStore {
+	return &instrumentedLoginAttemptStore{store: store, metrics: m}
+}
+
+func (s *instrumentedLoginAttemptStore) GetLoginAttempts(ctx context.Context, key string) (_ *domain.LoginAttempts, err error) {
+	defer s.metrics.observeRepo("GetLoginAttempts", time.Now(), &err)
+	return s.store.GetLoginAttempts(ctx, key)
+}
+
+func (s *instrumentedLoginAttemptStore) IncrementFailedLogins(ctx context.Context, key string, at time.Time, window time.Duration) (_ *domain.LoginAttempts, err error) {
+	defer s.metrics.observeRepo("IncrementFailedLogins", time.Now(), &err)
+	return s.store.IncrementFailedLogins(ctx, key, at, window)
+}
+
+func (s *instrumentedLoginAttemptStore) ResetLoginAttempts(ctx context.Context, key string) (err error) {
+	defer s.metrics.observeRepo("ResetLoginAttempts", time.Now(), &err)
+	return s.store.ResetLoginAttempts(ctx, key)
+}
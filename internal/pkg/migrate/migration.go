@@ -0,0 +1,153 @@
+// Package migrate applies versioned schema migrations, recording them in a schema_migrations table.
+//
+// Scripts are split into statements on semicolons at the end of a line. A statement whose body has such semicolons of its
+// own, e.g. a function or trigger, must be wrapped in lines reading -- +migrate StatementBegin and -- +migrate StatementEnd,
+// otherwise it would be split into broken statements
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+const (
+	statementBegin = "-- +migrate StatementBegin"
+	statementEnd   = "-- +migrate StatementEnd"
+)
+
+// Migration is a numbered pair of SQL scripts, Down undoing Up
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load reads the migrations from the top level of fsys, ordered by version.
+// Files must be named <version>_<name>.up.sql or <version>_<name>.down.sql, and each version needs both
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		match := migrationFileRegex.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s must be named <version>_<name>.up.sql or <version>_<name>.down.sql", e.Name())
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has an invalid version: %v", e.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, m.Name, match[2])
+		}
+
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration file %s: %v", e.Name(), err)
+		}
+
+		// checked now so that a badly delimited script can't leave a migration half applied
+		if _, err := splitStatements(string(b)); err != nil {
+			return nil, fmt.Errorf("migration file %s is invalid: %v", e.Name(), err)
+		}
+
+		if match[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			return nil, fmt.Errorf("migration %d_%s must have non-empty up and down scripts", m.Version, m.Name)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// splitStatements splits a script into statements so that drivers needn't support multiple statements per Exec.
+// Statements end with a semicolon at the end of a line, so semicolons within a line, e.g. in string literals, are fine.
+// Lines between StatementBegin and StatementEnd are kept together as one statement whatever they hold.
+// Otherwise lines that are only a -- comment are dropped
+func splitStatements(script string) ([]string, error) {
+	var statements []string
+	var current strings.Builder
+	inBlock := false
+	for i, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == statementBegin:
+			if inBlock {
+				return nil, fmt.Errorf("line %d: StatementBegin before the previous block's StatementEnd", i+1)
+			} else if strings.TrimSpace(current.String()) != "" {
+				return nil, fmt.Errorf("line %d: StatementBegin before the previous statement's semicolon", i+1)
+			}
+
+			inBlock = true
+			continue
+		case trimmed == statementEnd:
+			if !inBlock {
+				return nil, fmt.Errorf("line %d: StatementEnd without a StatementBegin", i+1)
+			}
+
+			if stmt := strings.TrimSpace(current.String()); stmt != "" {
+				statements = append(statements, stmt)
+			}
+
+			current.Reset()
+			inBlock = false
+			continue
+		case inBlock:
+			current.WriteString(line)
+			current.WriteString("\n")
+			continue
+		case trimmed == "" || strings.HasPrefix(trimmed, "--"):
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+
+	if inBlock {
+		return nil, fmt.Errorf("StatementBegin without a StatementEnd")
+	}
+
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+
+	return statements, nil
+}
@@ -0,0 +1,165 @@
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/OJOMB/graffiti-berlin-svc/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_tags.up.sql":         {Data: []byte("CREATE TABLE tags (id int);")},
+		"0002_add_tags.down.sql":       {Data: []byte("DROP TABLE tags;")},
+		"0001_initial_schema.up.sql":   {Data: []byte("CREATE TABLE users (id int);")},
+		"0001_initial_schema.down.sql": {Data: []byte("DROP TABLE users;")},
+	}
+
+	migrations, err := Load(fsys)
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 1, Name: "initial_schema", Up: "CREATE TABLE users (id int);", Down: "DROP TABLE users;"},
+		{Version: 2, Name: "add_tags", Up: "CREATE TABLE tags (id int);", Down: "DROP TABLE tags;"},
+	}, migrations)
+}
+
+func TestLoad_invalid(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"badly named file": {
+			"initial_schema.up.sql": {Data: []byte("CREATE TABLE users (id int);")},
+		},
+		"missing down script": {
+			"0001_initial_schema.up.sql": {Data: []byte("CREATE TABLE users (id int);")},
+		},
+		"empty up script": {
+			"0001_initial_schema.up.sql":   {Data: []byte("\n")},
+			"0001_initial_schema.down.sql": {Data: []byte("DROP TABLE users;")},
+		},
+		"unended statement block": {
+			"0001_initial_schema.up.sql":   {Data: []byte("-- +migrate StatementBegin\nCREATE TABLE users (id int);")},
+			"0001_initial_schema.down.sql": {Data: []byte("DROP TABLE users;")},
+		},
+		"version used twice": {
+			"0001_initial_schema.up.sql": {Data: []byte("CREATE TABLE users (id int);")},
+			"0001_add_tags.down.sql":     {Data: []byte("DROP TABLE tags;")},
+		},
+	}
+
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(fsys)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_embeddedMigrations(t *testing.T) {
+	for name, fsys := range map[string]fs.FS{"mysql": db.MySQLMigrations(), "sqlite": db.SQLiteMigrations(), "postgres": db.PostgresMigrations()} {
+		t.Run(name, func(t *testing.T) {
+			migrations, err := Load(fsys)
+			require.NoError(t, err)
+			require.NotEmpty(t, migrations)
+
+			for i, m := range migrations {
+				assert.Equal(t, int64(i+1), m.Version, "migration versions should be consecutive")
+			}
+		})
+	}
+}
+
+func TestLoad_embeddedMigrationsMatch(t *testing.T) {
+	mysql, err := Load(db.MySQLMigrations())
+	require.NoError(t, err)
+
+	for name, fsys := range map[string]fs.FS{"sqlite": db.SQLiteMigrations(), "postgres": db.PostgresMigrations()} {
+		migrations, err := Load(fsys)
+		require.NoError(t, err)
+
+		require.Equal(t, len(mysql), len(migrations), "%s should have the same migrations as mysql", name)
+		for i := range mysql {
+			assert.Equal(t, mysql[i].Name, migrations[i].Name)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- users can log in
+CREATE TABLE users (
+    id varchar(36),
+    name varchar(100) DEFAULT 'a;b'
+);
+
+CREATE INDEX idx_users_name ON users (name);
+INSERT INTO users (id) VALUES ('x')`
+
+	statements, err := splitStatements(script)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"CREATE TABLE users (\n    id varchar(36),\n    name varchar(100) DEFAULT 'a;b'\n);",
+		"CREATE INDEX idx_users_name ON users (name);",
+		"INSERT INTO users (id) VALUES ('x')",
+	}, statements)
+}
+
+func TestSplitStatements_statementBlocks(t *testing.T) {
+	body := `CREATE FUNCTION set_updated_at() RETURNS trigger LANGUAGE plpgsql AS $$
+BEGIN
+    -- kept as the block is passed on whole
+    NEW.updated_at := CURRENT_TIMESTAMP;
+    RETURN NEW;
+END
+$$;`
+
+	// without the delimiters a multi-line body is split at each semicolon, into statements that would fail
+	statements, err := splitStatements(body)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"CREATE FUNCTION set_updated_at() RETURNS trigger LANGUAGE plpgsql AS $$\nBEGIN\n    NEW.updated_at := CURRENT_TIMESTAMP;",
+		"RETURN NEW;",
+		"END\n$$;",
+	}, statements)
+
+	statements, err = splitStatements("CREATE TABLE a (id int);\n-- +migrate StatementBegin\n" + body + "\n-- +migrate StatementEnd\nCREATE TABLE b (id int);")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"CREATE TABLE a (id int);", body, "CREATE TABLE b (id int);"}, statements)
+}
+
+func TestSplitStatements_failurePath(t *testing.T) {
+	testCases := []struct {
+		name        string
+		script      string
+		expectedErr string
+	}{
+		{
+			name:        "block never ended",
+			script:      "-- +migrate StatementBegin\nCREATE TABLE a (id int);",
+			expectedErr: "StatementBegin without a StatementEnd",
+		},
+		{
+			name:        "block ended twice",
+			script:      "-- +migrate StatementBegin\nCREATE TABLE a (id int);\n-- +migrate StatementEnd\n-- +migrate StatementEnd",
+			expectedErr: "line 4: StatementEnd without a StatementBegin",
+		},
+		{
+			name:        "nested blocks",
+			script:      "-- +migrate StatementBegin\n-- +migrate StatementBegin",
+			expectedErr: "line 2: StatementBegin before the previous block's StatementEnd",
+		},
+		{
+			name:        "block within a statement",
+			script:      "CREATE TABLE a (\n-- +migrate StatementBegin",
+			expectedErr: "line 2: StatementBegin before the previous statement's semicolon",
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d: %s", idx, tc.name), func(t *testing.T) {
+			_, err := splitStatements(tc.script)
+			assert.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const componentMigrator = "Migrator"
+
+// Dialect covers the differences between databases that matter to the Migrator
+type Dialect interface {
+	// CreateTableSQL creates the schema_migrations table if it doesn't already exist
+	CreateTableSQL() string
+	// Placeholder is the bind parameter for the nth argument of a query, counting from 1
+	Placeholder(n int) string
+	// Lock blocks until conn holds a lock that excludes Migrators on every other connection to the database,
+	// so that replicas starting at the same time don't apply the same migrations
+	Lock(ctx context.Context, conn *sql.Conn) error
+	Unlock(ctx context.Context, conn *sql.Conn) error
+}
+
+// Status describes a migration known to the Migrator or recorded in the database
+type Status struct {
+	Version int64
+	Name    string
+	Applied bool
+	// AppliedAt is zero if the migration hasn't been applied
+	AppliedAt time.Time
+	// Dirty migrations failed part way through and the schema must be fixed by hand
+	Dirty bool
+	// Unknown migrations were applied but aren't known to the Migrator, e.g. because a newer release applied them
+	Unknown bool
+}
+
+// record is a row of the schema_migrations table
+type record struct {
+	name      string
+	dirty     bool
+	appliedAt time.Time
+}
+
+// Migrator applies migrations to a database. Each migration is recorded as dirty before it is run and marked clean
+// once it succeeds, as not every database can roll back DDL, and nothing more is run while a migration is dirty
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+	logger     *logrus.Entry
+}
+
+// New expects migrations ordered by version, as returned by Load
+func New(db *sql.DB, dialect Dialect, migrations []Migration, logger *logrus.Logger) *Migrator {
+	return &Migrator{
+		db:         db,
+		dialect:    dialect,
+		migrations: migrations,
+		logger:     logger.WithField("component", componentMigrator),
+	}
+}
+
+// Up applies every migration that hasn't been applied, in order of version, returning how many were applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		records, err := m.records(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := records[mig.Version]; ok {
+				continue
+			}
+
+			if err := m.up(ctx, conn, mig); err != nil {
+				return err
+			}
+
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the most recently applied migrations, up to steps of them, returning how many were rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	rolledBack := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		records, err := m.records(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		versions := make([]int64, 0, len(records))
+		for v := range records {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, v := range versions {
+			if rolledBack == steps {
+				break
+			}
+
+			mig, ok := m.migration(v)
+			if !ok {
+				return fmt.Errorf("can't roll back migration %d_%s as it isn't known to this release", v, records[v].name)
+			}
+
+			if err := m.down(ctx, conn, mig); err != nil {
+				return err
+			}
+
+			rolledBack++
+		}
+
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// Status lists every known or applied migration, ordered by version
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		records, err := m.queryRecords(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			s := Status{Version: mig.Version, Name: mig.Name}
+			if r, ok := records[mig.Version]; ok {
+				s.Applied, s.AppliedAt, s.Dirty = true, r.appliedAt, r.dirty
+				delete(records, mig.Version)
+			}
+
+			statuses = append(statuses, s)
+		}
+
+		for v, r := range records {
+			statuses = append(statuses, Status{Version: v, Name: r.name, Applied: true, AppliedAt: r.appliedAt, Dirty: r.dirty, Unknown: true})
+		}
+
+		return nil
+	})
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, err
+}
+
+// withLock runs fn holding the migration lock, on a single connection as the lock belongs to the connection
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get DB connection: %v", err)
+	}
+	defer conn.Close()
+
+	if err := m.dialect.Lock(ctx, conn); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %v", err)
+	}
+	defer func() {
+		// the lock must be released even if ctx was cancelled, otherwise it is only released when the connection is
+		if err := m.dialect.Unlock(context.Background(), conn); err != nil {
+			m.logger.WithError(err).Error("failed to release migration lock")
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, m.dialect.CreateTableSQL()); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) queryRecords(ctx context.Context, conn *sql.Conn) (map[int64]record, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, dirty, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	records := map[int64]record{}
+	for rows.Next() {
+		var version int64
+		var r record
+		if err := rows.Scan(&version, &r.name, &r.dirty, &r.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+		}
+
+		records[version] = r
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+
+	return records, nil
+}
+
+// records returns the applied migrations, failing if any are dirty
+func (m *Migrator) records(ctx context.Context, conn *sql.Conn) (map[int64]record, error) {
+	records, err := m.queryRecords(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	for v, r := range records {
+		if r.dirty {
+			return nil, fmt.Errorf(
+				"migration %d_%s is dirty as it failed part way through, fix the schema by hand then delete its row from schema_migrations", v, r.name,
+			)
+		}
+	}
+
+	return records, nil
+}
+
+func (m *Migrator) migration(version int64) (Migration, bool) {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+
+	return Migration{}, false
+}
+
+func (m *Migrator) up(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	logger := m.logger.WithField("migration", fmt.Sprintf("%d_%s", mig.Version, mig.Name))
+	logger.Info("applying migration")
+
+	insert := fmt.Sprintf(
+		`INSERT INTO schema_migrations (version, name, dirty) VALUES (%s, %s, %s)`,
+		m.dialect.Placeholder(1), m.dialect.Placeholder(2), m.dialect.Placeholder(3),
+	)
+	if _, err := conn.ExecContext(ctx, insert, mig.Version, mig.Name, true); err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+
+	if err := exec(ctx, conn, mig.Up); err != nil {
+		return fmt.Errorf("failed to apply migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+
+	clean := fmt.Sprintf(`UPDATE schema_migrations SET dirty = %s WHERE version = %s`, m.dialect.Placeholder(1), m.dialect.Placeholder(2))
+	if _, err := conn.ExecContext(ctx, clean, false, mig.Version); err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+
+	logger.Info("applied migration")
+
+	return nil
+}
+
+func (m *Migrator) down(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	logger := m.logger.WithField("migration", fmt.Sprintf("%d_%s", mig.Version, mig.Name))
+	logger.Info("rolling back migration")
+
+	dirty := fmt.Sprintf(`UPDATE schema_migrations SET dirty = %s WHERE version = %s`, m.dialect.Placeholder(1), m.dialect.Placeholder(2))
+	if _, err := conn.ExecContext(ctx, dirty, true, mig.Version); err != nil {
+		return fmt.Errorf("failed to record rollback of migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+
+	if err := exec(ctx, conn, mig.Down); err != nil {
+		return fmt.Errorf("failed to roll back migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+
+	remove := fmt.Sprintf(`DELETE FROM schema_migrations WHERE version = %s`, m.dialect.Placeholder(1))
+	if _, err := conn.ExecContext(ctx, remove, mig.Version); err != nil {
+		return fmt.Errorf("failed to record rollback of migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+
+	logger.Info("rolled back migration")
+
+	return nil
+}
+
+func exec(ctx context.Context, conn *sql.Conn, script string) error {
+	statements, err := splitStatements(script)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range statements {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
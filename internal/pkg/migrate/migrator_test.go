@@ -0,0 +1,148 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/OJOMB/graffiti-berlin-svc/db"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "modernc.org/sqlite"
+)
+
+var testMigrations = []Migration{
+	{Version: 1, Name: "create_artists", Up: "CREATE TABLE artists (id varchar(36) PRIMARY KEY);", Down: "DROP TABLE artists;"},
+	{Version: 2, Name: "create_crews", Up: "CREATE TABLE crews (id varchar(36) PRIMARY KEY);", Down: "DROP TABLE crews;"},
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	sqlDB, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	return sqlDB
+}
+
+func newTestMigrator(sqlDB *sql.DB, migrations []Migration) *Migrator {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	return New(sqlDB, NewSQLite(), migrations, logger)
+}
+
+func tableExists(t *testing.T, sqlDB *sql.DB, table string) bool {
+	var n int
+	require.NoError(t, sqlDB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n))
+
+	return n == 1
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := newTestDB(t)
+
+	// a release with only the first migration
+	applied, err := newTestMigrator(sqlDB, testMigrations[:1]).Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied)
+
+	m := newTestMigrator(sqlDB, testMigrations)
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[0].AppliedAt.IsZero())
+	assert.False(t, statuses[1].Applied)
+
+	applied, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied, "only pending migrations are applied")
+	assert.True(t, tableExists(t, sqlDB, "crews"))
+
+	applied, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, applied)
+
+	rolledBack, err := m.Down(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rolledBack)
+	assert.False(t, tableExists(t, sqlDB, "crews"), "the latest migration is rolled back first")
+	assert.True(t, tableExists(t, sqlDB, "artists"))
+
+	rolledBack, err = m.Down(ctx, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rolledBack)
+	assert.False(t, tableExists(t, sqlDB, "artists"))
+}
+
+func TestMigrator_unknownMigrations(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := newTestDB(t)
+
+	_, err := newTestMigrator(sqlDB, testMigrations).Up(ctx)
+	require.NoError(t, err)
+
+	// an older release doesn't know the second migration
+	m := newTestMigrator(sqlDB, testMigrations[:1])
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, Status{Version: 2, Name: "create_crews", Applied: true, AppliedAt: statuses[1].AppliedAt, Unknown: true}, statuses[1])
+
+	_, err = m.Up(ctx)
+	assert.NoError(t, err, "newer migrations don't stop an older release starting")
+
+	rolledBack, err := m.Down(ctx, 1)
+	assert.EqualError(t, err, "can't roll back migration 2_create_crews as it isn't known to this release")
+	assert.Equal(t, 0, rolledBack)
+}
+
+func TestMigrator_dirty(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := newTestDB(t)
+
+	broken := append(testMigrations[:1:1], Migration{
+		Version: 2,
+		Name:    "create_crews",
+		Up:      "CREATE TABLE crews (id varchar(36) PRIMARY KEY);\nCREATE TABLE crews (id varchar(36) PRIMARY KEY);",
+		Down:    "DROP TABLE crews;",
+	})
+
+	applied, err := newTestMigrator(sqlDB, broken).Up(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 1, applied)
+
+	m := newTestMigrator(sqlDB, testMigrations)
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.True(t, statuses[1].Dirty)
+
+	_, err = m.Up(ctx)
+	assert.EqualError(t, err, "migration 2_create_crews is dirty as it failed part way through, "+
+		"fix the schema by hand then delete its row from schema_migrations")
+
+	_, err = m.Down(ctx, 1)
+	assert.Error(t, err, "nothing runs while a migration is dirty")
+}
+
+func TestMigrator_sqliteMigrations(t *testing.T) {
+	ctx := context.Background()
+	sqlDB := newTestDB(t)
+
+	migrations, err := Load(db.SQLiteMigrations())
+	require.NoError(t, err)
+	m := newTestMigrator(sqlDB, migrations)
+
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.True(t, tableExists(t, sqlDB, "users"))
+
+	_, err = m.Down(ctx, len(migrations))
+	require.NoError(t, err)
+	assert.False(t, tableExists(t, sqlDB, "users"))
+}
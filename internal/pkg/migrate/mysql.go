@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// MySQL locks with GET_LOCK, a named lock held by the connection. It is released if the connection drops
+// so a migrator that crashes can't leave the lock held
+type MySQL struct {
+	// lockName is shared by every database on the server, so should identify the database
+	lockName    string
+	lockTimeout time.Duration
+}
+
+func NewMySQL(lockName string, lockTimeout time.Duration) *MySQL {
+	return &MySQL{lockName: lockName, lockTimeout: lockTimeout}
+}
+
+func (d *MySQL) CreateTableSQL() string {
+	return `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version bigint NOT NULL,
+    name varchar(255) NOT NULL,
+    dirty boolean NOT NULL,
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (version)
+)`
+}
+
+func (d *MySQL) Placeholder(int) string {
+	return "?"
+}
+
+func (d *MySQL) Lock(ctx context.Context, conn *sql.Conn) error {
+	// GET_LOCK returns 1 once locked, 0 on timeout and NULL on error
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, d.lockName, int(d.lockTimeout.Seconds())).Scan(&locked); err != nil {
+		return err
+	}
+
+	if !locked.Valid {
+		return fmt.Errorf("failed to get lock %s", d.lockName)
+	} else if locked.Int64 != 1 {
+		return fmt.Errorf("timed out after %s waiting for lock %s", d.lockTimeout, d.lockName)
+	}
+
+	return nil
+}
+
+func (d *MySQL) Unlock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `DO RELEASE_LOCK(?)`, d.lockName)
+	return err
+}
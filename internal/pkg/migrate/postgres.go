@@ -0,0 +1,70 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// how often to retry the lock while another migrator holds it
+const postgresLockPollInterval = 500 * time.Millisecond
+
+// Postgres locks with a session level advisory lock, held by the connection and released if it drops
+// so a migrator that crashes can't leave the lock held
+type Postgres struct {
+	// lockKey identifies the lock, advisory locks are shared by every database on the server so it is derived from a
+	// name that should identify the database
+	lockKey     int64
+	lockName    string
+	lockTimeout time.Duration
+}
+
+func NewPostgres(lockName string, lockTimeout time.Duration) *Postgres {
+	h := fnv.New64a()
+	h.Write([]byte(lockName))
+
+	return &Postgres{lockKey: int64(h.Sum64()), lockName: lockName, lockTimeout: lockTimeout}
+}
+
+func (d *Postgres) CreateTableSQL() string {
+	return `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version bigint NOT NULL,
+    name varchar(255) NOT NULL,
+    dirty boolean NOT NULL,
+    applied_at timestamptz DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (version)
+)`
+}
+
+func (d *Postgres) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// Lock polls rather than blocking in pg_advisory_lock so that it gives up after the lock timeout
+func (d *Postgres) Lock(ctx context.Context, conn *sql.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, d.lockTimeout)
+	defer cancel()
+
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, d.lockKey).Scan(&locked); err != nil {
+			return err
+		} else if locked {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for lock %s", d.lockTimeout, d.lockName)
+		case <-time.After(postgresLockPollInterval):
+		}
+	}
+}
+
+func (d *Postgres) Unlock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, d.lockKey)
+	return err
+}
@@ -0,0 +1,39 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SQLite locks by holding a write transaction for the whole migration, which other connections wait on for as long as
+// their busy timeout. SQLite can roll back DDL but the transaction is committed even if a migration fails, so that the
+// dirty record is kept just as it is for other databases
+type SQLite struct{}
+
+func NewSQLite() *SQLite {
+	return &SQLite{}
+}
+
+func (d *SQLite) CreateTableSQL() string {
+	return `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version bigint NOT NULL,
+    name varchar(255) NOT NULL,
+    dirty boolean NOT NULL,
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (version)
+)`
+}
+
+func (d *SQLite) Placeholder(int) string {
+	return "?"
+}
+
+func (d *SQLite) Lock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`)
+	return err
+}
+
+func (d *SQLite) Unlock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `COMMIT`)
+	return err
+}
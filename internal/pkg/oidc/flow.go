@@ -0,0 +1,119 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const randomValueBytes = 32
+
+// Flow is the per-login state that must survive the round trip to the provider.
+// It is handed to the user agent in a signed cookie so that any replica can complete the callback
+type Flow struct {
+	Provider     string `json:"p"`
+	State        string `json:"s"`
+	Nonce        string `json:"n"`
+	CodeVerifier string `json:"v"`
+	ExpiresAt    int64  `json:"e"`
+}
+
+// NewFlow generates fresh state, nonce and PKCE verifier values for a login via the given provider
+func NewFlow(provider string, ttl time.Duration) (*Flow, error) {
+	values := make([]string, 3)
+	for i := range values {
+		v, err := randomString()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate random value: %v", err)
+		}
+		values[i] = v
+	}
+
+	return &Flow{
+		Provider:     provider,
+		State:        values[0],
+		Nonce:        values[1],
+		CodeVerifier: values[2],
+		ExpiresAt:    time.Now().Add(ttl).Unix(),
+	}, nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge for the flow's verifier
+// https://datatracker.ietf.org/doc/html/rfc7636#section-4.2
+func (f *Flow) CodeChallenge() string {
+	return CodeChallengeS256(f.CodeVerifier)
+}
+
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// FlowCodec signs and verifies Flows so that they can be stored client side without being tampered with
+type FlowCodec struct {
+	secret []byte
+}
+
+func NewFlowCodec(secret []byte) *FlowCodec {
+	return &FlowCodec{secret: secret}
+}
+
+func (fc *FlowCodec) Encode(f *Flow) (string, error) {
+	payload, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + fc.sign(encoded), nil
+}
+
+// Decode verifies the signature and expiry of an encoded flow
+func (fc *FlowCodec) Decode(s string) (*Flow, error) {
+	parts := strings.SplitN(s, ".", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed flow")
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(fc.sign(parts[0]))) {
+		return nil, fmt.Errorf("flow signature is invalid")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed flow: %v", err)
+	}
+
+	var f Flow
+	if err := json.Unmarshal(payload, &f); err != nil {
+		return nil, fmt.Errorf("malformed flow: %v", err)
+	}
+
+	if time.Now().Unix() > f.ExpiresAt {
+		return nil, fmt.Errorf("flow has expired")
+	}
+
+	return &f, nil
+}
+
+func (fc *FlowCodec) sign(s string) string {
+	mac := hmac.New(sha256.New, fc.secret)
+	mac.Write([]byte(s))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomString() (string, error) {
+	b := make([]byte, randomValueBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minRefreshInterval stops a flood of tokens with unknown kids from hammering the provider's JWKS endpoint
+const minRefreshInterval = time.Minute
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// remoteKeySet caches a provider's public keys, refetching when a token references a kid it hasn't seen,
+// which is how providers signal key rotation
+type remoteKeySet struct {
+	client *http.Client
+	uri    string
+
+	mu          sync.Mutex
+	keys        map[string]interface{}
+	lastFetched time.Time
+}
+
+func newRemoteKeySet(client *http.Client, uri string) *remoteKeySet {
+	return &remoteKeySet{client: client, uri: uri, keys: map[string]interface{}{}}
+}
+
+func (ks *remoteKeySet) get(ctx context.Context, kid, alg string) (interface{}, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[kid]
+	if !ok && time.Since(ks.lastFetched) > minRefreshInterval {
+		if err := ks.refresh(ctx); err != nil {
+			return nil, err
+		}
+		key, ok = ks.keys[kid]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+
+	if !algMatchesKey(alg, key) {
+		return nil, fmt.Errorf("signing method %s does not match key %q", alg, kid)
+	}
+
+	return key, nil
+}
+
+func (ks *remoteKeySet) refresh(ctx context.Context) error {
+	var set jwks
+	if err := getJSON(ctx, ks.client, ks.uri, &set); err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			// skip key types we don't understand rather than rejecting the whole set
+			continue
+		}
+		keys[k.KeyID] = pub
+	}
+
+	ks.keys = keys
+	ks.lastFetched = time.Now()
+
+	return nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.KeyType)
+	}
+}
+
+func algMatchesKey(alg string, key interface{}) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" || alg == "RS384" || alg == "RS512"
+	case *ecdsa.PublicKey:
+		return alg == "ES256" || alg == "ES384"
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,225 @@
+// Package oidctest provides an in-process OpenID Connect provider for exercising the relying-party flow in tests
+package oidctest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const keyID = "mock-key-1"
+
+// User is the identity the provider will assert for the next login
+type User struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUserName string
+}
+
+type authRequest struct {
+	user          User
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// Provider is a minimal OIDC provider that auto-approves every authorization request for the configured User
+type Provider struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+
+	mu    sync.Mutex
+	user  User
+	codes map[string]authRequest
+}
+
+// NewProvider starts a mock provider. Callers must Close it when done
+func NewProvider(clientID, clientSecret string) *Provider {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		private:      private,
+		public:       public,
+		codes:        map[string]authRequest{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("/authorize", p.handleAuthorize)
+	mux.HandleFunc("/token", p.handleToken)
+	mux.HandleFunc("/jwks", p.handleJWKS)
+	p.Server = httptest.NewServer(mux)
+
+	return p
+}
+
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+func (p *Provider) Issuer() string {
+	return p.Server.URL
+}
+
+// SetUser sets the identity asserted by subsequent logins
+func (p *Provider) SetUser(u User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.user = u
+}
+
+// Authorize plays the part of the user agent visiting the authorization URL and returns the callback URL the
+// provider redirects back to, including the code and state query parameters
+func (p *Provider) Authorize(authCodeURL string) (*url.URL, error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	resp, err := client.Get(authCodeURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return resp.Location()
+}
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.Issuer() + "/authorize",
+		"token_endpoint":                        p.Issuer() + "/token",
+		"jwks_uri":                              p.Issuer() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"EdDSA"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+
+	p.mu.Lock()
+	p.codes[code] = authRequest{
+		user:          p.user,
+		clientID:      q.Get("client_id"),
+		redirectURI:   q.Get("redirect_uri"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+	}
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	req, ok := p.codes[r.PostForm.Get("code")]
+	// codes are single use
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok,
+		r.PostForm.Get("client_id") != req.clientID,
+		r.PostForm.Get("client_secret") != p.ClientSecret,
+		r.PostForm.Get("redirect_uri") != req.redirectURI,
+		base64.RawURLEncoding.EncodeToString(sum[:]) != req.codeChallenge:
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"iss":                p.Issuer(),
+		"sub":                req.user.Subject,
+		"aud":                req.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+		"nonce":              req.nonce,
+		"email":              req.user.Email,
+		"email_verified":     req.user.EmailVerified,
+		"preferred_username": req.user.PreferredUserName,
+	})
+	token.Header["kid"] = keyID
+
+	idToken, err := token.SignedString(p.private)
+	if err != nil {
+		http.Error(w, `{"error":"server_error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]string{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": keyID,
+			"alg": "EdDSA",
+			"use": "sig",
+			"x":   base64.RawURLEncoding.EncodeToString(p.public),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,222 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+
+	defaultHTTPTimeout = 10 * time.Second
+)
+
+var defaultScopes = []string{"openid", "email", "profile"}
+
+// Config holds the relying-party registration for a single identity provider
+type Config struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Identity is the verified subset of ID token claims we care about
+type Identity struct {
+	Provider          string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUserName string
+}
+
+type discoveryDoc struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUserName string `json:"preferred_username"`
+}
+
+// Provider is an OpenID Connect relying-party client for the authorization-code flow with PKCE
+type Provider struct {
+	cfg        Config
+	httpClient *http.Client
+	metadata   discoveryDoc
+	keys       *remoteKeySet
+}
+
+// NewProvider fetches the provider's discovery document and returns a client ready to use.
+// If httpClient is nil a client with a default timeout is used
+func NewProvider(ctx context.Context, cfg Config, httpClient *http.Client) (*Provider, error) {
+	if cfg.Name == "" || cfg.IssuerURL == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("each of name, issuer URL, client ID and redirect URL must not be empty")
+	}
+
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = defaultScopes
+	}
+
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+
+	var metadata discoveryDoc
+	if err := getJSON(ctx, httpClient, strings.TrimSuffix(cfg.IssuerURL, "/")+discoveryPath, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document for %s: %v", cfg.Name, err)
+	}
+
+	// the issuer in the discovery document must exactly match the one we were configured with
+	// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation
+	if metadata.Issuer != cfg.IssuerURL {
+		return nil, fmt.Errorf("discovery document issuer %q does not match configured issuer %q", metadata.Issuer, cfg.IssuerURL)
+	}
+
+	return &Provider{
+		cfg:        cfg,
+		httpClient: httpClient,
+		metadata:   metadata,
+		keys:       newRemoteKeySet(httpClient, metadata.JWKSURI),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// AuthCodeURL returns the URL of the provider's consent page to which the user agent should be redirected
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return p.metadata.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange redeems the authorization code for tokens and returns the identity from the verified ID token
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %v", err)
+	}
+
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response contained no id_token")
+	}
+
+	return p.verifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, rawIDToken, nonce string) (*Identity, error) {
+	var claims idTokenClaims
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.get(ctx, kid, token.Method.Alg())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %v", err)
+	}
+
+	switch {
+	case claims.Issuer != p.metadata.Issuer:
+		return nil, fmt.Errorf("id_token issuer %q does not match %q", claims.Issuer, p.metadata.Issuer)
+	case !claims.VerifyAudience(p.cfg.ClientID, true):
+		return nil, fmt.Errorf("id_token audience does not include client ID")
+	case claims.ExpiresAt == nil:
+		return nil, fmt.Errorf("id_token has no expiry")
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("id_token nonce does not match")
+	case claims.Subject == "":
+		return nil, fmt.Errorf("id_token has no subject")
+	}
+
+	return &Identity{
+		Provider:          p.cfg.Name,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUserName: claims.PreferredUserName,
+	}, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
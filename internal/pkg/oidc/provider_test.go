@@ -0,0 +1,167 @@
+package oidc
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRedirectURL = "https://graffiti.example.com/auth/oidc/mock/callback"
+
+func newTestProvider(t *testing.T, mock *oidctest.Provider) *Provider {
+	p, err := NewProvider(context.Background(), Config{
+		Name:         "mock",
+		IssuerURL:    mock.Issuer(),
+		ClientID:     mock.ClientID,
+		ClientSecret: mock.ClientSecret,
+		RedirectURL:  testRedirectURL,
+	}, nil)
+	require.NoError(t, err)
+
+	return p
+}
+
+func TestProviderLogin_successPath(t *testing.T) {
+	mock := oidctest.NewProvider("client-id", "client-secret")
+	defer mock.Close()
+	mock.SetUser(oidctest.User{Subject: "12345", Email: "test@example.com", EmailVerified: true, PreferredUserName: "test"})
+
+	p := newTestProvider(t, mock)
+
+	flow, err := NewFlow(p.Name(), time.Minute)
+	require.NoError(t, err)
+
+	callback, err := mock.Authorize(p.AuthCodeURL(flow.State, flow.Nonce, flow.CodeChallenge()))
+	require.NoError(t, err)
+	assert.Equal(t, "graffiti.example.com", callback.Host)
+	assert.Equal(t, flow.State, callback.Query().Get("state"))
+
+	identity, err := p.Exchange(context.Background(), callback.Query().Get("code"), flow.CodeVerifier, flow.Nonce)
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{
+		Provider:          "mock",
+		Subject:           "12345",
+		Email:             "test@example.com",
+		EmailVerified:     true,
+		PreferredUserName: "test",
+	}, identity)
+}
+
+func TestProviderExchange_failurePath(t *testing.T) {
+	mock := oidctest.NewProvider("client-id", "client-secret")
+	defer mock.Close()
+	mock.SetUser(oidctest.User{Subject: "12345"})
+
+	p := newTestProvider(t, mock)
+
+	testCases := []struct {
+		name        string
+		verifier    func(f *Flow) string
+		nonce       func(f *Flow) string
+		expectedErr string
+	}{
+		{
+			name:        "wrong PKCE verifier",
+			verifier:    func(f *Flow) string { return "not-the-verifier" },
+			nonce:       func(f *Flow) string { return f.Nonce },
+			expectedErr: "token endpoint returned status 400",
+		},
+		{
+			name:        "replayed nonce",
+			verifier:    func(f *Flow) string { return f.CodeVerifier },
+			nonce:       func(f *Flow) string { return "some-other-nonce" },
+			expectedErr: "id_token nonce does not match",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			flow, err := NewFlow(p.Name(), time.Minute)
+			require.NoError(t, err)
+
+			callback, err := mock.Authorize(p.AuthCodeURL(flow.State, flow.Nonce, flow.CodeChallenge()))
+			require.NoError(t, err)
+
+			identity, err := p.Exchange(context.Background(), callback.Query().Get("code"), tc.verifier(flow), tc.nonce(flow))
+			assert.Nil(t, identity)
+			assert.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestNewProvider_issuerMismatch_failurePath(t *testing.T) {
+	mock := oidctest.NewProvider("client-id", "client-secret")
+	defer mock.Close()
+
+	_, err := NewProvider(context.Background(), Config{
+		Name:        "mock",
+		IssuerURL:   mock.Issuer() + "/",
+		ClientID:    mock.ClientID,
+		RedirectURL: testRedirectURL,
+	}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match configured issuer")
+}
+
+func TestProviderAuthCodeURL_successPath(t *testing.T) {
+	mock := oidctest.NewProvider("client-id", "client-secret")
+	defer mock.Close()
+
+	p := newTestProvider(t, mock)
+
+	u, err := url.Parse(p.AuthCodeURL("state", "nonce", "challenge"))
+	require.NoError(t, err)
+	assert.Equal(t, mock.Issuer()+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"client-id"},
+		"redirect_uri":          {testRedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {"state"},
+		"nonce":                 {"nonce"},
+		"code_challenge":        {"challenge"},
+		"code_challenge_method": {"S256"},
+	}, u.Query())
+}
+
+func TestFlowCodec_successPath(t *testing.T) {
+	fc := NewFlowCodec([]byte("secret"))
+
+	flow, err := NewFlow("mock", time.Minute)
+	require.NoError(t, err)
+
+	encoded, err := fc.Encode(flow)
+	require.NoError(t, err)
+
+	decoded, err := fc.Decode(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, flow, decoded)
+}
+
+func TestFlowCodec_failurePath(t *testing.T) {
+	fc := NewFlowCodec([]byte("secret"))
+
+	expired, err := NewFlow("mock", -time.Minute)
+	require.NoError(t, err)
+	encodedExpired, err := fc.Encode(expired)
+	require.NoError(t, err)
+
+	valid, err := NewFlow("mock", time.Minute)
+	require.NoError(t, err)
+	encodedWithOtherSecret, err := NewFlowCodec([]byte("other")).Encode(valid)
+	require.NoError(t, err)
+
+	_, err = fc.Decode(encodedExpired)
+	assert.EqualError(t, err, "flow has expired")
+
+	_, err = fc.Decode(encodedWithOtherSecret)
+	assert.EqualError(t, err, "flow signature is invalid")
+
+	_, err = fc.Decode("garbage")
+	assert.EqualError(t, err, "malformed flow")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -27,15 +28,16 @@ func (pg *PasswordGenerator) New(password string) (string, error) {
 }
 
 func (pg *PasswordGenerator) IsValid(password string) bool {
-	return !saltedHashRegex.MatchString(password)
+	return saltedHashRegex.MatchString(password)
 }
 
+// Check returns domain.ErrPasswordMismatch if the password does not match the hash
 func (pg *PasswordGenerator) Check(hash, password string) error {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	if err != nil && err != bcrypt.ErrMismatchedHashAndPassword {
-		return fmt.Errorf("mismatched hash and password")
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return domain.ErrPasswordMismatch
 	} else if err != nil {
-		return fmt.Errorf("encountered error checking password")
+		return fmt.Errorf("encountered error checking password: %v", err)
 	}
 
 	return nil
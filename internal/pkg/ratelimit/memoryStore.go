@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many takes happen between sweeps of full buckets
+const sweepEvery = 1000
+
+type bucket struct {
+	tokens float64
+	// fullAt is when the bucket will have refilled, after which it is indistinguishable from a new bucket
+	fullAt time.Time
+	at     time.Time
+}
+
+// MemoryStore is a Store for single instance deployments and tests. Buckets are not shared between replicas
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), at: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = refill(b.tokens, now.Sub(b.at), limit)
+	b.at = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	b.fullAt = now.Add(time.Duration((float64(limit.Burst) - b.tokens) * float64(limit.interval())))
+
+	s.takes++
+	if s.takes%sweepEvery == 0 {
+		s.sweep(now)
+	}
+
+	return allowed, b.tokens, nil
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for k, b := range s.buckets {
+		if !now.Before(b.fullAt) {
+			delete(s.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Package ratelimit implements token bucket rate limiting over a pluggable bucket store
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit allows bursts of up to Burst requests, refilling at Burst requests per Period
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// interval is how long it takes for a single token to be refilled
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Burst)
+}
+
+// Store holds token buckets. Implementations must take tokens atomically as a Store may be shared between replicas
+type Store interface {
+	// Take refills the key's bucket for the time elapsed since it was last used, then removes a token if one is available.
+	// It reports whether a token was taken and how many remain, which may be fractional
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, float64, error)
+}
+
+// Result describes the state of a bucket after a request has been counted against it
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next request would be allowed, zero if one would be allowed now
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again
+	ResetAfter time.Duration
+}
+
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Allow counts a request against the key's bucket
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	allowed, tokens, err := l.store.Take(ctx, key, limit, l.now())
+	if err != nil {
+		return nil, err
+	}
+
+	interval := limit.interval()
+	res := &Result{
+		Allowed:    allowed,
+		Limit:      limit.Burst,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: time.Duration((float64(limit.Burst) - tokens) * float64(interval)),
+	}
+
+	if tokens < 1 {
+		res.RetryAfter = time.Duration((1 - tokens) * float64(interval))
+	}
+
+	return res, nil
+}
+
+// refill returns the tokens in a bucket which had the given number of tokens elapsed ago
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return math.Min(float64(limit.Burst), tokens+float64(elapsed)/float64(limit.interval()))
+}
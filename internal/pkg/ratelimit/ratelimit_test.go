@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStores(t *testing.T) map[string]Store {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"redis":  NewRedisStore(client, "ratelimit:"),
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+			limiter := NewLimiter(store)
+			limiter.now = func() time.Time { return now }
+
+			ctx := context.Background()
+			limit := Limit{Burst: 3, Period: 3 * time.Second}
+
+			for i := 2; i >= 0; i-- {
+				res, err := limiter.Allow(ctx, "ip:192.0.2.1", limit)
+				require.NoError(t, err)
+				assert.True(t, res.Allowed)
+				assert.Equal(t, 3, res.Limit)
+				assert.Equal(t, i, res.Remaining)
+			}
+
+			res, err := limiter.Allow(ctx, "ip:192.0.2.1", limit)
+			require.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Equal(t, 0, res.Remaining)
+			assert.Equal(t, time.Second, res.RetryAfter)
+			assert.Equal(t, 3*time.Second, res.ResetAfter)
+
+			// other keys have their own buckets
+			res, err = limiter.Allow(ctx, "ip:192.0.2.2", limit)
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+
+			// half a token has been refilled, which is not enough
+			now = now.Add(500 * time.Millisecond)
+			res, err = limiter.Allow(ctx, "ip:192.0.2.1", limit)
+			require.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+			now = now.Add(time.Second)
+			res, err = limiter.Allow(ctx, "ip:192.0.2.1", limit)
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Equal(t, 0, res.Remaining)
+			assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+
+			// refills never exceed the burst
+			now = now.Add(time.Hour)
+			res, err = limiter.Allow(ctx, "ip:192.0.2.1", limit)
+			require.NoError(t, err)
+			assert.Equal(t, 2, res.Remaining)
+		})
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	limit := Limit{Burst: 10, Period: time.Minute}
+
+	store.Take(context.Background(), "a", limit, now)
+	store.sweep(now.Add(time.Second))
+	assert.Len(t, store.buckets, 1)
+
+	store.sweep(now.Add(time.Minute))
+	assert.Len(t, store.buckets, 0)
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// takeScript is the Redis equivalent of MemoryStore.Take. Running it as a script makes the read-modify-write atomic.
+// Times are in microseconds and the current time is passed in rather than using Redis TIME so behaviour matches the
+// memory store. Buckets expire once full since a missing bucket is treated as full
+var takeScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "at")
+local tokens = tonumber(state[1])
+local at = tonumber(state[2])
+if tokens == nil or at == nil then
+	tokens = burst
+	at = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - at) / interval)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "at", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) * interval / 1000) + 1)
+
+return {allowed, tostring(tokens)}
+`)
+
+// RedisStore is a Store shared between replicas through Redis or a Redis compatible server
+type RedisStore struct {
+	client    redis.Scripter
+	keyPrefix string
+}
+
+// NewRedisStore creates a store which namespaces its keys with keyPrefix
+func NewRedisStore(client redis.Scripter, keyPrefix string) *RedisStore {
+	return &RedisStore{client: client, keyPrefix: keyPrefix}
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, float64, error) {
+	res, err := takeScript.Run(
+		ctx,
+		s.client,
+		[]string{s.keyPrefix + key},
+		limit.Burst, limit.interval().Microseconds(), now.UnixNano()/int64(time.Microsecond),
+	).Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take token: %v", err)
+	} else if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected response from take script: %v", res)
+	}
+
+	allowed, _ := res[0].(int64)
+	tokensStr, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("unexpected tokens from take script: %v", res[1])
+	}
+
+	return allowed == 1, tokens, nil
+}
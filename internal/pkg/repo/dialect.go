@@ -0,0 +1,111 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	mysqlErrDuplicateEntry  = 1062
+	mysqlErrNoReferencedRow = 1452
+
+	postgresErrUniqueViolation     = "23505"
+	postgresErrForeignKeyViolation = "23503"
+)
+
+// sqlDialect is what differs between the databases SQLRepo runs on
+type sqlDialect struct {
+	// system tags query spans, e.g. semconv.DBSystemMySQL
+	system attribute.KeyValue
+	rebind func(query string) string
+	// mapError wraps constraint violations with domain.ErrConflict or domain.ErrNotFound, keeping the driver's message
+	mapError func(err error) error
+}
+
+var (
+	mysqlDialect    = sqlDialect{system: semconv.DBSystemMySQL, rebind: noRebind, mapError: mapMySQLError}
+	sqliteDialect   = sqlDialect{system: semconv.DBSystemSqlite, rebind: noRebind, mapError: mapSQLiteError}
+	postgresDialect = sqlDialect{system: semconv.DBSystemPostgreSQL, rebind: rebindDollar, mapError: mapPostgresError}
+)
+
+// noRebind suits databases that use ? placeholders
+func noRebind(query string) string {
+	return query
+}
+
+// rebindDollar numbers the placeholders as $1, $2... for postgres.
+// None of the queries have a ? other than as a placeholder
+func rebindDollar(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func mapMySQLError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return err
+	}
+
+	switch mysqlErr.Number {
+	case mysqlErrDuplicateEntry:
+		return fmt.Errorf("%w: %v", domain.ErrConflict, err)
+	case mysqlErrNoReferencedRow:
+		return fmt.Errorf("%w: %v", domain.ErrNotFound, err)
+	default:
+		return err
+	}
+}
+
+// mapSQLiteError relies on the driver turning on extended result codes, which tell constraint violations apart
+func mapSQLiteError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return fmt.Errorf("%w: %v", domain.ErrConflict, err)
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		return fmt.Errorf("%w: %v", domain.ErrNotFound, err)
+	default:
+		return err
+	}
+}
+
+func mapPostgresError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case postgresErrUniqueViolation:
+		return fmt.Errorf("%w: %v", domain.ErrConflict, err)
+	case postgresErrForeignKeyViolation:
+		return fmt.Errorf("%w: %v", domain.ErrNotFound, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,61 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebindDollar(t *testing.T) {
+	assert.Equal(t,
+		"UPDATE users SET email = $1 WHERE id = $2 AND user_name = $3",
+		rebindDollar("UPDATE users SET email = ? WHERE id = ? AND user_name = ?"),
+	)
+	assert.Equal(t, "SELECT 1", rebindDollar("SELECT 1"))
+}
+
+// SQLite's errors can only be made by the driver, so its mapping is covered by the SQLite conformance run
+func TestMapError(t *testing.T) {
+	otherErr := errors.New("connection refused")
+
+	testCases := []struct {
+		name     string
+		mapError func(error) error
+		err      error
+		expected error
+	}{
+		{name: "mysql duplicate entry", mapError: mapMySQLError, err: &mysql.MySQLError{Number: 1062}, expected: domain.ErrConflict},
+		{name: "mysql missing referenced row", mapError: mapMySQLError, err: &mysql.MySQLError{Number: 1452}, expected: domain.ErrNotFound},
+		{name: "mysql other error", mapError: mapMySQLError, err: &mysql.MySQLError{Number: 1213}},
+		{name: "mysql wrapped", mapError: mapMySQLError, err: fmt.Errorf("exec: %w", &mysql.MySQLError{Number: 1062}), expected: domain.ErrConflict},
+		{name: "postgres unique violation", mapError: mapPostgresError, err: &pgconn.PgError{Code: "23505"}, expected: domain.ErrConflict},
+		{name: "postgres foreign key violation", mapError: mapPostgresError, err: &pgconn.PgError{Code: "23503"}, expected: domain.ErrNotFound},
+		{name: "postgres other error", mapError: mapPostgresError, err: &pgconn.PgError{Code: "40001"}},
+		{name: "sqlite not a driver error", mapError: mapSQLiteError, err: otherErr},
+		{name: "not a driver error", mapError: mapMySQLError, err: otherErr},
+		{name: "no rows", mapError: mapPostgresError, err: sql.ErrNoRows},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.mapError(tc.err)
+			if tc.expected == nil {
+				assert.Equal(t, tc.err, err, "returned as is")
+				return
+			}
+
+			assert.ErrorIs(t, err, tc.expected)
+			assert.Contains(t, err.Error(), tc.err.Error(), "the driver's message is kept")
+		})
+	}
+
+	assert.NoError(t, mapMySQLError(nil))
+	assert.NoError(t, mapSQLiteError(nil))
+	assert.NoError(t, mapPostgresError(nil))
+}
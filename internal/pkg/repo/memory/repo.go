@@ -0,0 +1,455 @@
+// Package memory provides a domain.Repo held in memory, for tests and running locally without a database.
+// It enforces the same unique and foreign key constraints as the SQL schema, and user names and emails are compared
+// case-insensitively to match MySQL's default collation
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+)
+
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+type recoveryCode struct {
+	used bool
+}
+
+// Repo is safe for concurrent use. Values are copied in and out so callers can't modify what is stored
+type Repo struct {
+	// mu is shared with transactions, which hold it throughout
+	mu            *sync.RWMutex
+	inTx          bool
+	users         map[string]domain.User
+	identities    map[identityKey]domain.UserIdentity
+	mfa           map[string]domain.UserMFA
+	recoveryCodes map[string]map[string]recoveryCode
+	apiKeys       map[string]apiKey
+	// now stamps the columns the database defaults to CURRENT_TIMESTAMP
+	now func() time.Time
+}
+
+// apiKey adds the revocation time, which isn't part of domain.APIKey
+type apiKey struct {
+	key       domain.APIKey
+	revokedAt *time.Time
+}
+
+func NewRepo() *Repo {
+	return &Repo{
+		mu:            &sync.RWMutex{},
+		users:         map[string]domain.User{},
+		identities:    map[identityKey]domain.UserIdentity{},
+		mfa:           map[string]domain.UserMFA{},
+		recoveryCodes: map[string]map[string]recoveryCode{},
+		apiKeys:       map[string]apiKey{},
+		// TIMESTAMP columns hold whole seconds
+		now: func() time.Time { return time.Now().UTC().Truncate(time.Second) },
+	}
+}
+
+// WithTx runs fn against a copy of everything, which replaces the original only if fn succeeds.
+// Transactions hold the lock throughout, so they run one at a time and nothing else sees them part way through
+func (r *Repo) WithTx(ctx context.Context, fn func(tx domain.Repo) error) error {
+	if r.inTx {
+		return fn(r)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx := r.clone()
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	r.users, r.identities, r.mfa, r.recoveryCodes, r.apiKeys = tx.users, tx.identities, tx.mfa, tx.recoveryCodes, tx.apiKeys
+
+	return nil
+}
+
+func (r *Repo) clone() *Repo {
+	tx := &Repo{
+		mu:            r.mu,
+		inTx:          true,
+		users:         make(map[string]domain.User, len(r.users)),
+		identities:    make(map[identityKey]domain.UserIdentity, len(r.identities)),
+		mfa:           make(map[string]domain.UserMFA, len(r.mfa)),
+		recoveryCodes: make(map[string]map[string]recoveryCode, len(r.recoveryCodes)),
+		apiKeys:       make(map[string]apiKey, len(r.apiKeys)),
+		now:           r.now,
+	}
+
+	// stored values are only ever replaced, never modified, so copying the maps is enough
+	for k, v := range r.users {
+		tx.users[k] = v
+	}
+	for k, v := range r.identities {
+		tx.identities[k] = v
+	}
+	for k, v := range r.mfa {
+		tx.mfa[k] = v
+	}
+	for userID, codes := range r.recoveryCodes {
+		tx.recoveryCodes[userID] = make(map[string]recoveryCode, len(codes))
+		for h, c := range codes {
+			tx.recoveryCodes[userID][h] = c
+		}
+	}
+	for k, v := range r.apiKeys {
+		tx.apiKeys[k] = v
+	}
+
+	return tx
+}
+
+// lock and the rest do nothing in a transaction, which already holds the lock
+func (r *Repo) lock() {
+	if !r.inTx {
+		r.mu.Lock()
+	}
+}
+
+func (r *Repo) unlock() {
+	if !r.inTx {
+		r.mu.Unlock()
+	}
+}
+
+func (r *Repo) rlock() {
+	if !r.inTx {
+		r.mu.RLock()
+	}
+}
+
+func (r *Repo) runlock() {
+	if !r.inTx {
+		r.mu.RUnlock()
+	}
+}
+
+func (r *Repo) CreateUser(ctx context.Context, user domain.User) error {
+	r.lock()
+	defer r.unlock()
+
+	if _, ok := r.users[user.ID]; ok {
+		return fmt.Errorf("%w: duplicate user id %s", domain.ErrConflict, user.ID)
+	}
+
+	if err := r.checkUniqueUser(user); err != nil {
+		return err
+	}
+
+	// as the database defaults them to
+	user.Version = 1
+	user.CreatedAt = r.now()
+	user.ModifiedAt = user.CreatedAt
+	r.users[user.ID] = user
+
+	return nil
+}
+
+func (r *Repo) GetUser(ctx context.Context, userID string) (*domain.User, error) {
+	r.rlock()
+	defer r.runlock()
+
+	user, ok := r.users[userID]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+
+	return &user, nil
+}
+
+func (r *Repo) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	r.rlock()
+	defer r.runlock()
+
+	for _, user := range r.users {
+		if strings.EqualFold(user.Attributes.Email, email) {
+			return &user, nil
+		}
+	}
+
+	return nil, domain.ErrNotFound
+}
+
+func (r *Repo) GetUserByUserName(ctx context.Context, userName string) (*domain.User, error) {
+	r.rlock()
+	defer r.runlock()
+
+	for _, user := range r.users {
+		if strings.EqualFold(user.Attributes.UserName, userName) {
+			return &user, nil
+		}
+	}
+
+	return nil, domain.ErrNotFound
+}
+
+// UpdateUser does nothing if the user doesn't exist, like an UPDATE matching no rows
+func (r *Repo) UpdateUser(ctx context.Context, user domain.User) error {
+	r.lock()
+	defer r.unlock()
+
+	existing, ok := r.users[user.ID]
+	if !ok {
+		return fmt.Errorf("%w: user %s", domain.ErrNotFound, user.ID)
+	} else if existing.Version != user.Version {
+		return fmt.Errorf("%w: user %s is at version %d not %d", domain.ErrVersionMismatch, user.ID, existing.Version, user.Version)
+	}
+
+	if err := r.checkUniqueUser(user); err != nil {
+		return err
+	}
+
+	existing.Attributes = user.Attributes
+	existing.Password = user.Password
+	existing.Version++
+	existing.ModifiedAt = r.now()
+	r.users[user.ID] = existing
+
+	return nil
+}
+
+// checkUniqueUser checks no other user has the same user name or email
+func (r *Repo) checkUniqueUser(user domain.User) error {
+	for _, other := range r.users {
+		if other.ID == user.ID {
+			continue
+		}
+
+		if strings.EqualFold(other.Attributes.UserName, user.Attributes.UserName) {
+			return fmt.Errorf("%w: duplicate user_name %s", domain.ErrConflict, user.Attributes.UserName)
+		} else if strings.EqualFold(other.Attributes.Email, user.Attributes.Email) {
+			return fmt.Errorf("%w: duplicate email %s", domain.ErrConflict, user.Attributes.Email)
+		}
+	}
+
+	return nil
+}
+
+func (r *Repo) checkUserExists(userID string) error {
+	if _, ok := r.users[userID]; !ok {
+		return fmt.Errorf("%w: user %s", domain.ErrNotFound, userID)
+	}
+
+	return nil
+}
+
+func (r *Repo) GetUserIdentity(ctx context.Context, provider, subject string) (*domain.UserIdentity, error) {
+	r.rlock()
+	defer r.runlock()
+
+	identity, ok := r.identities[identityKey{provider: provider, subject: subject}]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+
+	return &identity, nil
+}
+
+func (r *Repo) CreateUserIdentity(ctx context.Context, identity domain.UserIdentity) error {
+	r.lock()
+	defer r.unlock()
+
+	key := identityKey{provider: identity.Provider, subject: identity.Subject}
+	if _, ok := r.identities[key]; ok {
+		return fmt.Errorf("%w: duplicate user identity %s/%s", domain.ErrConflict, identity.Provider, identity.Subject)
+	}
+
+	if err := r.checkUserExists(identity.UserID); err != nil {
+		return err
+	}
+
+	identity.CreatedAt = r.now()
+	r.identities[key] = identity
+
+	return nil
+}
+
+func (r *Repo) GetUserMFA(ctx context.Context, userID string) (*domain.UserMFA, error) {
+	r.rlock()
+	defer r.runlock()
+
+	mfa, ok := r.mfa[userID]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+
+	return &mfa, nil
+}
+
+func (r *Repo) SaveUserMFA(ctx context.Context, mfa domain.UserMFA) error {
+	r.lock()
+	defer r.unlock()
+
+	if err := r.checkUserExists(mfa.UserID); err != nil {
+		return err
+	}
+
+	if existing, ok := r.mfa[mfa.UserID]; ok {
+		mfa.CreatedAt = existing.CreatedAt
+	} else {
+		mfa.CreatedAt = r.now()
+	}
+
+	r.mfa[mfa.UserID] = mfa
+
+	return nil
+}
+
+func (r *Repo) UpdateMFALastUsedStep(ctx context.Context, userID string, step int64) (bool, error) {
+	r.lock()
+	defer r.unlock()
+
+	mfa, ok := r.mfa[userID]
+	if !ok || mfa.LastUsedStep >= step {
+		return false, nil
+	}
+
+	mfa.LastUsedStep = step
+	r.mfa[userID] = mfa
+
+	return true, nil
+}
+
+// ReplaceRecoveryCodes leaves the existing codes in place if the new ones can't all be stored
+func (r *Repo) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	r.lock()
+	defer r.unlock()
+
+	codes := map[string]recoveryCode{}
+	for _, h := range codeHashes {
+		if _, ok := codes[h]; ok {
+			return fmt.Errorf("%w: duplicate recovery code for user %s", domain.ErrConflict, userID)
+		}
+
+		codes[h] = recoveryCode{}
+	}
+
+	if len(codes) > 0 {
+		if err := r.checkUserExists(userID); err != nil {
+			return err
+		}
+	}
+
+	r.recoveryCodes[userID] = codes
+
+	return nil
+}
+
+func (r *Repo) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	r.lock()
+	defer r.unlock()
+
+	code, ok := r.recoveryCodes[userID][codeHash]
+	if !ok || code.used {
+		return false, nil
+	}
+
+	r.recoveryCodes[userID][codeHash] = recoveryCode{used: true}
+
+	return true, nil
+}
+
+func (r *Repo) CreateAPIKey(ctx context.Context, key domain.APIKey) error {
+	r.lock()
+	defer r.unlock()
+
+	if _, ok := r.apiKeys[key.ID]; ok {
+		return fmt.Errorf("%w: duplicate api key id %s", domain.ErrConflict, key.ID)
+	}
+
+	for _, k := range r.apiKeys {
+		if k.key.Hash == key.Hash {
+			return fmt.Errorf("%w: duplicate api key hash", domain.ErrConflict)
+		}
+	}
+
+	if err := r.checkUserExists(key.UserID); err != nil {
+		return err
+	}
+
+	r.apiKeys[key.ID] = apiKey{key: copyAPIKey(key)}
+
+	return nil
+}
+
+func (r *Repo) GetAPIKeyByHash(ctx context.Context, keyHash string) (*domain.APIKey, error) {
+	r.rlock()
+	defer r.runlock()
+
+	for _, k := range r.apiKeys {
+		if k.key.Hash == keyHash && k.revokedAt == nil {
+			key := copyAPIKey(k.key)
+			return &key, nil
+		}
+	}
+
+	return nil, domain.ErrNotFound
+}
+
+func (r *Repo) ListAPIKeys(ctx context.Context, userID string) ([]domain.APIKey, error) {
+	r.rlock()
+	defer r.runlock()
+
+	keys := []domain.APIKey{}
+	for _, k := range r.apiKeys {
+		if k.key.UserID == userID && k.revokedAt == nil {
+			keys = append(keys, copyAPIKey(k.key))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	return keys, nil
+}
+
+func (r *Repo) RevokeAPIKey(ctx context.Context, userID, keyID string, at time.Time) (bool, error) {
+	r.lock()
+	defer r.unlock()
+
+	k, ok := r.apiKeys[keyID]
+	if !ok || k.key.UserID != userID || k.revokedAt != nil {
+		return false, nil
+	}
+
+	k.revokedAt = &at
+	r.apiKeys[keyID] = k
+
+	return true, nil
+}
+
+func (r *Repo) TouchAPIKey(ctx context.Context, keyID string, at time.Time) error {
+	r.lock()
+	defer r.unlock()
+
+	k, ok := r.apiKeys[keyID]
+	if !ok {
+		return nil
+	}
+
+	k.key.LastUsedAt = &at
+	r.apiKeys[keyID] = k
+
+	return nil
+}
+
+func copyAPIKey(key domain.APIKey) domain.APIKey {
+	key.Scopes = append([]string(nil), key.Scopes...)
+	if key.LastUsedAt != nil {
+		lastUsedAt := *key.LastUsedAt
+		key.LastUsedAt = &lastUsedAt
+	}
+
+	return key
+}
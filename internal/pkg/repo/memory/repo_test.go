@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/repo/repotest"
+)
+
+func TestRepo_conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) domain.Repo {
+		return NewRepo()
+	})
+}
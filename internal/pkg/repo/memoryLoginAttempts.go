@@ -0,0 +1,76 @@
+package repo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+)
+
+// sweepEvery is how many increments happen between sweeps of stale entries
+const sweepEvery = 1000
+
+// MemoryLoginAttemptStore is a domain.LoginAttemptStore for single instance deployments and tests.
+// Counts are lost on restart and not shared between replicas
+type MemoryLoginAttemptStore struct {
+	mu         sync.Mutex
+	attempts   map[string]domain.LoginAttempts
+	retention  time.Duration
+	increments int
+}
+
+// NewMemoryLoginAttemptStore creates a store which forgets keys whose last failure was longer than retention ago
+func NewMemoryLoginAttemptStore(retention time.Duration) *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: map[string]domain.LoginAttempts{}, retention: retention}
+}
+
+func (s *MemoryLoginAttemptStore) GetLoginAttempts(ctx context.Context, key string) (*domain.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return &a, nil
+}
+
+func (s *MemoryLoginAttemptStore) IncrementFailedLogins(ctx context.Context, key string, at time.Time, window time.Duration) (*domain.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.attempts[key]
+	if !ok || at.Sub(a.LastFailureAt) > window {
+		a = domain.LoginAttempts{Key: key}
+	}
+
+	a.Failures++
+	a.LastFailureAt = at
+	s.attempts[key] = a
+
+	s.increments++
+	if s.increments%sweepEvery == 0 {
+		s.sweep(at)
+	}
+
+	return &a, nil
+}
+
+func (s *MemoryLoginAttemptStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) sweep(now time.Time) {
+	for k, a := range s.attempts {
+		if now.Sub(a.LastFailureAt) > s.retention {
+			delete(s.attempts, k)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLoginAttemptStore(t *testing.T) {
+	store := NewMemoryLoginAttemptStore(time.Hour)
+	ctx := context.Background()
+	now := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	a, err := store.GetLoginAttempts(ctx, "ip:10.0.0.1")
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+
+	store.IncrementFailedLogins(ctx, "ip:10.0.0.1", now, time.Minute)
+	a, err = store.IncrementFailedLogins(ctx, "ip:10.0.0.1", now.Add(time.Second), time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, a.Failures)
+	assert.Equal(t, now.Add(time.Second), a.LastFailureAt)
+
+	// failures older than the window are forgotten
+	a, err = store.IncrementFailedLogins(ctx, "ip:10.0.0.1", now.Add(2*time.Minute), time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, a.Failures)
+
+	assert.NoError(t, store.ResetLoginAttempts(ctx, "ip:10.0.0.1"))
+	a, err = store.GetLoginAttempts(ctx, "ip:10.0.0.1")
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+}
@@ -0,0 +1,40 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/sirupsen/logrus"
+
+	// registers the pgx driver as "pgx"
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+const componentPostgresRepo = "PostgresRepo"
+
+// PostgresRepo stores everything in PostgreSQL with the PostGIS extension, for geo queries beyond what MySQL supports.
+// Queries are shared with SQLRepo, rebound to postgres placeholders, apart from the methods that upsert.
+// There is no piece repository yet, so the geography columns and GIST indexes are there but the bounding box,
+// nearest neighbour and district containment queries that would use them are still to do
+type PostgresRepo struct {
+	*SQLRepo
+}
+
+// NewPostgresRepo expects db to have been opened with the pgx driver
+func NewPostgresRepo(db *sql.DB, logger *logrus.Logger) *PostgresRepo {
+	return &PostgresRepo{SQLRepo: newSQLRepo(newTracedDB(db, componentPostgresRepo, postgresDialect), logger)}
+}
+
+func (r *PostgresRepo) WithTx(ctx context.Context, fn func(tx domain.Repo) error) error {
+	return r.withTx(ctx, func(tx *SQLRepo) error { return fn(&PostgresRepo{SQLRepo: tx}) })
+}
+
+func (r *PostgresRepo) IncrementFailedLogins(ctx context.Context, key string, at time.Time, window time.Duration) (*domain.LoginAttempts, error) {
+	return r.incrementFailedLoginsOnConflict(ctx, key, at, window)
+}
+
+func (r *PostgresRepo) SaveUserMFA(ctx context.Context, mfa domain.UserMFA) error {
+	return r.saveUserMFAOnConflict(ctx, mfa)
+}
@@ -0,0 +1,421 @@
+// Package repotest is a conformance suite for domain.Repo implementations, so that they all behave the same
+package repotest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	userID1 = "7c7f1f4e-3b7a-4b8e-9d8f-0a1b2c3d4e01"
+	userID2 = "7c7f1f4e-3b7a-4b8e-9d8f-0a1b2c3d4e02"
+	userID3 = "7c7f1f4e-3b7a-4b8e-9d8f-0a1b2c3d4e03"
+
+	keyID1 = "5d2b8c1a-6e4f-4a3b-8c7d-1e2f3a4b5c01"
+	keyID2 = "5d2b8c1a-6e4f-4a3b-8c7d-1e2f3a4b5c02"
+	keyID3 = "5d2b8c1a-6e4f-4a3b-8c7d-1e2f3a4b5c03"
+
+	passwordHash = "$2a$15$6Bq3mBzS2mX7JgM0vq7xUeZL0YVw0m6q8xJ0rQ1kq2m3n4o5p6q7r"
+)
+
+// NewRepo returns an empty repo for each test
+type NewRepo func(t *testing.T) domain.Repo
+
+// Run runs the conformance suite against the repos returned by newRepo
+func Run(t *testing.T, newRepo NewRepo) {
+	tests := map[string]func(t *testing.T, repo domain.Repo){
+		"users":                      testUsers,
+		"user unique constraints":    testUserUniqueConstraints,
+		"update user":                testUpdateUser,
+		"user identities":            testUserIdentities,
+		"user mfa":                   testUserMFA,
+		"recovery codes":             testRecoveryCodes,
+		"api keys":                   testAPIKeys,
+		"api key unique constraints": testAPIKeyUniqueConstraints,
+		"revoke api key":             testRevokeAPIKey,
+		"transactions":               testTransactions,
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			test(t, newRepo(t))
+		})
+	}
+}
+
+func newUser(id, userName, email string) domain.User {
+	return *domain.NewUser(id, userName, email, passwordHash)
+}
+
+func createUser(t *testing.T, repo domain.Repo, id, userName, email string) domain.User {
+	user := newUser(id, userName, email)
+	require.NoError(t, repo.CreateUser(context.Background(), user))
+
+	return user
+}
+
+func assertUser(t *testing.T, expected domain.User, actual *domain.User) {
+	if assert.NotNil(t, actual) {
+		assert.Equal(t, expected.ID, actual.ID)
+		assert.Equal(t, expected.Attributes, actual.Attributes)
+		assert.Equal(t, expected.Password, actual.Password)
+		assert.Equal(t, expected.Version, actual.Version)
+		assert.False(t, actual.CreatedAt.IsZero(), "created at should be set by the repo")
+		assert.False(t, actual.ModifiedAt.Before(actual.CreatedAt), "modified at should not be before created at")
+	}
+}
+
+func testUsers(t *testing.T, repo domain.Repo) {
+	ctx := context.Background()
+	user := createUser(t, repo, userID1, "banksy", "banksy@example.com")
+
+	got, err := repo.GetUser(ctx, userID1)
+	require.NoError(t, err)
+	assertUser(t, user, got)
+
+	got, err = repo.GetUserByEmail(ctx, "banksy@example.com")
+	require.NoError(t, err)
+	assertUser(t, user, got)
+
+	got, err = repo.GetUserByUserName(ctx, "banksy")
+	require.NoError(t, err)
+	assertUser(t, user, got)
+
+	// unknown users are not an error
+	got, err = repo.GetUser(ctx, userID2)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+
+	got, err = repo.GetUserByEmail(ctx, "nobody@example.com")
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+
+	got, err = repo.GetUserByUserName(ctx, "nobody")
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
+
+func testUserUniqueConstraints(t *testing.T, repo domain.Repo) {
+	ctx := context.Background()
+	createUser(t, repo, userID1, "banksy", "banksy@example.com")
+
+	assert.ErrorIs(t, repo.CreateUser(ctx, newUser(userID1, "blu", "blu@example.com")), domain.ErrConflict, "duplicate id")
+	assert.ErrorIs(t, repo.CreateUser(ctx, newUser(userID2, "banksy", "blu@example.com")), domain.ErrConflict, "duplicate user name")
+	assert.ErrorIs(t, repo.CreateUser(ctx, newUser(userID2, "blu", "banksy@example.com")), domain.ErrConflict, "duplicate email")
+	assert.ErrorIs(t, repo.CreateUser(ctx, newUser(userID2, "BANKSY", "blu@example.com")), domain.ErrConflict, "user names are case-insensitive")
+	assert.ErrorIs(t, repo.CreateUser(ctx, newUser(userID2, "blu", "Banksy@Example.com")), domain.ErrConflict, "emails are case-insensitive")
+
+	_, err := repo.GetUser(ctx, userID2)
+	assert.ErrorIs(t, err, domain.ErrNotFound, "no user should have been created")
+}
+
+func testUpdateUser(t *testing.T, repo domain.Repo) {
+	ctx := context.Background()
+	user := createUser(t, repo, userID1, "banksy", "banksy@example.com")
+	createUser(t, repo, userID2, "blu", "blu@example.com")
+
+	created, err := repo.GetUser(ctx, userID1)
+	require.NoError(t, err)
+	require.NotNil(t, created)
+
+	user.Attributes.UserName = "banksy2"
+	user.Attributes.Email = "banksy2@example.com"
+	require.NoError(t, repo.UpdateUser(ctx, user))
+	user.Version++
+
+	got, err := repo.GetUser(ctx, userID1)
+	require.NoError(t, err)
+	assertUser(t, user, got)
+	assert.True(t, created.CreatedAt.Equal(got.CreatedAt), "created at should be kept")
+
+	got, err = repo.GetUserByUserName(ctx, "banksy")
+	assert.ErrorIs(t, err, domain.ErrNotFound, "old user name should be free")
+
+	clash := user
+	clash.Attributes.UserName = "blu"
+	assert.ErrorIs(t, repo.UpdateUser(ctx, clash), domain.ErrConflict, "user name taken by another user")
+
+	got, err = repo.GetUser(ctx, userID1)
+	require.NoError(t, err)
+	assertUser(t, user, got)
+
+	stale := user
+	stale.Version = 1
+	stale.Attributes.UserName = "banksy3"
+	assert.ErrorIs(t, repo.UpdateUser(ctx, stale), domain.ErrVersionMismatch, "updated since version 1")
+
+	got, err = repo.GetUser(ctx, userID1)
+	require.NoError(t, err)
+	assertUser(t, user, got)
+
+	assert.ErrorIs(t, repo.UpdateUser(ctx, newUser(userID3, "vhils", "vhils@example.com")), domain.ErrNotFound)
+	_, err = repo.GetUser(ctx, userID3)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
+
+func testUserIdentities(t *testing.T, repo domain.Repo) {
+	ctx := context.Background()
+	createUser(t, repo, userID1, "banksy", "banksy@example.com")
+
+	identity := domain.UserIdentity{Provider: "google", Subject: "1234", UserID: userID1}
+	require.NoError(t, repo.CreateUserIdentity(ctx, identity))
+
+	got, err := repo.GetUserIdentity(ctx, "google", "1234")
+	require.NoError(t, err)
+	if assert.NotNil(t, got) {
+		assert.Equal(t, identity.Provider, got.Provider)
+		assert.Equal(t, identity.Subject, got.Subject)
+		assert.Equal(t, identity.UserID, got.UserID)
+		assert.False(t, got.CreatedAt.IsZero(), "created at should be set by the repo")
+	}
+
+	got, err = repo.GetUserIdentity(ctx, "github", "1234")
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+
+	assert.ErrorIs(t, repo.CreateUserIdentity(ctx, identity), domain.ErrConflict, "duplicate identity")
+	assert.ErrorIs(t, repo.CreateUserIdentity(ctx, domain.UserIdentity{Provider: "google", Subject: "5678", UserID: userID2}), domain.ErrNotFound, "unknown user")
+}
+
+func testUserMFA(t *testing.T, repo domain.Repo) {
+	ctx := context.Background()
+	createUser(t, repo, userID1, "banksy", "banksy@example.com")
+
+	got, err := repo.GetUserMFA(ctx, userID1)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+
+	require.NoError(t, repo.SaveUserMFA(ctx, domain.UserMFA{UserID: userID1, Secret: "SECRET1"}))
+	created, err := repo.GetUserMFA(ctx, userID1)
+	require.NoError(t, err)
+	require.NotNil(t, created)
+	assert.Equal(t, "SECRET1", created.Secret)
+	assert.False(t, created.Enabled)
+	assert.False(t, created.CreatedAt.IsZero(), "created at should be set by the repo")
+
+	// saving again replaces the settings
+	require.NoError(t, repo.SaveUserMFA(ctx, domain.UserMFA{UserID: userID1, Secret: "SECRET2", Enabled: true, LastUsedStep: 10}))
+	got, err = repo.GetUserMFA(ctx, userID1)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "SECRET2", got.Secret)
+	assert.True(t, got.Enabled)
+	assert.Equal(t, int64(10), got.LastUsedStep)
+	assert.True(t, created.CreatedAt.Equal(got.CreatedAt), "created at should be kept")
+
+	// the last used step only moves forward
+	updated, err := repo.UpdateMFALastUsedStep(ctx, userID1, 10)
+	require.NoError(t, err)
+	assert.False(t, updated)
+
+	updated, err = repo.UpdateMFALastUsedStep(ctx, userID1, 11)
+	require.NoError(t, err)
+	assert.True(t, updated)
+
+	got, err = repo.GetUserMFA(ctx, userID1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), got.LastUsedStep)
+
+	updated, err = repo.UpdateMFALastUsedStep(ctx, userID2, 12)
+	require.NoError(t, err)
+	assert.False(t, updated, "user without mfa")
+
+	assert.ErrorIs(t, repo.SaveUserMFA(ctx, domain.UserMFA{UserID: userID2, Secret: "SECRET"}), domain.ErrNotFound, "unknown user")
+}
+
+func testRecoveryCodes(t *testing.T, repo domain.Repo) {
+	ctx := context.Background()
+	createUser(t, repo, userID1, "banksy", "banksy@example.com")
+	createUser(t, repo, userID2, "blu", "blu@example.com")
+
+	require.NoError(t, repo.ReplaceRecoveryCodes(ctx, userID1, []string{"hash1", "hash2"}))
+	require.NoError(t, repo.ReplaceRecoveryCodes(ctx, userID2, []string{"hash3"}))
+
+	used, err := repo.UseRecoveryCode(ctx, userID1, "hash1")
+	require.NoError(t, err)
+	assert.True(t, used)
+
+	used, err = repo.UseRecoveryCode(ctx, userID1, "hash1")
+	require.NoError(t, err)
+	assert.False(t, used, "codes can only be used once")
+
+	used, err = repo.UseRecoveryCode(ctx, userID1, "hash3")
+	require.NoError(t, err)
+	assert.False(t, used, "codes belong to one user")
+
+	// a failed replace keeps the existing codes
+	assert.ErrorIs(t, repo.ReplaceRecoveryCodes(ctx, userID1, []string{"hash4", "hash4"}), domain.ErrConflict)
+	used, err = repo.UseRecoveryCode(ctx, userID1, "hash2")
+	require.NoError(t, err)
+	assert.True(t, used)
+
+	require.NoError(t, repo.ReplaceRecoveryCodes(ctx, userID1, []string{"hash1"}))
+	used, err = repo.UseRecoveryCode(ctx, userID1, "hash1")
+	require.NoError(t, err)
+	assert.True(t, used, "replaced codes are unused")
+
+	used, err = repo.UseRecoveryCode(ctx, userID2, "hash3")
+	require.NoError(t, err)
+	assert.True(t, used, "other users' codes are untouched")
+
+	assert.ErrorIs(t, repo.ReplaceRecoveryCodes(ctx, userID3, []string{"hash5"}), domain.ErrNotFound, "unknown user")
+}
+
+func newAPIKey(id, userID, hash string, createdAt time.Time) domain.APIKey {
+	return domain.APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      "key " + id,
+		Prefix:    "gb_" + id[:8],
+		Hash:      hash,
+		Scopes:    []string{"pieces:read", "pieces:write"},
+		CreatedAt: createdAt,
+	}
+}
+
+func testAPIKeys(t *testing.T, repo domain.Repo) {
+	ctx := context.Background()
+	createUser(t, repo, userID1, "banksy", "banksy@example.com")
+	createUser(t, repo, userID2, "blu", "blu@example.com")
+
+	// TIMESTAMP columns hold whole seconds
+	now := time.Now().UTC().Truncate(time.Second)
+	key1 := newAPIKey(keyID1, userID1, "hash1", now)
+	key2 := newAPIKey(keyID2, userID1, "hash2", now.Add(-time.Hour))
+	key3 := newAPIKey(keyID3, userID2, "hash3", now)
+	for _, k := range []domain.APIKey{key1, key2, key3} {
+		require.NoError(t, repo.CreateAPIKey(ctx, k))
+	}
+
+	got, err := repo.GetAPIKeyByHash(ctx, "hash1")
+	require.NoError(t, err)
+	if assert.NotNil(t, got) {
+		assert.Equal(t, key1.ID, got.ID)
+		assert.Equal(t, key1.UserID, got.UserID)
+		assert.Equal(t, key1.Name, got.Name)
+		assert.Equal(t, key1.Prefix, got.Prefix)
+		assert.Equal(t, key1.Scopes, got.Scopes)
+		assert.True(t, key1.CreatedAt.Equal(got.CreatedAt))
+		assert.Nil(t, got.LastUsedAt)
+	}
+
+	got, err = repo.GetAPIKeyByHash(ctx, "hash4")
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+
+	keys, err := repo.ListAPIKeys(ctx, userID1)
+	require.NoError(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, keyID2, keys[0].ID, "keys are listed oldest first")
+		assert.Equal(t, keyID1, keys[1].ID)
+	}
+
+	keys, err = repo.ListAPIKeys(ctx, userID3)
+	require.NoError(t, err)
+	assert.NotNil(t, keys, "no keys is an empty list")
+	assert.Empty(t, keys)
+
+	usedAt := now.Add(time.Minute)
+	require.NoError(t, repo.TouchAPIKey(ctx, keyID1, usedAt))
+	got, err = repo.GetAPIKeyByHash(ctx, "hash1")
+	require.NoError(t, err)
+	if assert.NotNil(t, got) && assert.NotNil(t, got.LastUsedAt) {
+		assert.True(t, usedAt.Equal(*got.LastUsedAt))
+	}
+
+	assert.NoError(t, repo.TouchAPIKey(ctx, "unknown", usedAt), "touching an unknown key changes nothing")
+}
+
+func testAPIKeyUniqueConstraints(t *testing.T, repo domain.Repo) {
+	ctx := context.Background()
+	createUser(t, repo, userID1, "banksy", "banksy@example.com")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, repo.CreateAPIKey(ctx, newAPIKey(keyID1, userID1, "hash1", now)))
+
+	assert.ErrorIs(t, repo.CreateAPIKey(ctx, newAPIKey(keyID1, userID1, "hash2", now)), domain.ErrConflict, "duplicate id")
+	assert.ErrorIs(t, repo.CreateAPIKey(ctx, newAPIKey(keyID2, userID1, "hash1", now)), domain.ErrConflict, "duplicate hash")
+	assert.ErrorIs(t, repo.CreateAPIKey(ctx, newAPIKey(keyID3, userID2, "hash3", now)), domain.ErrNotFound, "unknown user")
+}
+
+func testRevokeAPIKey(t *testing.T, repo domain.Repo) {
+	ctx := context.Background()
+	createUser(t, repo, userID1, "banksy", "banksy@example.com")
+	createUser(t, repo, userID2, "blu", "blu@example.com")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	require.NoError(t, repo.CreateAPIKey(ctx, newAPIKey(keyID1, userID1, "hash1", now)))
+
+	revoked, err := repo.RevokeAPIKey(ctx, userID2, keyID1, now)
+	require.NoError(t, err)
+	assert.False(t, revoked, "only the owner can revoke a key")
+
+	revoked, err = repo.RevokeAPIKey(ctx, userID1, keyID1, now)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = repo.RevokeAPIKey(ctx, userID1, keyID1, now)
+	require.NoError(t, err)
+	assert.False(t, revoked, "already revoked")
+
+	_, err = repo.GetAPIKeyByHash(ctx, "hash1")
+	assert.ErrorIs(t, err, domain.ErrNotFound, "revoked keys can't be used")
+
+	keys, err := repo.ListAPIKeys(ctx, userID1)
+	require.NoError(t, err)
+	assert.Empty(t, keys, "revoked keys aren't listed")
+}
+
+func testTransactions(t *testing.T, repo domain.Repo) {
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	err := repo.WithTx(ctx, func(tx domain.Repo) error {
+		createUser(t, tx, userID1, "banksy", "banksy@example.com")
+		require.NoError(t, tx.CreateUserIdentity(ctx, domain.UserIdentity{Provider: "google", Subject: "1", UserID: userID1}))
+
+		user, err := tx.GetUser(ctx, userID1)
+		require.NoError(t, err)
+		assert.NotNil(t, user, "writes are visible in the transaction")
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	user, err := repo.GetUser(ctx, userID1)
+	require.NoError(t, err)
+	assert.NotNil(t, user, "committed")
+
+	err = repo.WithTx(ctx, func(tx domain.Repo) error {
+		createUser(t, tx, userID2, "blu", "blu@example.com")
+		require.NoError(t, tx.CreateUserIdentity(ctx, domain.UserIdentity{Provider: "google", Subject: "2", UserID: userID2}))
+		require.NoError(t, tx.ReplaceRecoveryCodes(ctx, userID1, []string{"hash1"}))
+
+		return errAbort
+	})
+	assert.Equal(t, errAbort, err)
+
+	user, err = repo.GetUser(ctx, userID2)
+	assert.ErrorIs(t, err, domain.ErrNotFound, "rolled back")
+
+	_, err = repo.GetUserIdentity(ctx, "google", "2")
+	assert.ErrorIs(t, err, domain.ErrNotFound, "rolled back")
+
+	used, err := repo.UseRecoveryCode(ctx, userID1, "hash1")
+	require.NoError(t, err)
+	assert.False(t, used, "rolled back")
+
+	err = repo.WithTx(ctx, func(tx domain.Repo) error {
+		createUser(t, tx, userID2, "blu", "blu@example.com")
+
+		return tx.WithTx(ctx, func(nested domain.Repo) error {
+			// a duplicate user name
+			return nested.CreateUser(ctx, newUser(userID3, "BLU", "blu3@example.com"))
+		})
+	})
+	assert.ErrorIs(t, err, domain.ErrConflict)
+
+	user, err = repo.GetUser(ctx, userID2)
+	assert.ErrorIs(t, err, domain.ErrNotFound, "a nested transaction joins the outer one, so its failure rolls back everything")
+}
@@ -3,6 +3,9 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
 	"github.com/sirupsen/logrus"
@@ -11,19 +14,59 @@ import (
 const componentSQLRepo = "SQLRepo"
 
 type SQLRepo struct {
-	db     *sql.DB
+	// db runs the queries, either directly on conn or in tx
+	db     querier
+	conn   *tracedDB
+	tx     *tracedTx
 	logger *logrus.Entry
 }
 
+// NewSQLRepo stores everything in MySQL
 func NewSQLRepo(db *sql.DB, logger *logrus.Logger) *SQLRepo {
+	return newSQLRepo(newTracedDB(db, componentSQLRepo, mysqlDialect), logger)
+}
+
+func newSQLRepo(db *tracedDB, logger *logrus.Logger) *SQLRepo {
 	return &SQLRepo{
 		db:     db,
-		logger: logger.WithField("component", componentSQLRepo),
+		conn:   db,
+		logger: logger.WithField("component", db.tracer.component),
 	}
 }
 
-func (r *SQLRepo) Ping() error {
-	return r.db.Ping()
+// Ping checks a connection to the DB can be made
+func (r *SQLRepo) Ping(ctx context.Context) error {
+	return r.conn.PingContext(ctx)
+}
+
+func (r *SQLRepo) WithTx(ctx context.Context, fn func(tx domain.Repo) error) error {
+	return r.withTx(ctx, func(tx *SQLRepo) error { return fn(tx) })
+}
+
+// withTx lets the repos embedding SQLRepo hand fn themselves, so that their overrides apply in the transaction too
+func (r *SQLRepo) withTx(ctx context.Context, fn func(tx *SQLRepo) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "WithTx").Error("failed to begin transaction")
+		return err
+	}
+	// a no-op once committed, and rolls back if fn panics
+	defer tx.Rollback()
+
+	if err := fn(&SQLRepo{db: tx, conn: r.conn, tx: tx, logger: r.logger}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "WithTx").Error("failed to commit transaction")
+		return err
+	}
+
+	return nil
 }
 
 func (r *SQLRepo) CreateUser(ctx context.Context, user domain.User) error {
@@ -33,7 +76,7 @@ func (r *SQLRepo) CreateUser(ctx context.Context, user domain.User) error {
 		user.ID, user.Attributes.UserName, user.Attributes.Email, user.Password,
 	)
 	if err != nil {
-		r.logger.WithError(err).WithField("method", "CreateUser").Error("failed to create user")
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "CreateUser").Error("failed to create user")
 		return err
 	}
 
@@ -41,70 +84,408 @@ func (r *SQLRepo) CreateUser(ctx context.Context, user domain.User) error {
 }
 
 func (r *SQLRepo) GetUser(ctx context.Context, userID string) (*domain.User, error) {
-	user := domain.User{}
-	err := r.db.QueryRowContext(
+	user, err := scanUser(r.db.QueryRowContext(
 		ctx,
-		`SELECT id, user_name, email, password FROM users WHERE id = ?`,
+		`SELECT id, user_name, email, password, version, created_at, updated_at FROM users WHERE id = ?`,
 		userID,
-	).Scan(
-		&user.ID, &user.Attributes.UserName, &user.Attributes.Email, &user.Password,
+	))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
+	} else if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "GetUser").Error("failed to get user")
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// UpdateUser compares and swaps the version in one statement. Bumping the version means a matching row always changes,
+// so MySQL, which only counts changed rows, still reports it as affected
+func (r *SQLRepo) UpdateUser(ctx context.Context, user domain.User) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE users SET user_name = ?, email = ?, password = ?, version = version + 1 WHERE id = ? AND version = ?`,
+		user.Attributes.UserName, user.Attributes.Email, user.Password, user.ID, user.Version,
 	)
 	if err != nil {
-		r.logger.WithError(err).WithField("method", "GetUser").Error("failed to get user")
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "UpdateUser").Error("failed to update user")
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if n == 1 {
+		return nil
+	}
+
+	// nothing was updated so either the user doesn't exist or another update got there first
+	var version int64
+	err = r.db.QueryRowContext(ctx, `SELECT version FROM users WHERE id = ?`, user.ID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return domain.ErrNotFound
+	} else if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "UpdateUser").Error("failed to get user version")
+		return err
+	}
+
+	return fmt.Errorf("%w: user %s is at version %d not %d", domain.ErrVersionMismatch, user.ID, version, user.Version)
+}
+
+func (r *SQLRepo) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	user, err := scanUser(r.db.QueryRowContext(
+		ctx,
+		`SELECT id, user_name, email, password, version, created_at, updated_at FROM users WHERE email = ?`,
+		email,
+	))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
+	} else if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "GetUserByEmail").Error("failed to get user")
 		return nil, err
 	}
 
-	return &user, nil
+	return user, nil
 }
 
-func (r *SQLRepo) UpdateUser(ctx context.Context, user domain.User) error {
+func (r *SQLRepo) GetUserByUserName(ctx context.Context, username string) (*domain.User, error) {
+	user, err := scanUser(r.db.QueryRowContext(
+		ctx,
+		`SELECT id, user_name, email, password, version, created_at, updated_at FROM users WHERE user_name = ?`,
+		username,
+	))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
+	} else if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "GetUserByUserName").Error("failed to get user")
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *SQLRepo) GetUserIdentity(ctx context.Context, provider, subject string) (*domain.UserIdentity, error) {
+	identity := domain.UserIdentity{}
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT provider, subject, user_id, created_at FROM user_identities WHERE provider = ? AND subject = ?`,
+		provider, subject,
+	).Scan(
+		&identity.Provider, &identity.Subject, &identity.UserID, &identity.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
+	} else if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "GetUserIdentity").Error("failed to get user identity")
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (r *SQLRepo) CreateUserIdentity(ctx context.Context, identity domain.UserIdentity) error {
 	_, err := r.db.ExecContext(
 		ctx,
-		`UPDATE users SET user_name = ?, email = ?, password = ? WHERE id = ?`,
-		user.Attributes.UserName, user.Attributes.Email, user.Password, user.ID,
+		`INSERT INTO user_identities (provider, subject, user_id) VALUES (?, ?, ?)`,
+		identity.Provider, identity.Subject, identity.UserID,
 	)
 	if err != nil {
-		r.logger.WithError(err).WithField("method", "UpdateUser").Error("failed to update user")
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "CreateUserIdentity").Error("failed to create user identity")
 		return err
 	}
 
 	return nil
 }
 
-func (r *SQLRepo) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
-	user := domain.User{}
+func (r *SQLRepo) GetLoginAttempts(ctx context.Context, key string) (*domain.LoginAttempts, error) {
+	attempts := domain.LoginAttempts{}
 	err := r.db.QueryRowContext(
 		ctx,
-		`SELECT id, user_name, email, password FROM users WHERE email = ?`,
-		email,
+		`SELECT attempt_key, failures, last_failure_at FROM login_attempts WHERE attempt_key = ?`,
+		key,
 	).Scan(
-		&user.ID, &user.Attributes.UserName, &user.Attributes.Email, &user.Password,
+		&attempts.Key, &attempts.Failures, &attempts.LastFailureAt,
 	)
-	if err != nil && err != sql.ErrNoRows {
+	if err == sql.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
-		r.logger.WithError(err).WithField("method", "GetUserByEmail").Error("failed to get user")
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "GetLoginAttempts").Error("failed to get login attempts")
 		return nil, err
 	}
 
-	return &user, nil
+	return &attempts, nil
 }
 
-func (r *SQLRepo) GetUserByUserName(ctx context.Context, username string) (*domain.User, error) {
-	user := domain.User{}
+// IncrementFailedLogins upserts in a single statement so that concurrent failures are all counted
+func (r *SQLRepo) IncrementFailedLogins(ctx context.Context, key string, at time.Time, window time.Duration) (*domain.LoginAttempts, error) {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO login_attempts (attempt_key, failures, last_failure_at) VALUES (?, 1, ?)
+		ON DUPLICATE KEY UPDATE
+			failures = IF(last_failure_at < ?, 1, failures + 1),
+			last_failure_at = VALUES(last_failure_at)`,
+		key, at, at.Add(-window),
+	)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "IncrementFailedLogins").Error("failed to increment failed logins")
+		return nil, err
+	}
+
+	return r.GetLoginAttempts(ctx, key)
+}
+
+// incrementFailedLoginsOnConflict is IncrementFailedLogins for databases that upsert with ON CONFLICT.
+// Times are converted to UTC as SQLite stores them as text, which only compares correctly in the same zone
+func (r *SQLRepo) incrementFailedLoginsOnConflict(ctx context.Context, key string, at time.Time, window time.Duration) (*domain.LoginAttempts, error) {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO login_attempts (attempt_key, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT (attempt_key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = excluded.last_failure_at`,
+		key, at.UTC(), at.Add(-window).UTC(),
+	)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "IncrementFailedLogins").Error("failed to increment failed logins")
+		return nil, err
+	}
+
+	return r.GetLoginAttempts(ctx, key)
+}
+
+func (r *SQLRepo) ResetLoginAttempts(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE attempt_key = ?`, key)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "ResetLoginAttempts").Error("failed to reset login attempts")
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLRepo) GetUserMFA(ctx context.Context, userID string) (*domain.UserMFA, error) {
+	mfa := domain.UserMFA{}
 	err := r.db.QueryRowContext(
 		ctx,
-		`SELECT id, user_name, email, password FROM users WHERE user_name = ?`,
-		username,
+		`SELECT user_id, secret, enabled, last_used_step, created_at FROM user_mfa WHERE user_id = ?`,
+		userID,
 	).Scan(
-		&user.ID, &user.Attributes.UserName, &user.Attributes.Email, &user.Password,
+		&mfa.UserID, &mfa.Secret, &mfa.Enabled, &mfa.LastUsedStep, &mfa.CreatedAt,
 	)
-	if err != nil && err != sql.ErrNoRows {
-		return nil, nil
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
+	} else if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "GetUserMFA").Error("failed to get user mfa")
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+func (r *SQLRepo) SaveUserMFA(ctx context.Context, mfa domain.UserMFA) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO user_mfa (user_id, secret, enabled, last_used_step) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE secret = VALUES(secret), enabled = VALUES(enabled), last_used_step = VALUES(last_used_step)`,
+		mfa.UserID, mfa.Secret, mfa.Enabled, mfa.LastUsedStep,
+	)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "SaveUserMFA").Error("failed to save user mfa")
+		return err
+	}
+
+	return nil
+}
+
+// saveUserMFAOnConflict is SaveUserMFA for databases that upsert with ON CONFLICT
+func (r *SQLRepo) saveUserMFAOnConflict(ctx context.Context, mfa domain.UserMFA) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO user_mfa (user_id, secret, enabled, last_used_step) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, enabled = excluded.enabled, last_used_step = excluded.last_used_step`,
+		mfa.UserID, mfa.Secret, mfa.Enabled, mfa.LastUsedStep,
+	)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "SaveUserMFA").Error("failed to save user mfa")
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLRepo) UpdateMFALastUsedStep(ctx context.Context, userID string, step int64) (bool, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE user_mfa SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`,
+		step, userID, step,
+	)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "UpdateMFALastUsedStep").Error("failed to update last used step")
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (r *SQLRepo) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	return r.withTx(ctx, func(tx *SQLRepo) error {
+		if _, err := tx.db.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID); err != nil {
+			r.logger.WithContext(ctx).WithError(err).WithField("method", "ReplaceRecoveryCodes").Error("failed to delete recovery codes")
+			return err
+		}
+
+		for _, h := range codeHashes {
+			if _, err := tx.db.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, h); err != nil {
+				r.logger.WithContext(ctx).WithError(err).WithField("method", "ReplaceRecoveryCodes").Error("failed to insert recovery code")
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *SQLRepo) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		userID, codeHash,
+	)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "UseRecoveryCode").Error("failed to use recovery code")
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (r *SQLRepo) CreateAPIKey(ctx context.Context, key domain.APIKey) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		key.ID, key.UserID, key.Name, key.Prefix, key.Hash, strings.Join(key.Scopes, " "), key.CreatedAt,
+	)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "CreateAPIKey").Error("failed to create api key")
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLRepo) GetAPIKeyByHash(ctx context.Context, keyHash string) (*domain.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRowContext(
+		ctx,
+		`SELECT id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL`,
+		keyHash,
+	))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrNotFound
 	} else if err != nil {
-		r.logger.WithError(err).WithField("method", "GetUserByEmail").Error("failed to get user")
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "GetAPIKeyByHash").Error("failed to get api key")
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (r *SQLRepo) ListAPIKeys(ctx context.Context, userID string) ([]domain.APIKey, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at FROM api_keys
+		WHERE user_id = ? AND revoked_at IS NULL ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "ListAPIKeys").Error("failed to list api keys")
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []domain.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			r.logger.WithContext(ctx).WithError(err).WithField("method", "ListAPIKeys").Error("failed to scan api key")
+			return nil, err
+		}
+
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (r *SQLRepo) RevokeAPIKey(ctx context.Context, userID, keyID string, at time.Time) (bool, error) {
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`,
+		at, keyID, userID,
+	)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "RevokeAPIKey").Error("failed to revoke api key")
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (r *SQLRepo) TouchAPIKey(ctx context.Context, keyID string, at time.Time) error {
+	if _, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = ? WHERE id = ?`, at, keyID); err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("method", "TouchAPIKey").Error("failed to update api key last used")
+		return err
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans the columns id, user_name, email, password, version, created_at, updated_at
+func scanUser(row rowScanner) (*domain.User, error) {
+	var user domain.User
+	err := row.Scan(
+		&user.ID, &user.Attributes.UserName, &user.Attributes.Email, &user.Password, &user.Version, &user.CreatedAt, &user.ModifiedAt,
+	)
+	if err != nil {
 		return nil, err
 	}
 
 	return &user, nil
 }
+
+func scanAPIKey(row rowScanner) (*domain.APIKey, error) {
+	var (
+		key        domain.APIKey
+		scopes     string
+		lastUsedAt sql.NullTime
+	)
+
+	if err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.CreatedAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/db"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/migrate"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/repo/repotest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// testMySQLDSNEnv names a MySQL database the tests may empty, e.g. root:simple@tcp(localhost:3306)/graffiti_test?parseTime=true
+const testMySQLDSNEnv = "TEST_MYSQL_DSN"
+
+// tables in the order they can be emptied without breaking foreign keys
+var userTables = []string{"api_keys", "mfa_recovery_codes", "user_mfa", "user_identities", "users"}
+
+func TestSQLRepo_conformance(t *testing.T) {
+	dsn := os.Getenv(testMySQLDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", testMySQLDSNEnv)
+	}
+
+	sqlDB, err := sql.Open("mysql", dsn)
+	require.NoError(t, err)
+	defer sqlDB.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	migrations, err := migrate.Load(db.MySQLMigrations())
+	require.NoError(t, err)
+	_, err = migrate.New(sqlDB, migrate.NewMySQL("repo-test", time.Minute), migrations, logger).Up(context.Background())
+	require.NoError(t, err)
+
+	repotest.Run(t, func(t *testing.T) domain.Repo {
+		for _, table := range userTables {
+			_, err := sqlDB.Exec("DELETE FROM " + table)
+			require.NoError(t, err)
+		}
+
+		return NewSQLRepo(sqlDB, logger)
+	})
+}
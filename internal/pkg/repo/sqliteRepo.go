@@ -0,0 +1,58 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/sirupsen/logrus"
+
+	// registers the pure Go sqlite driver, so the binary needn't be built with cgo
+	_ "modernc.org/sqlite"
+)
+
+const (
+	componentSQLiteRepo = "SQLiteRepo"
+
+	// how long a connection waits for another to finish writing before giving up
+	sqliteBusyTimeout = 5 * time.Second
+)
+
+// OpenSQLite opens the database file at path, creating it if need be, with foreign keys enforced.
+// Writes go to a write-ahead log so that reads aren't blocked by them. Transactions take the write lock as they begin,
+// as one that reads before writing would otherwise fail if another had written in the meantime
+func OpenSQLite(path string) (*sql.DB, error) {
+	params := url.Values{"_txlock": {"immediate"}}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", sqliteBusyTimeout.Milliseconds()))
+	params.Add("_pragma", "journal_mode(WAL)")
+
+	return sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+}
+
+// SQLiteRepo stores everything in a single SQLite file, for single node deployments.
+// SQLite understands the same SQL as MySQL apart from upserts, so only the methods that upsert are overridden.
+// There are no piece queries yet, so the bounding box queries on lat/lon that stand in for spatial ones are still to do
+type SQLiteRepo struct {
+	*SQLRepo
+}
+
+// NewSQLiteRepo expects foreign keys to have been enabled for every connection of db, as SQLite leaves them off
+func NewSQLiteRepo(db *sql.DB, logger *logrus.Logger) *SQLiteRepo {
+	return &SQLiteRepo{SQLRepo: newSQLRepo(newTracedDB(db, componentSQLiteRepo, sqliteDialect), logger)}
+}
+
+func (r *SQLiteRepo) WithTx(ctx context.Context, fn func(tx domain.Repo) error) error {
+	return r.withTx(ctx, func(tx *SQLRepo) error { return fn(&SQLiteRepo{SQLRepo: tx}) })
+}
+
+func (r *SQLiteRepo) IncrementFailedLogins(ctx context.Context, key string, at time.Time, window time.Duration) (*domain.LoginAttempts, error) {
+	return r.incrementFailedLoginsOnConflict(ctx, key, at, window)
+}
+
+func (r *SQLiteRepo) SaveUserMFA(ctx context.Context, mfa domain.UserMFA) error {
+	return r.saveUserMFAOnConflict(ctx, mfa)
+}
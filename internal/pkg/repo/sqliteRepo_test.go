@@ -0,0 +1,65 @@
+package repo
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OJOMB/graffiti-berlin-svc/db"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/domain"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/migrate"
+	"github.com/OJOMB/graffiti-berlin-svc/internal/pkg/repo/repotest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSQLiteRepo(t *testing.T) *SQLiteRepo {
+	sqlDB, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	migrations, err := migrate.Load(db.SQLiteMigrations())
+	require.NoError(t, err)
+	_, err = migrate.New(sqlDB, migrate.NewSQLite(), migrations, logger).Up(context.Background())
+	require.NoError(t, err)
+
+	return NewSQLiteRepo(sqlDB, logger)
+}
+
+func TestSQLiteRepo_conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) domain.Repo {
+		return newTestSQLiteRepo(t)
+	})
+}
+
+func TestSQLiteRepo_loginAttempts(t *testing.T) {
+	repo := newTestSQLiteRepo(t)
+	ctx := context.Background()
+	now := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	a, err := repo.GetLoginAttempts(ctx, "ip:10.0.0.1")
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+
+	repo.IncrementFailedLogins(ctx, "ip:10.0.0.1", now, time.Minute)
+	a, err = repo.IncrementFailedLogins(ctx, "ip:10.0.0.1", now.Add(time.Second), time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 2, a.Failures)
+	assert.True(t, now.Add(time.Second).Equal(a.LastFailureAt))
+
+	// failures older than the window are forgotten
+	a, err = repo.IncrementFailedLogins(ctx, "ip:10.0.0.1", now.Add(2*time.Minute), time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, a.Failures)
+
+	require.NoError(t, repo.ResetLoginAttempts(ctx, "ip:10.0.0.1"))
+	a, err = repo.GetLoginAttempts(ctx, "ip:10.0.0.1")
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+}
@@ -0,0 +1,155 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/OJOMB/graffiti-berlin-svc/internal/pkg/repo"
+
+// tracedDB starts a span for each query run through it. Spans cover running the query, not reading the rows it returns.
+// Queries are written with ? placeholders and rebound for the dialect, and errors from writes are mapped by it
+type tracedDB struct {
+	db      *sql.DB
+	tracer  *queryTracer
+	dialect sqlDialect
+}
+
+// queryTracer names spans after the repo and tags them with the database system, e.g. semconv.DBSystemMySQL
+type queryTracer struct {
+	tracer    trace.Tracer
+	component string
+	system    attribute.KeyValue
+}
+
+func newTracedDB(db *sql.DB, component string, dialect sqlDialect) *tracedDB {
+	return &tracedDB{
+		db:      db,
+		tracer:  &queryTracer{tracer: otel.Tracer(tracerName), component: component, system: dialect.system},
+		dialect: dialect,
+	}
+}
+
+func (t *tracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	query = t.dialect.rebind(query)
+	ctx, span := t.tracer.start(ctx, query)
+	res, err := t.db.ExecContext(ctx, query, args...)
+	endQuerySpan(span, err)
+
+	return res, t.dialect.mapError(err)
+}
+
+func (t *tracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	query = t.dialect.rebind(query)
+	ctx, span := t.tracer.start(ctx, query)
+	rows, err := t.db.QueryContext(ctx, query, args...)
+	endQuerySpan(span, err)
+
+	return rows, err
+}
+
+func (t *tracedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	query = t.dialect.rebind(query)
+	ctx, span := t.tracer.start(ctx, query)
+	row := t.db.QueryRowContext(ctx, query, args...)
+	endQuerySpan(span, row.Err())
+
+	return row
+}
+
+func (t *tracedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*tracedTx, error) {
+	tx, err := t.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracedTx{tx: tx, tracer: t.tracer, dialect: t.dialect}, nil
+}
+
+func (t *tracedDB) PingContext(ctx context.Context) error {
+	return t.db.PingContext(ctx)
+}
+
+// querier is implemented by both tracedDB and tracedTx, so that queries are written once whether or not they run in a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// tracedTx starts a span for each query run in the transaction
+type tracedTx struct {
+	tx      *sql.Tx
+	tracer  *queryTracer
+	dialect sqlDialect
+}
+
+func (t *tracedTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	query = t.dialect.rebind(query)
+	ctx, span := t.tracer.start(ctx, query)
+	res, err := t.tx.ExecContext(ctx, query, args...)
+	endQuerySpan(span, err)
+
+	return res, t.dialect.mapError(err)
+}
+
+func (t *tracedTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	query = t.dialect.rebind(query)
+	ctx, span := t.tracer.start(ctx, query)
+	rows, err := t.tx.QueryContext(ctx, query, args...)
+	endQuerySpan(span, err)
+
+	return rows, err
+}
+
+func (t *tracedTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	query = t.dialect.rebind(query)
+	ctx, span := t.tracer.start(ctx, query)
+	row := t.tx.QueryRowContext(ctx, query, args...)
+	endQuerySpan(span, row.Err())
+
+	return row
+}
+
+func (t *tracedTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *tracedTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// start records the statement, which only ever holds placeholders, never the values
+func (t *queryTracer) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	operation := query
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+
+	return t.tracer.Start(
+		ctx,
+		t.component+" "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			t.system,
+			semconv.DBOperationKey.String(operation),
+			semconv.DBStatementKey.String(query),
+		),
+	)
+}
+
+func endQuerySpan(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
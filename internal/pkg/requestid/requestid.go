@@ -0,0 +1,42 @@
+// Package requestid carries the ID of the request being handled through the context, so every layer can log it
+package requestid
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+const logFieldRequestID = "request_id"
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, or an empty string if there isn't one
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// LogHook adds the request ID to log entries created with WithContext
+type LogHook struct{}
+
+func (LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (LogHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+
+	if id := FromContext(entry.Context); id != "" {
+		entry.Data[logFieldRequestID] = id
+	}
+
+	return nil
+}
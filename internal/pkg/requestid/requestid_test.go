@@ -0,0 +1,38 @@
+package requestid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContext(t *testing.T) {
+	assert.Equal(t, "", FromContext(context.Background()))
+	assert.Equal(t, "V1StGXR8_Z5jdHi6B-myT", FromContext(NewContext(context.Background(), "V1StGXR8_Z5jdHi6B-myT")))
+}
+
+func TestLogHook(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(LogHook{})
+
+	logger.WithContext(NewContext(context.Background(), "V1StGXR8_Z5jdHi6B-myT")).Info("with request ID")
+	logger.WithContext(context.Background()).Info("without request ID")
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	var withID, withoutID map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[0], &withID))
+	require.NoError(t, json.Unmarshal(lines[1], &withoutID))
+
+	assert.Equal(t, "V1StGXR8_Z5jdHi6B-myT", withID[logFieldRequestID])
+	assert.NotContains(t, withoutID, logFieldRequestID)
+}
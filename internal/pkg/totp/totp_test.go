@@ -0,0 +1,95 @@
+package totp
+
+import (
+	"bytes"
+	"encoding/base32"
+	"image/png"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc6238Secret is the SHA1 test seed from RFC 6238 Appendix B
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode_rfc6238TestVectors(t *testing.T) {
+	tool := NewTool("graffiti-berlin-svc", 1)
+
+	// RFC 6238 lists 8 digit codes, we use the last 6
+	testCases := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tc := range testCases {
+		code, err := tool.Code(rfc6238Secret, time.Unix(tc.unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tool := NewTool("graffiti-berlin-svc", 1)
+	at := time.Unix(1111111111, 0)
+
+	step, ok := tool.Validate(rfc6238Secret, "050471", at)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1111111111/30), step)
+
+	// previous step is within the allowed skew
+	step, ok = tool.Validate(rfc6238Secret, "050471", at.Add(30*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1111111111/30), step)
+
+	_, ok = tool.Validate(rfc6238Secret, "050471", at.Add(90*time.Second))
+	assert.False(t, ok)
+
+	_, ok = tool.Validate(rfc6238Secret, "12345", at)
+	assert.False(t, ok)
+
+	_, ok = tool.Validate("not base32!", "050471", at)
+	assert.False(t, ok)
+}
+
+func TestNewSecretRoundTrip(t *testing.T) {
+	tool := NewTool("graffiti-berlin-svc", 0)
+
+	secret, err := tool.NewSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	now := time.Now()
+	code, err := tool.Code(secret, now)
+	require.NoError(t, err)
+
+	_, ok := tool.Validate(secret, code, now)
+	assert.True(t, ok)
+}
+
+func TestURIAndQRCode(t *testing.T) {
+	tool := NewTool("graffiti-berlin-svc", 1)
+
+	uri, err := url.Parse(tool.URI("test@example.com", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/graffiti-berlin-svc:test@example.com", uri.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	assert.Equal(t, "graffiti-berlin-svc", uri.Query().Get("issuer"))
+
+	pngBytes, err := tool.QRCodePNG("test@example.com", "JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(pngBytes))
+	require.NoError(t, err)
+	assert.Equal(t, 256, img.Bounds().Dx())
+}
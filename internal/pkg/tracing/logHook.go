@@ -0,0 +1,34 @@
+package tracing
+
+import (
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	logFieldTraceID = "trace_id"
+	logFieldSpanID  = "span_id"
+)
+
+// LogHook adds the trace and span IDs to log entries created with WithContext, so logs can be found from a trace
+type LogHook struct{}
+
+func (LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (LogHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+
+	spanCtx := trace.SpanContextFromContext(entry.Context)
+	if !spanCtx.IsValid() {
+		return nil
+	}
+
+	entry.Data[logFieldTraceID] = spanCtx.TraceID().String()
+	entry.Data[logFieldSpanID] = spanCtx.SpanID().String()
+
+	return nil
+}
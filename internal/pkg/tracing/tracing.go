@@ -0,0 +1,63 @@
+// Package tracing configures OpenTelemetry tracing for the service
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+)
+
+const (
+	// ExporterOTLP sends spans to a collector over OTLP/HTTP, configured by the standard OTEL_EXPORTER_OTLP_* env vars
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes spans to stdout for local runs
+	ExporterStdout = "stdout"
+	// ExporterNone disables tracing
+	ExporterNone = "none"
+)
+
+// Setup registers a global tracer provider sending spans to the named exporter, and W3C trace context propagation.
+// The returned func flushes any buffered spans and should be called before the service exits
+func Setup(ctx context.Context, exporter, serviceName, version string, stdout io.Writer) (func(context.Context) error, error) {
+	// propagate trace context even when not exporting so that traces aren't broken by passing through the service
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(stdout))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %v", exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(serviceName),
+		semconv.ServiceVersionKey.String(version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
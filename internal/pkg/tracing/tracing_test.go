@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestSetup_stdout(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+
+	var out bytes.Buffer
+	shutdown, err := Setup(context.Background(), ExporterStdout, "test-svc", "v1.2.3", &out)
+	require.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test-span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	assert.Contains(t, out.String(), `"Name":"test-span"`)
+	assert.Contains(t, out.String(), `"Value":"test-svc"`)
+	assert.Contains(t, out.String(), `"Value":"v1.2.3"`)
+
+	// the W3C traceparent header is understood
+	assert.Contains(t, otel.GetTextMapPropagator().Fields(), "traceparent")
+}
+
+func TestSetup_unknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), "zipkin", "test-svc", "v1.2.3", nil)
+	assert.EqualError(t, err, `unknown trace exporter "zipkin"`)
+}
+
+func TestLogHook(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(LogHook{})
+
+	// a remote parent, as propagated through the traceparent header
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(ctx, "test-span")
+	defer span.End()
+
+	logger.WithContext(ctx).Info("with trace")
+	logger.Info("without trace")
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	var withTrace, withoutTrace map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[0], &withTrace))
+	require.NoError(t, json.Unmarshal(lines[1], &withoutTrace))
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", withTrace[logFieldTraceID])
+	assert.Equal(t, span.SpanContext().SpanID().String(), withTrace[logFieldSpanID])
+	assert.NotContains(t, withoutTrace, logFieldTraceID)
+}